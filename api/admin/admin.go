@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+)
+
+type Controller struct {
+	//written as source of request events
+	InstanceID string
+
+	DataProvider data.DataProvider
+}
+
+func (controller *Controller) HandleListEvents(c *fiber.Ctx) error {
+	events, err := controller.DataProvider.EventList(c.Params("id"))
+	if err != nil {
+		log.Printf("EventList error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(events)
+}
+
+// states are published by Maker, so they can be behind by its sync interval
+func (controller *Controller) HandleListServers(c *fiber.Ctx) error {
+	servers, err := controller.DataProvider.ServerList()
+	if err != nil {
+		log.Printf("ServerList error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	return c.JSON(servers)
+}
+
+func (controller *Controller) HandleListDeadLetter(c *fiber.Ctx) error {
+	IDs, err := controller.DataProvider.DeadLetterList()
+	if err != nil {
+		log.Printf("DeadLetterList error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	result := []common.RequestBody{}
+	for _, ID := range IDs {
+		request, err := controller.DataProvider.Get(ID)
+		if err != nil {
+			log.Printf("GetClientRequest error: %v", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		//expired requests are listed only by ID
+		if request == nil {
+			request = &common.RequestBody{ID: ID}
+		}
+
+		result = append(result, *request)
+	}
+
+	return c.JSON(result)
+}
+
+func (controller *Controller) HandleReplayDeadLetter(c *fiber.Ctx) error {
+	ID := c.Params("id")
+	removed, err := controller.DataProvider.DeadLetterRemove(ID)
+	if err != nil {
+		log.Printf("DeadLetterRemove error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	if !removed {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	request, err := controller.DataProvider.Get(ID)
+	if err != nil {
+		log.Printf("GetClientRequest error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	//client already created new request or record expired, nothing to replay
+	if request == nil || request.Status != common.FAILED {
+		log.Printf("Dropped request %v from dead-letter queue, it's not failed anymore", ID)
+		return c.SendStatus(fiber.StatusConflict)
+	}
+
+	err = controller.replayRequest(*request)
+	if err != nil {
+		log.Printf("ReplayRequest error: %v", err)
+		//keep request in dead-letter queue, so it can be replayed again
+		controller.DataProvider.DeadLetterPush(ID)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	log.Printf("Replayed request %v from dead-letter queue", ID)
+	data.LogEvent(controller.DataProvider, controller.InstanceID, ID, common.RequestEvent{Type: common.EVENT_REPLAYED})
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// replayed request gets all attempts again
+func (controller *Controller) replayRequest(failed common.RequestBody) error {
+	request := common.RequestBody{ID: failed.ID, Status: common.CREATED, Priority: failed.Priority}
+	_, err := controller.DataProvider.Set(request)
+	if err != nil {
+		return err
+	}
+
+	err = controller.DataProvider.ListPush(request.ID, request.Priority, 0)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+	"github.com/stretchr/testify/assert"
+)
+
+func createApp(dataProvider data.DataProvider) *fiber.App {
+	controller := Controller{DataProvider: dataProvider}
+
+	app := fiber.New()
+	app.Get("/admin/dead-letter", controller.HandleListDeadLetter)
+	app.Post("/admin/dead-letter/:id/replay", controller.HandleReplayDeadLetter)
+	app.Get("/admin/requests/:id/events", controller.HandleListEvents)
+	app.Get("/admin/servers", controller.HandleListServers)
+
+	return app
+}
+
+func TestListDeadLetter(t *testing.T) {
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	failed := common.RequestBody{ID: "client1", Status: common.FAILED, Attempts: 3, Reason: "no capacity"}
+	_, err := dataProvider.Set(failed)
+	assert.NoError(t, err)
+	assert.NoError(t, dataProvider.DeadLetterPush("client1"))
+	//record of this request is expired
+	assert.NoError(t, dataProvider.DeadLetterPush("client2"))
+
+	httpRequest, err := http.NewRequest("GET", "/admin/dead-letter", nil)
+	assert.NoError(t, err)
+
+	response, err := createApp(dataProvider).Test(httpRequest)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, fiber.StatusOK, response.StatusCode)
+
+	result := []common.RequestBody{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+	assert.Equal(t, []common.RequestBody{failed, {ID: "client2"}}, result)
+}
+
+type ReplayDeadLetterArgs struct {
+	request    *common.RequestBody
+	deadLetter bool
+}
+
+type ReplayDeadLetterWant struct {
+	code   int
+	status string
+	queued bool
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		args ReplayDeadLetterArgs
+		want ReplayDeadLetterWant
+	}{
+		{
+			name: "replay failed",
+			args: ReplayDeadLetterArgs{
+				request:    &common.RequestBody{ID: "client1", Status: common.FAILED, Attempts: 3, Reason: "no capacity", Priority: 5},
+				deadLetter: true,
+			},
+			want: ReplayDeadLetterWant{
+				code:   fiber.StatusAccepted,
+				status: common.CREATED,
+				queued: true,
+			},
+		},
+		{
+			name: "not in dead-letter queue",
+			args: ReplayDeadLetterArgs{
+				request:    &common.RequestBody{ID: "client1", Status: common.FAILED},
+				deadLetter: false,
+			},
+			want: ReplayDeadLetterWant{
+				code:   fiber.StatusNotFound,
+				status: common.FAILED,
+			},
+		},
+		{
+			name: "already requeued by client",
+			args: ReplayDeadLetterArgs{
+				request:    &common.RequestBody{ID: "client1", Status: common.IN_PROGRESS},
+				deadLetter: true,
+			},
+			want: ReplayDeadLetterWant{
+				code:   fiber.StatusConflict,
+				status: common.IN_PROGRESS,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+			_, err := dataProvider.Set(*test.args.request)
+			assert.NoError(t, err)
+			if test.args.deadLetter {
+				assert.NoError(t, dataProvider.DeadLetterPush(test.args.request.ID))
+			}
+
+			httpRequest, err := http.NewRequest("POST", "/admin/dead-letter/client1/replay", nil)
+			assert.NoError(t, err)
+
+			response, err := createApp(dataProvider).Test(httpRequest)
+			assert.NoError(t, err)
+			defer response.Body.Close()
+			assert.Equal(t, test.want.code, response.StatusCode)
+
+			request := datatest.GetRequest(t, dataProvider, "client1")
+			if assert.NotNil(t, request) {
+				assert.Equal(t, test.want.status, request.Status)
+				assert.Equal(t, test.args.request.Priority, request.Priority)
+			}
+
+			//empty queue can't be verified without blocking
+			if test.want.queued {
+				assert.Equal(t, 0, request.Attempts)
+				assert.Equal(t, []string{"client1"}, datatest.PopN(t, dataProvider, 1))
+
+				events, err := dataProvider.EventList("client1")
+				assert.NoError(t, err)
+				if assert.Len(t, events, 1) {
+					assert.Equal(t, common.EVENT_REPLAYED, events[0].Type)
+				}
+			}
+
+			deadLetter, err := dataProvider.DeadLetterList()
+			assert.NoError(t, err)
+			assert.Empty(t, deadLetter)
+		})
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	events := []common.RequestEvent{
+		{Time: time.Unix(1, 0).UTC(), Type: common.EVENT_CREATED, Source: "api1"},
+		{Time: time.Unix(2, 0).UTC(), Type: common.EVENT_POPPED, Source: "maker1"},
+		{Time: time.Unix(3, 0).UTC(), Type: common.EVENT_RESERVATION, Source: "maker1", Container: "container1", Code: fiber.StatusForbidden},
+	}
+	for _, event := range events {
+		assert.NoError(t, dataProvider.EventPush("client1", event))
+	}
+
+	for _, ID := range []string{"client1", "client2"} {
+		httpRequest, err := http.NewRequest("GET", "/admin/requests/"+ID+"/events", nil)
+		assert.NoError(t, err)
+
+		response, err := createApp(dataProvider).Test(httpRequest)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, fiber.StatusOK, response.StatusCode)
+
+		result := []common.RequestEvent{}
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+		if ID == "client1" {
+			assert.Equal(t, events, result)
+		} else {
+			assert.Empty(t, result)
+		}
+	}
+}
+
+func TestListServers(t *testing.T) {
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	updatedAt := time.Unix(10, 0).UTC()
+	servers := []common.ServerStatus{
+		{ID: "container1", Address: "container1", State: common.SERVER_ALLOCATED, UpdatedAt: updatedAt},
+		{ID: "container2", State: common.SERVER_CREATING, UpdatedAt: updatedAt},
+	}
+	for _, server := range servers {
+		assert.NoError(t, dataProvider.ServerSet(server))
+	}
+
+	httpRequest, err := http.NewRequest("GET", "/admin/servers", nil)
+	assert.NoError(t, err)
+
+	response, err := createApp(dataProvider).Test(httpRequest)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, fiber.StatusOK, response.StatusCode)
+
+	result := []common.ServerStatus{}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+	assert.Equal(t, servers, result)
+}
@@ -1,24 +1,46 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/st-matskevich/go-matchmaker/common"
 )
 
 const CLIENT_ID_CTX_KEY = "client-id"
+const CLIENT_PRIORITY_CTX_KEY = "client-priority"
+const CLIENT_TENANT_CTX_KEY = "client-tenant"
+
+type Identity struct {
+	ID string
+	//one of common.PRIORITY_* range values, e.g. higher for premium players or tournament matches
+	Priority int
+	//game or deployment the client belongs to, empty for single tenant setup
+	Tenant string
+}
 
 type Authorizer interface {
-	Authorize(header string) (id string, err error)
+	Authorize(header string) (identity Identity, err error)
 }
 
 func New(authorizer Authorizer) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		id, err := authorizer.Authorize(authHeader)
+		//fiber reuses header buffers after handler returns, but ID can outlive the request
+		authHeader := utils.CopyString(c.Get("Authorization"))
+		identity, err := authorizer.Authorize(authHeader)
 
 		if err == nil {
-			c.Locals(CLIENT_ID_CTX_KEY, id)
+			c.Locals(CLIENT_ID_CTX_KEY, identity.ID)
+			c.Locals(CLIENT_PRIORITY_CTX_KEY, identity.Priority)
+			c.Locals(CLIENT_TENANT_CTX_KEY, identity.Tenant)
 			return c.Next()
 		}
 
@@ -28,10 +50,103 @@ func New(authorizer Authorizer) fiber.Handler {
 
 type DummyAuthorizer struct{}
 
-func (authorizer *DummyAuthorizer) Authorize(header string) (id string, err error) {
+func (authorizer *DummyAuthorizer) Authorize(header string) (identity Identity, err error) {
 	if header == "" {
-		return "", errors.New("header is empty")
+		return Identity{}, errors.New("header is empty")
+	}
+
+	return Identity{ID: header, Priority: common.PRIORITY_NORMAL}, nil
+}
+
+// TokenAuthorizer accepts only requests with shared token in the header
+type TokenAuthorizer struct {
+	Token string
+}
+
+func (authorizer *TokenAuthorizer) Authorize(header string) (identity Identity, err error) {
+	if authorizer.Token == "" || subtle.ConstantTimeCompare([]byte(header), []byte(authorizer.Token)) != 1 {
+		return Identity{}, errors.New("invalid token")
+	}
+
+	return Identity{ID: "admin", Priority: common.PRIORITY_NORMAL}, nil
+}
+
+// prefix of Authorization header with signed token, token without it is accepted too
+const BEARER_PREFIX = "Bearer "
+
+var ErrInvalidToken = errors.New("invalid client token")
+var ErrTokenExpired = errors.New("client token expired")
+
+// TokenClaims are signed content of client token issued by game backend
+type TokenClaims struct {
+	ClientID string `json:"sub"`
+	Tenant   string `json:"tenant,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	//unix time in ms, token doesn't expire if 0
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// SignedAuthorizer accepts client tokens that game backend signed with shared secret,
+// so client can't choose own ID, tenant or priority. See SignToken
+type SignedAuthorizer struct {
+	Secret []byte
+}
+
+func (authorizer *SignedAuthorizer) Authorize(header string) (identity Identity, err error) {
+	claims, err := ParseToken(authorizer.Secret, strings.TrimPrefix(header, BEARER_PREFIX), time.Now())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{ID: claims.ClientID, Priority: claims.Priority, Tenant: claims.Tenant}, nil
+}
+
+// SignToken returns base64url encoded JSON claims and their HMAC-SHA256 signature separated by dot
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signToken(secret, payload)), nil
+}
+
+// ParseToken verifies signature, expiry and claims of token
+func ParseToken(secret []byte, token string, now time.Time) (TokenClaims, error) {
+	claims := TokenClaims{}
+	if len(secret) == 0 {
+		return claims, ErrInvalidToken
 	}
 
-	return header, nil
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return claims, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signToken(secret, payload)) {
+		return claims, ErrInvalidToken
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.ClientID == "" || claims.Priority < common.PRIORITY_NORMAL || claims.Priority > common.PRIORITY_MAX {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt > 0 && now.UnixMilli() >= claims.ExpiresAt {
+		return claims, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+func signToken(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
 }
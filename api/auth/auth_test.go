@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenAuthorizer(t *testing.T) {
+	authorizer := TokenAuthorizer{Token: "secret"}
+
+	_, err := authorizer.Authorize("secret")
+	assert.NoError(t, err)
+
+	_, err = authorizer.Authorize("secret2")
+	assert.Error(t, err)
+
+	_, err = authorizer.Authorize("")
+	assert.Error(t, err)
+
+	//empty token never matches
+	authorizer = TokenAuthorizer{}
+	_, err = authorizer.Authorize("")
+	assert.Error(t, err)
+}
+
+func TestSignedAuthorizer(t *testing.T) {
+	secret := []byte("secret")
+	authorizer := SignedAuthorizer{Secret: secret}
+
+	token, err := SignToken(secret, TokenClaims{ClientID: "client1", Tenant: "game1", Priority: 5})
+	assert.NoError(t, err)
+	identity, err := authorizer.Authorize(BEARER_PREFIX + token)
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{ID: "client1", Tenant: "game1", Priority: 5}, identity)
+
+	tests := []struct {
+		name   string
+		secret []byte
+		claims TokenClaims
+		err    error
+	}{
+		{name: "other secret", secret: []byte("other"), claims: TokenClaims{ClientID: "client1"}, err: ErrInvalidToken},
+		{name: "expired", secret: secret, claims: TokenClaims{ClientID: "client1", ExpiresAt: time.Now().UnixMilli()}, err: ErrTokenExpired},
+		{name: "no client ID", secret: secret, claims: TokenClaims{Tenant: "game1"}, err: ErrInvalidToken},
+		{name: "priority out of range", secret: secret, claims: TokenClaims{ClientID: "client1", Priority: 11}, err: ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := SignToken(test.secret, test.claims)
+			assert.NoError(t, err)
+			_, err = authorizer.Authorize(token)
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+
+	//empty secret never matches
+	_, err = (&SignedAuthorizer{}).Authorize(token)
+	assert.Error(t, err)
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/st-matskevich/go-matchmaker/api/auth"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type BackendRequestHandlingWant struct {
+	code int
+	body string
+	//join token header of response
+	joinToken string
+	status    string
+	queued    bool
+	events    []string
+}
+
+func TestBackendRequestHandling(t *testing.T) {
+	tests := []struct {
+		name string
+		args RequestHandlingArgs
+		want BackendRequestHandlingWant
+	}{
+		{
+			name: "request NONE",
+			args: RequestHandlingArgs{
+				clientID: "client1",
+				request:  nil,
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusAccepted,
+				status: common.CREATED,
+				queued: true,
+				events: []string{common.EVENT_CREATED},
+			},
+		},
+		{
+			name: "request FAILED",
+			args: RequestHandlingArgs{
+				clientID: "client1",
+				request: &common.RequestBody{
+					ID:     "client1",
+					Status: common.FAILED,
+					Reason: "no capacity",
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusServiceUnavailable,
+				status: common.FAILED,
+				events: []string{common.EVENT_REPORTED},
+			},
+		},
+		{
+			name: "request FAILED reported",
+			args: RequestHandlingArgs{
+				clientID: "client1",
+				request: &common.RequestBody{
+					ID:       "client1",
+					Status:   common.FAILED,
+					Reason:   "no capacity",
+					Reported: true,
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusAccepted,
+				status: common.CREATED,
+				queued: true,
+				events: []string{common.EVENT_CREATED},
+			},
+		},
+		{
+			name: "request IN_PROGRESS",
+			args: RequestHandlingArgs{
+				clientID: "client1",
+				request: &common.RequestBody{
+					ID:     "client1",
+					Status: common.IN_PROGRESS,
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusAccepted,
+				status: common.IN_PROGRESS,
+				events: []string{},
+			},
+		},
+		{
+			name: "request DONE reservation pending",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusOK,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusOK,
+				body:   ":45677",
+				status: common.DONE,
+				events: []string{},
+			},
+		},
+		{
+			name: "request DONE with join token",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusOK,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+					JoinToken:  "token1",
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:      fiber.StatusOK,
+				body:      ":45677",
+				joinToken: "token1",
+				status:    common.DONE,
+				events:    []string{},
+			},
+		},
+		{
+			name: "request DONE reservation not pending",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusNotFound,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusAccepted,
+				status: common.CREATED,
+				queued: true,
+				events: []string{common.EVENT_RESERVATION_LOST, common.EVENT_CREATED},
+			},
+		},
+		{
+			name: "request DONE reservation joined",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusOK,
+				reservationBody: `{"joined": true}`,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+					ExpiresAt:  time.Now().Add(-time.Minute).UnixMilli(),
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusOK,
+				body:   ":45677",
+				status: common.DONE,
+				events: []string{common.EVENT_JOINED},
+			},
+		},
+		{
+			name: "request DONE reservation expired",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusNotFound,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+					ExpiresAt:  time.Now().Add(-time.Minute).UnixMilli(),
+				},
+			},
+			want: BackendRequestHandlingWant{
+				code:   fiber.StatusGone,
+				status: common.FAILED,
+				events: []string{common.EVENT_RESERVATION_EXPIRED},
+			},
+		},
+	}
+
+	for _, backend := range datatest.Backends() {
+		for _, test := range tests {
+			t.Run(backend.Name+" "+test.name, func(t *testing.T) {
+				dataProvider := backend.Create(t)
+				httpMock := web.HTTPClientMock{}
+
+				controller := Controller{
+					DataProvider:     dataProvider,
+					HttpClient:       &httpMock,
+					ImageControlPort: "3000",
+				}
+
+				if test.args.request != nil {
+					_, err := dataProvider.Set(*test.args.request)
+					assert.NoError(t, err)
+				}
+
+				if test.args.reservationCode != 0 {
+					httpResponse := http.Response{StatusCode: test.args.reservationCode, Body: http.NoBody}
+					if test.args.reservationBody != "" {
+						httpResponse.Body = io.NopCloser(strings.NewReader(test.args.reservationBody))
+					}
+					httpMock.On("Do", mock.Anything).Return(&httpResponse, nil).Once()
+				}
+
+				app := fiber.New()
+				app.Post("/request", func(c *fiber.Ctx) error {
+					c.Locals(auth.CLIENT_ID_CTX_KEY, test.args.clientID)
+					return controller.HandleCreateRequest(c)
+				})
+
+				httpRequest, err := http.NewRequest("POST", "/request", nil)
+				assert.NoError(t, err)
+
+				response, err := app.Test(httpRequest)
+				assert.NoError(t, err)
+				defer response.Body.Close()
+
+				assert.Equal(t, test.want.code, response.StatusCode)
+				assert.Equal(t, test.want.joinToken, response.Header.Get(jointoken.HEADER))
+				if test.want.body != "" {
+					bodyBytes, err := io.ReadAll(response.Body)
+					assert.NoError(t, err)
+					assert.Equal(t, test.want.body, string(bodyBytes))
+				}
+
+				request := datatest.GetRequest(t, dataProvider, test.args.clientID)
+				if assert.NotNil(t, request) {
+					assert.Equal(t, test.want.status, request.Status)
+				}
+
+				//empty queue can't be verified without blocking
+				if test.want.queued {
+					assert.Equal(t, []string{test.args.clientID}, datatest.PopN(t, dataProvider, 1))
+				}
+
+				events, err := dataProvider.EventList(test.args.clientID)
+				assert.NoError(t, err)
+				eventTypes := []string{}
+				for _, event := range events {
+					eventTypes = append(eventTypes, event.Type)
+				}
+				assert.Equal(t, test.want.events, eventTypes)
+
+				httpMock.AssertExpectations(t)
+			})
+		}
+	}
+}
+
+func TestBackendQueueBackpressure(t *testing.T) {
+	for _, backend := range datatest.Backends() {
+		t.Run(backend.Name, func(t *testing.T) {
+			dataProvider := backend.Create(t)
+			controller := Controller{
+				DataProvider:        dataProvider,
+				MaxQueueDepth:       3,
+				QueueWaitPerRequest: 100 * time.Millisecond,
+			}
+
+			app := fiber.New()
+			app.Post("/request", func(c *fiber.Ctx) error {
+				//header value is reused by fiber, so it's copied as in auth middleware
+				c.Locals(auth.CLIENT_ID_CTX_KEY, utils.CopyString(c.Get("Authorization")))
+				return controller.HandleCreateRequest(c)
+			})
+
+			send := func(clientID string) (*http.Response, string) {
+				httpRequest, err := http.NewRequest("POST", "/request", nil)
+				assert.NoError(t, err)
+				httpRequest.Header.Set("Authorization", clientID)
+
+				response, err := app.Test(httpRequest)
+				assert.NoError(t, err)
+				defer response.Body.Close()
+
+				body, err := io.ReadAll(response.Body)
+				assert.NoError(t, err)
+				return response, string(body)
+			}
+
+			for _, ID := range []string{"other1", "other2"} {
+				assert.NoError(t, dataProvider.ListPush(ID, common.PRIORITY_NORMAL, 0))
+			}
+
+			//queued request reports position on every call
+			for i := 0; i < 2; i++ {
+				response, body := send("client1")
+				assert.Equal(t, fiber.StatusAccepted, response.StatusCode)
+				assert.JSONEq(t, `{"position": 2, "estimated_wait_ms": 300}`, body)
+			}
+
+			//queue is full, request is not created
+			response, _ := send("client2")
+			assert.Equal(t, fiber.StatusServiceUnavailable, response.StatusCode)
+			assert.Equal(t, "1", response.Header.Get(fiber.HeaderRetryAfter))
+
+			request := datatest.GetRequest(t, dataProvider, "client2")
+			if assert.NotNil(t, request) {
+				assert.Equal(t, common.FAILED, request.Status)
+				assert.True(t, request.Reported)
+			}
+
+			//request is created once queue has room
+			assert.Equal(t, []string{"other1"}, datatest.PopN(t, dataProvider, 1))
+			response, body := send("client2")
+			assert.Equal(t, fiber.StatusAccepted, response.StatusCode)
+			assert.JSONEq(t, `{"position": 2, "estimated_wait_ms": 300}`, body)
+		})
+	}
+}
@@ -1,22 +1,63 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/st-matskevich/go-matchmaker/api/auth"
 	"github.com/st-matskevich/go-matchmaker/common"
 	"github.com/st-matskevich/go-matchmaker/common/data"
 	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/controlauth"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
 )
 
 type Controller struct {
+	//written as source of request events
+	InstanceID string
+
 	DataProvider data.DataProvider
 	HttpClient   web.HTTPClient
 
 	ImageControlPort string
+
+	//priority of request created after client lost reservation
+	ReconnectPriority int
+
+	//new requests are rejected while queue has this many requests, unlimited if 0
+	MaxQueueDepth int
+	//average time between pops by all Makers, wait is not estimated if 0
+	QueueWaitPerRequest time.Duration
+}
+
+// QueueStatus is body of 202 response while request is queued
+type QueueStatus struct {
+	//number of requests that are served first
+	Position        int   `json:"position"`
+	EstimatedWaitMs int64 `json:"estimated_wait_ms,omitempty"`
+}
+
+// limits of CreateRequestBody, bodies over them are rejected
+const (
+	MAX_REQUEST_ATTRIBUTES = 32
+	MAX_REQUEST_PARTY      = 16
+	//of attribute keys and values and of party member IDs
+	MAX_REQUEST_VALUE_LENGTH = 256
+)
+
+// CreateRequestBody is optional JSON body of POST /request, passed to game server in
+// Reservation API v2 request
+type CreateRequestBody struct {
+	Attributes map[string]string `json:"attributes,omitempty"`
+	//IDs of clients that join server together with the client
+	Party []string `json:"party,omitempty"`
 }
 
 func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
@@ -26,23 +67,75 @@ func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	locker := common.RequestBody{ID: clientID, Status: common.OCCUPIED}
-	request, err := controller.DataProvider.Set(locker)
+	//priority is optional, zero value is common.PRIORITY_NORMAL
+	priority, _ := c.Locals(auth.CLIENT_PRIORITY_CTX_KEY).(int)
+
+	//body is optional, it's used only if new request is created. Bodies of other
+	//types are ignored, so clients that send them by default are not rejected
+	body := CreateRequestBody{}
+	if len(c.Body()) > 0 && c.Is("json") {
+		err := json.Unmarshal(c.Body(), &body)
+		if err == nil {
+			err = validateRequestBody(body)
+		}
+
+		if err != nil {
+			log.Printf("Client %v sent invalid request body: %v", clientID, err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+	}
+
+	//read before locking, so pending request is not overwritten by OCCUPIED status
+	request, err := controller.DataProvider.Get(clientID)
 	if err != nil {
 		log.Printf("GetClientRequest error: %v", err)
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 	log.Printf("Got request from client %v", clientID)
 
+	if request != nil && (request.Status == common.CREATED || request.Status == common.IN_PROGRESS || request.Status == common.OCCUPIED) {
+		log.Printf("Client %v request is in progress", clientID)
+		return controller.sendQueueStatus(c, clientID)
+	}
+
+	//failure is reported once, next call creates new request
+	if request != nil && request.Status == common.FAILED && !request.Reported {
+		log.Printf("Client %v request failed after %v attempts: %v", clientID, request.Attempts, request.Reason)
+		request.Reported = true
+		_, err = controller.DataProvider.Set(*request)
+		if err != nil {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		data.LogEvent(controller.DataProvider, controller.InstanceID, clientID, common.RequestEvent{Type: common.EVENT_REPORTED, Message: request.Reason})
+
+		return c.SendStatus(fiber.StatusServiceUnavailable)
+	}
+
+	locker := common.RequestBody{ID: clientID, Status: common.OCCUPIED}
+	request, err = controller.DataProvider.Set(locker)
+	if err != nil {
+		log.Printf("LockClientRequest error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
 	createNewRequest := false
+	//expired records are removed by data provider, so they are handled same way as no request
 	if request == nil || request.Status == common.FAILED {
-		log.Printf("Client %v last request is failed or nil", clientID)
+		log.Printf("Client %v last request is failed, expired or nil", clientID)
 		createNewRequest = true
+		if request != nil {
+			//client retried by itself, request is not dead anymore
+			_, err = controller.DataProvider.DeadLetterRemove(clientID)
+			if err != nil {
+				log.Printf("DeadLetterRemove error: %v", err)
+				return c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
 	} else if request.Status == common.CREATED || request.Status == common.IN_PROGRESS || request.Status == common.OCCUPIED {
 		log.Printf("Client %v request is in progress", clientID)
 		createNewRequest = false
 	} else if request.Status == common.DONE {
-		pending, err := controller.getReservationStatus(*request)
+		status, pending, err := controller.getReservationStatus(*request)
 		if err != nil {
 			//don't return, maybe just found closed container, create new request
 			log.Printf("Reservation verify error: %v", err)
@@ -50,7 +143,13 @@ func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
 
 		if err == nil && pending {
 			log.Printf("Client %v reservation is OK, sending server address", clientID)
-			//set back done status for future calls
+			if status.Joined && !request.Joined {
+				request.Joined = true
+				data.LogEvent(controller.DataProvider, controller.InstanceID, clientID, common.RequestEvent{Type: common.EVENT_JOINED, Container: request.Container})
+			}
+
+			//set back done status for future calls, unchanged record keeps its TTL and
+			//TTL of not joined client is derived from ExpiresAt by data backend
 			_, err = controller.DataProvider.Set(*request)
 			if err != nil {
 				return c.SendStatus(fiber.StatusInternalServerError)
@@ -59,10 +158,25 @@ func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
 			//hostname can contain port, remove last :.* part
 			parts := strings.Split(c.Hostname(), ":")
 			hostname := strings.Join(parts[:len(parts)-1], ":") + ":" + request.ServerPort
+			//client shows token to server, so address can't be shared with other clients
+			if request.JoinToken != "" {
+				c.Set(jointoken.HEADER, request.JoinToken)
+			}
+			//client passes tokens to its party, so each member joins with own token
+			if len(request.PartyTokens) > 0 {
+				c.Set(jointoken.PARTY_HEADER, jointoken.FormatPartyTokens(request.PartyTokens))
+			}
 			return c.Status(fiber.StatusOK).SendString(hostname)
+		} else if isReservationExpired(*request, time.Now()) {
+			return controller.reportExpiredReservation(c, *request)
 		} else {
 			log.Printf("Client %v reservation is not pending", clientID)
+			data.LogEvent(controller.DataProvider, controller.InstanceID, clientID, common.RequestEvent{Type: common.EVENT_RESERVATION_LOST, Container: request.Container})
 			createNewRequest = true
+			//client already waited for a server once, don't put it to the end of the queue
+			if priority < controller.ReconnectPriority {
+				priority = controller.ReconnectPriority
+			}
 		}
 	} else {
 		log.Println("Found not implemented status")
@@ -70,7 +184,17 @@ func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
 	}
 
 	if createNewRequest {
-		err = controller.createRequest(clientID)
+		full, err := controller.isQueueFull()
+		if err != nil {
+			log.Printf("Queue length error: %v", err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		if full {
+			return controller.rejectRequest(c, clientID)
+		}
+
+		err = controller.createRequest(clientID, priority, body)
 		if err != nil {
 			log.Printf("CreateRequest error: %v", err)
 			return c.SendStatus(fiber.StatusInternalServerError)
@@ -78,37 +202,163 @@ func (controller *Controller) HandleCreateRequest(c *fiber.Ctx) error {
 		log.Printf("Created new request for client %v", clientID)
 	}
 
-	return c.SendStatus(fiber.StatusAccepted)
+	return controller.sendQueueStatus(c, clientID)
 }
 
-func (controller *Controller) getReservationStatus(request common.RequestBody) (bool, error) {
+// body is stored with request and sent to servers, so its size is limited
+func validateRequestBody(body CreateRequestBody) error {
+	if len(body.Attributes) > MAX_REQUEST_ATTRIBUTES {
+		return fmt.Errorf("more than %v attributes", MAX_REQUEST_ATTRIBUTES)
+	}
+
+	for key, value := range body.Attributes {
+		if len(key) > MAX_REQUEST_VALUE_LENGTH || len(value) > MAX_REQUEST_VALUE_LENGTH {
+			return fmt.Errorf("attribute is longer than %v bytes", MAX_REQUEST_VALUE_LENGTH)
+		}
+	}
+
+	if len(body.Party) > MAX_REQUEST_PARTY {
+		return fmt.Errorf("more than %v party members", MAX_REQUEST_PARTY)
+	}
+
+	for _, memberID := range body.Party {
+		if memberID == "" || len(memberID) > MAX_REQUEST_VALUE_LENGTH {
+			return fmt.Errorf("party member ID is empty or longer than %v bytes", MAX_REQUEST_VALUE_LENGTH)
+		}
+	}
+
+	return nil
+}
+
+// responds with position of queued request, body is empty if request is processed
+// or data backend doesn't report positions
+func (controller *Controller) sendQueueStatus(c *fiber.Ctx, clientID string) error {
+	position, err := controller.DataProvider.ListPosition(clientID)
+	if err != nil && !errors.Is(err, data.ErrNotSupported) {
+		log.Printf("Queue position error: %v", err)
+	}
+
+	if err != nil || position < 0 {
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+
+	status := QueueStatus{Position: position}
+	if controller.QueueWaitPerRequest > 0 {
+		status.EstimatedWaitMs = (time.Duration(position+1) * controller.QueueWaitPerRequest).Milliseconds()
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(status)
+}
+
+func (controller *Controller) isQueueFull() (bool, error) {
+	if controller.MaxQueueDepth <= 0 {
+		return false, nil
+	}
+
+	length, err := controller.DataProvider.ListLength()
+	if err != nil {
+		return false, err
+	}
+
+	return length >= controller.MaxQueueDepth, nil
+}
+
+// locked request is replaced with reported failure, so next call tries to create request again
+func (controller *Controller) rejectRequest(c *fiber.Ctx, clientID string) error {
+	log.Printf("Queue is full, rejecting client %v request", clientID)
+	rejected := common.RequestBody{ID: clientID, Status: common.FAILED, Reason: "queue is full", Reported: true}
+	_, err := controller.DataProvider.Set(rejected)
+	if err != nil {
+		log.Printf("RejectRequest error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	data.LogEvent(controller.DataProvider, controller.InstanceID, clientID, common.RequestEvent{Type: common.EVENT_REJECTED, Message: rejected.Reason})
+
+	if controller.QueueWaitPerRequest > 0 {
+		retryAfter := time.Duration(controller.MaxQueueDepth) * controller.QueueWaitPerRequest
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+	}
+
+	return c.SendStatus(fiber.StatusServiceUnavailable)
+}
+
+// getReservationStatus returns true if server still holds slot for client, status is
+// empty if server doesn't report it
+func (controller *Controller) getReservationStatus(request common.RequestBody) (common.ReservationStatus, bool, error) {
 	containerURL := "http://" + request.Container + ":" + controller.ImageControlPort
 	containerURL += "/reservation/" + request.ID
 
+	status := common.ReservationStatus{}
 	req, err := http.NewRequest("GET", containerURL, nil)
 	if err != nil {
-		return false, err
+		return status, false, err
+	}
+
+	if request.ServerID != "" {
+		req.Header.Set(controlauth.SERVER_ID_HEADER, request.ServerID)
 	}
 
 	resp, err := controller.HttpClient.Do(req)
 	if err != nil {
-		return false, err
+		return status, false, err
 	}
+	defer resp.Body.Close()
 
-	return resp.StatusCode == 200, nil
+	if resp.StatusCode != 200 {
+		return status, false, nil
+	}
+
+	//body is optional, so servers without it are not failed
+	json.NewDecoder(resp.Body).Decode(&status)
+	return status, true, nil
+}
+
+// server dropped slot of client that didn't join in time, client is not queued
+// silently, so it knows that it was late
+func isReservationExpired(request common.RequestBody, now time.Time) bool {
+	return !request.Joined && request.ExpiresAt > 0 && now.UnixMilli() >= request.ExpiresAt
+}
+
+// expired request is replaced with reported failure, so next call creates new request
+func (controller *Controller) reportExpiredReservation(c *fiber.Ctx, request common.RequestBody) error {
+	log.Printf("Client %v reservation expired", request.ID)
+	expired := common.RequestBody{ID: request.ID, Status: common.FAILED, Reason: "reservation expired", Reported: true}
+	_, err := controller.DataProvider.Set(expired)
+	if err != nil {
+		log.Printf("ExpireRequest error: %v", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	data.LogEvent(controller.DataProvider, controller.InstanceID, request.ID, common.RequestEvent{Type: common.EVENT_RESERVATION_EXPIRED, Container: request.Container})
+
+	return c.SendStatus(fiber.StatusGone)
 }
 
-func (controller *Controller) createRequest(clientID string) error {
-	request := common.RequestBody{ID: clientID, Status: common.CREATED}
+func (controller *Controller) createRequest(clientID string, priority int, body CreateRequestBody) error {
+	request := common.RequestBody{
+		ID:         clientID,
+		Status:     common.CREATED,
+		Priority:   priority,
+		Attributes: body.Attributes,
+		Party:      body.Party,
+	}
 	_, err := controller.DataProvider.Set(request)
 	if err != nil {
 		return err
 	}
 
-	err = controller.DataProvider.ListPush(request.ID)
+	//request without queue entry is never processed, so it's failed to be reported on next call,
+	//e.g. if queue backend doesn't support request priority
+	err = controller.DataProvider.ListPush(request.ID, request.Priority, 0)
 	if err != nil {
+		request.Status = common.FAILED
+		request.Reason = fmt.Sprintf("failed to queue request: %v", err)
+		_, setErr := controller.DataProvider.Set(request)
+		if setErr != nil {
+			log.Printf("FailRequest error: %v", setErr)
+		}
 		return err
 	}
+	data.LogEvent(controller.DataProvider, controller.InstanceID, request.ID, common.RequestEvent{Type: common.EVENT_CREATED, Message: fmt.Sprintf("priority %v", request.Priority)})
 
 	return nil
 }
@@ -3,7 +3,9 @@ package controller
 import (
 	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/st-matskevich/go-matchmaker/api/auth"
@@ -23,6 +25,8 @@ type RequestHandlingArgs struct {
 	clientID        string
 	request         *common.RequestBody
 	reservationCode int
+	//optional body of reservation response
+	reservationBody string
 }
 
 func TestRequestHandling(t *testing.T) {
@@ -105,6 +109,23 @@ func TestRequestHandling(t *testing.T) {
 				request: &common.RequestBody{
 					ID:     "client1",
 					Status: common.FAILED,
+					Reason: "no capacity",
+				},
+			},
+			want: RequestHandlingWant{
+				code: fiber.StatusServiceUnavailable,
+				body: "",
+			},
+		},
+		{
+			name: "request FAILED reported",
+			args: RequestHandlingArgs{
+				clientID: "client1",
+				request: &common.RequestBody{
+					ID:       "client1",
+					Status:   common.FAILED,
+					Reason:   "no capacity",
+					Reported: true,
 				},
 			},
 			want: RequestHandlingWant{
@@ -147,6 +168,24 @@ func TestRequestHandling(t *testing.T) {
 				body: "",
 			},
 		},
+		{
+			name: "request DONE reservation expired",
+			args: RequestHandlingArgs{
+				clientID:        "client1",
+				reservationCode: fiber.StatusNotFound,
+				request: &common.RequestBody{
+					ID:         "client1",
+					Status:     common.DONE,
+					Container:  "container1",
+					ServerPort: "45677",
+					ExpiresAt:  time.Now().Add(-time.Minute).UnixMilli(),
+				},
+			},
+			want: RequestHandlingWant{
+				code: fiber.StatusGone,
+				body: "",
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -162,35 +201,63 @@ func TestRequestHandling(t *testing.T) {
 				ImageControlPort: containerControlPort,
 			}
 
-			//set with get on request
 			if test.args.clientID != "" {
-				dataProvider.On("Set", mock.Anything).Return(test.args.request, nil).Once()
+				dataProvider.On("Get", test.args.clientID).Return(test.args.request, nil).Once()
 			}
 
-			if test.args.request != nil && test.args.request.Status == common.DONE {
+			//events are verified in backend tests
+			dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+			//queue status is verified in backend tests
+			dataProvider.On("ListPosition", mock.Anything).Return(-1, nil).Maybe()
+
+			request := test.args.request
+			pending := request != nil && (request.Status == common.CREATED || request.Status == common.IN_PROGRESS || request.Status == common.OCCUPIED)
+			if request != nil && request.Status == common.FAILED && !request.Reported {
+				//expect reported flag update
+				reported := mock.MatchedBy(func(req common.RequestBody) bool {
+					return req.Status == common.FAILED && req.Reported
+				})
+				dataProvider.On("Set", reported).Return(request, nil).Once()
+			} else if test.args.clientID != "" && !pending {
+				//expect lock
+				dataProvider.On("Set", mock.Anything).Return(request, nil).Once()
+			}
+
+			if request != nil && request.Status == common.DONE {
 				//expect reservation request
-				containerURL := "http://" + test.args.request.Container + ":" + containerControlPort
+				containerURL := "http://" + request.Container + ":" + containerControlPort
 				containerURL += "/reservation/" + test.args.clientID
 				req, err := http.NewRequest("GET", containerURL, nil)
 				assert.NoError(t, err)
 
-				httpResponse := http.Response{StatusCode: test.args.reservationCode}
+				httpResponse := http.Response{StatusCode: test.args.reservationCode, Body: http.NoBody}
 				httpMock.On("Do", req).Return(&httpResponse, nil).Once()
 
 				if test.args.reservationCode == fiber.StatusOK {
 					//expect request update
 					dataProvider.On("Set", mock.Anything).Return(nil, nil).Once()
+				} else if isReservationExpired(*request, time.Now()) {
+					//expect reported failure
+					expired := mock.MatchedBy(func(req common.RequestBody) bool {
+						return req.Status == common.FAILED && req.Reported
+					})
+					dataProvider.On("Set", expired).Return(nil, nil).Once()
 				} else {
 					//expect new request
 					dataProvider.On("Set", mock.Anything).Return(nil, nil).Once()
-					dataProvider.On("ListPush", mock.Anything).Return(nil).Once()
+					dataProvider.On("ListPush", test.args.clientID, common.PRIORITY_NORMAL, time.Duration(0)).Return(nil).Once()
 				}
 			}
 
-			if test.args.request == nil || test.args.request.Status == common.FAILED {
+			if request != nil && request.Status == common.FAILED && request.Reported {
+				//expect request removal from dead-letter queue
+				dataProvider.On("DeadLetterRemove", test.args.clientID).Return(true, nil).Once()
+			}
+
+			if request == nil || (request.Status == common.FAILED && request.Reported) {
 				//expect new request
 				dataProvider.On("Set", mock.Anything).Return(nil, nil).Once()
-				dataProvider.On("ListPush", mock.Anything).Return(nil).Once()
+				dataProvider.On("ListPush", test.args.clientID, common.PRIORITY_NORMAL, time.Duration(0)).Return(nil).Once()
 			}
 
 			app := fiber.New()
@@ -218,3 +285,218 @@ func TestRequestHandling(t *testing.T) {
 		})
 	}
 }
+
+type RequestPriorityArgs struct {
+	clientPriority    int
+	reconnectPriority int
+	request           *common.RequestBody
+}
+
+func TestRequestPriority(t *testing.T) {
+	tests := []struct {
+		name string
+		args RequestPriorityArgs
+		want int
+	}{
+		{
+			name: "new request",
+			args: RequestPriorityArgs{
+				clientPriority:    common.PRIORITY_MAX,
+				reconnectPriority: common.PRIORITY_NORMAL,
+				request:           nil,
+			},
+			want: common.PRIORITY_MAX,
+		},
+		{
+			name: "reconnect raises priority",
+			args: RequestPriorityArgs{
+				clientPriority:    common.PRIORITY_NORMAL,
+				reconnectPriority: 5,
+				request:           &common.RequestBody{ID: "client1", Status: common.DONE, Container: "container1"},
+			},
+			want: 5,
+		},
+		{
+			name: "reconnect keeps higher client priority",
+			args: RequestPriorityArgs{
+				clientPriority:    common.PRIORITY_MAX,
+				reconnectPriority: 5,
+				request:           &common.RequestBody{ID: "client1", Status: common.DONE, Container: "container1"},
+			},
+			want: common.PRIORITY_MAX,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataProvider := data.MockDataProvider{}
+			httpMock := web.HTTPClientMock{}
+
+			controller := Controller{
+				DataProvider:      &dataProvider,
+				HttpClient:        &httpMock,
+				ImageControlPort:  "3000",
+				ReconnectPriority: test.args.reconnectPriority,
+			}
+
+			dataProvider.On("Get", "client1").Return(test.args.request, nil).Once()
+			dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+			dataProvider.On("ListPosition", mock.Anything).Return(-1, nil).Maybe()
+			dataProvider.On("Set", mock.Anything).Return(test.args.request, nil).Once()
+			body := &closeTracker{Reader: strings.NewReader("")}
+			if test.args.request != nil {
+				httpResponse := http.Response{StatusCode: fiber.StatusNotFound, Body: body}
+				httpMock.On("Do", mock.Anything).Return(&httpResponse, nil).Once()
+			}
+
+			created := mock.MatchedBy(func(req common.RequestBody) bool {
+				return req.Status == common.CREATED && req.Priority == test.want
+			})
+			dataProvider.On("Set", created).Return(nil, nil).Once()
+			dataProvider.On("ListPush", "client1", test.want, time.Duration(0)).Return(nil).Once()
+
+			app := fiber.New()
+			app.Post("/request", func(c *fiber.Ctx) error {
+				c.Locals(auth.CLIENT_ID_CTX_KEY, "client1")
+				c.Locals(auth.CLIENT_PRIORITY_CTX_KEY, test.args.clientPriority)
+				return controller.HandleCreateRequest(c)
+			})
+
+			httpRequest, err := http.NewRequest("POST", "/request", nil)
+			assert.NoError(t, err)
+
+			response, err := app.Test(httpRequest)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusAccepted, response.StatusCode)
+			//body of lost reservation response is closed too, so connection is not leaked
+			assert.Equal(t, test.args.request != nil, body.closed)
+
+			dataProvider.AssertExpectations(t)
+			httpMock.AssertExpectations(t)
+		})
+	}
+}
+
+// closeTracker is response body that reports whether it was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (body *closeTracker) Close() error {
+	body.closed = true
+	return nil
+}
+
+func TestRequestQueueError(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	controller := Controller{DataProvider: &dataProvider, HttpClient: &web.HTTPClientMock{}, ImageControlPort: "3000"}
+
+	dataProvider.On("Get", "client1").Return(nil, nil).Once()
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+	dataProvider.On("Set", mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.OCCUPIED
+	})).Return(nil, nil).Once()
+	dataProvider.On("Set", mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.CREATED
+	})).Return(nil, nil).Once()
+	dataProvider.On("ListPush", "client1", common.PRIORITY_MAX, time.Duration(0)).Return(data.ErrNotSupported).Once()
+	//request that is not queued is failed, so it's not left in CREATED status
+	dataProvider.On("Set", mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.FAILED && req.Reason != ""
+	})).Return(nil, nil).Once()
+
+	app := fiber.New()
+	app.Post("/request", func(c *fiber.Ctx) error {
+		c.Locals(auth.CLIENT_ID_CTX_KEY, "client1")
+		c.Locals(auth.CLIENT_PRIORITY_CTX_KEY, common.PRIORITY_MAX)
+		return controller.HandleCreateRequest(c)
+	})
+
+	httpRequest, err := http.NewRequest("POST", "/request", nil)
+	assert.NoError(t, err)
+	response, err := app.Test(httpRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusInternalServerError, response.StatusCode)
+
+	dataProvider.AssertExpectations(t)
+}
+
+func TestRequestBody(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+	controller := Controller{DataProvider: &dataProvider, HttpClient: &httpMock, ImageControlPort: "3000"}
+
+	dataProvider.On("Get", "client1").Return(nil, nil)
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+	dataProvider.On("ListPosition", mock.Anything).Return(-1, nil).Maybe()
+	dataProvider.On("Set", mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.OCCUPIED
+	})).Return(nil, nil).Once()
+	//attributes and party are stored with new request
+	created := mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.CREATED && req.Attributes["mode"] == "duel" && len(req.Party) == 1 && req.Party[0] == "client2"
+	})
+	dataProvider.On("Set", created).Return(nil, nil).Once()
+	dataProvider.On("ListPush", "client1", common.PRIORITY_NORMAL, time.Duration(0)).Return(nil).Once()
+
+	app := fiber.New()
+	app.Post("/request", func(c *fiber.Ctx) error {
+		c.Locals(auth.CLIENT_ID_CTX_KEY, "client1")
+		return controller.HandleCreateRequest(c)
+	})
+
+	httpRequest, err := http.NewRequest("POST", "/request", strings.NewReader(`{"attributes":{"mode":"duel"},"party":["client2"]}`))
+	assert.NoError(t, err)
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := app.Test(httpRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, response.StatusCode)
+
+	//invalid body is rejected before request is read
+	party := `{"party":["` + strings.Repeat(`client2","`, MAX_REQUEST_PARTY) + `client2"]}`
+	for _, body := range []string{`{"party":`, party, `{"party":[""]}`} {
+		httpRequest, err = http.NewRequest("POST", "/request", strings.NewReader(body))
+		assert.NoError(t, err)
+		httpRequest.Header.Set("Content-Type", "application/json")
+		response, err = app.Test(httpRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, response.StatusCode)
+	}
+
+	dataProvider.AssertExpectations(t)
+}
+
+func TestRequestBodyOfOtherType(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+	controller := Controller{DataProvider: &dataProvider, HttpClient: &httpMock, ImageControlPort: "3000"}
+
+	dataProvider.On("Get", "client1").Return(nil, nil)
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+	dataProvider.On("ListPosition", mock.Anything).Return(-1, nil).Maybe()
+	dataProvider.On("Set", mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.OCCUPIED
+	})).Return(nil, nil).Once()
+	//body that is not JSON is ignored
+	created := mock.MatchedBy(func(req common.RequestBody) bool {
+		return req.Status == common.CREATED && req.Attributes == nil && req.Party == nil
+	})
+	dataProvider.On("Set", created).Return(nil, nil).Once()
+	dataProvider.On("ListPush", "client1", common.PRIORITY_NORMAL, time.Duration(0)).Return(nil).Once()
+
+	app := fiber.New()
+	app.Post("/request", func(c *fiber.Ctx) error {
+		c.Locals(auth.CLIENT_ID_CTX_KEY, "client1")
+		return controller.HandleCreateRequest(c)
+	})
+
+	httpRequest, err := http.NewRequest("POST", "/request", strings.NewReader(`mode=duel`))
+	assert.NoError(t, err)
+	httpRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := app.Test(httpRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, response.StatusCode)
+
+	dataProvider.AssertExpectations(t)
+}
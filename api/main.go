@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
+	"github.com/st-matskevich/go-matchmaker/api/admin"
 	"github.com/st-matskevich/go-matchmaker/api/auth"
 	"github.com/st-matskevich/go-matchmaker/api/controller"
+	"github.com/st-matskevich/go-matchmaker/api/tenant"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/config"
 	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
 )
 
 func main() {
@@ -22,43 +31,150 @@ func main() {
 		log.Println("No .env file found")
 	}
 
-	redisServerURL := os.Getenv("REDIS_SERVER_URL")
-	clientRedis, err := data.CreateRedisDataProvider(redisServerURL)
+	tenants, err := config.GetTenants()
 	if err != nil {
-		log.Fatalf("Redis connection error: %v", err)
+		log.Fatalf("Failed to parse tenants: %v", err)
 	}
 
-	log.Println("Connected to Redis")
+	defaultTenant, err := config.GetDefaultTenant(tenants)
+	if err != nil {
+		log.Fatalf("Failed to parse default tenant: %v", err)
+	}
+
+	//signed tokens carry tenant of client, DummyAuthorizer requests are served only by unnamed or default tenant
+	var authorizer auth.Authorizer = &auth.DummyAuthorizer{}
+	tokenSecret := os.Getenv("CLIENT_TOKEN_SECRET")
+	if tokenSecret != "" {
+		authorizer = &auth.SignedAuthorizer{Secret: []byte(tokenSecret)}
+		log.Println("Using signed client tokens")
+	} else if tenants[0] != "" && defaultTenant == "" {
+		log.Fatalf("DummyAuthorizer doesn't set tenant, set DEFAULT_TENANT or CLIENT_TOKEN_SECRET with TENANTS")
+	}
+
+	controllers := map[string]*controller.Controller{}
+	adminControllers := map[string]*admin.Controller{}
+	for _, tenant := range tenants {
+		dataProvider, err := config.CreateDataProvider(tenant)
+		if err != nil {
+			log.Fatalf("Data backend connection error for tenant %q: %v", tenant, err)
+		}
+		log.Printf("Connected to data backend for tenant %q", tenant)
+
+		controller, err := initController(tenant, dataProvider)
+		if err != nil {
+			log.Fatalf("Failed to initialize Controller for tenant %q: %v", tenant, err)
+		}
+
+		controllers[tenant] = controller
+		adminControllers[tenant] = &admin.Controller{InstanceID: controller.InstanceID, DataProvider: dataProvider}
+	}
 
 	app := fiber.New()
 
-	app.Use(
-		auth.New(&auth.DummyAuthorizer{}),
-	)
+	//tenant is chosen by Authorizer, requests without tenant are served by default tenant
+	source := tenant.WithDefault(tenant.FromIdentity, defaultTenant)
+	app.Post("/request", auth.New(authorizer), tenant.Route(controllers, source, (*controller.Controller).HandleCreateRequest))
 
-	controller, err := initController(clientRedis)
+	//admin routes are disabled if token is not set, tenant is chosen by query parameter
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken != "" {
+		adminGroup := app.Group("/admin", auth.New(&auth.TokenAuthorizer{Token: adminToken}))
+		adminGroup.Get("/dead-letter", tenant.Route(adminControllers, tenant.FromQuery, (*admin.Controller).HandleListDeadLetter))
+		adminGroup.Post("/dead-letter/:id/replay", tenant.Route(adminControllers, tenant.FromQuery, (*admin.Controller).HandleReplayDeadLetter))
+		adminGroup.Get("/requests/:id/events", tenant.Route(adminControllers, tenant.FromQuery, (*admin.Controller).HandleListEvents))
+		adminGroup.Get("/servers", tenant.Route(adminControllers, tenant.FromQuery, (*admin.Controller).HandleListServers))
+		log.Println("Enabled admin routes")
+	}
+
+	shutdownTimeout, err := config.GetShutdownTimeout()
 	if err != nil {
-		log.Fatalf("Failed to initialize Controller: %v", err)
+		log.Fatalf("Failed to parse shutdown timeout: %v", err)
 	}
 
-	app.Post("/request", controller.HandleCreateRequest)
+	//Listen returns as soon as listener is closed, so shutdown result is awaited separately
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		log.Printf("Stopping API service, waiting for running requests up to %v", shutdownTimeout)
+		shutdown <- app.ShutdownWithTimeout(shutdownTimeout)
+	}()
+
+	err = app.Listen(":3000")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = <-shutdown
+	if err != nil {
+		log.Fatalf("Failed to finish running requests: %v", err)
+	}
 
-	log.Fatal(app.Listen(":3000"))
+	log.Println("API service stopped")
 }
 
-func initController(dataProvider data.DataProvider) (*controller.Controller, error) {
-	timeoutString := os.Getenv("RESERVATION_TIMEOUT")
+func initController(tenant string, dataProvider data.DataProvider) (*controller.Controller, error) {
+	timeoutString := config.GetTenantEnv(tenant, "RESERVATION_TIMEOUT")
 	reservationTimeout, err := strconv.Atoi(timeoutString)
 	if err != nil {
 		return nil, err
 	}
-	httpClient := &http.Client{Timeout: time.Duration(reservationTimeout) * time.Millisecond}
+	var httpClient web.HTTPClient = &http.Client{Timeout: time.Duration(reservationTimeout) * time.Millisecond}
+	//same secret is used by Maker and passed to servers
+	controlSecret := config.GetTenantEnv(tenant, "IMAGE_CONTROL_SECRET")
+	if controlSecret != "" {
+		httpClient = &web.SigningClient{Client: httpClient, Secret: controlSecret}
+	}
+
+	imageControlPort := config.GetTenantEnv(tenant, "IMAGE_CONTROL_PORT")
+
+	reconnectPriority := common.PRIORITY_NORMAL
+	priorityString := config.GetTenantEnv(tenant, "RECONNECT_PRIORITY")
+	if priorityString != "" {
+		reconnectPriority, err = strconv.Atoi(priorityString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//NATS queue keeps publish order and rejects priorities
+	if os.Getenv("QUEUE_BACKEND") == data.NATS_QUEUE_BACKEND && reconnectPriority > common.PRIORITY_NORMAL {
+		return nil, errors.New("RECONNECT_PRIORITY is not supported by NATS queue backend")
+	}
 
-	imageControlPort := os.Getenv("IMAGE_CONTROL_PORT")
+	maxQueueDepth := 0
+	depthString := config.GetTenantEnv(tenant, "MAX_QUEUE_DEPTH")
+	if depthString != "" {
+		maxQueueDepth, err = strconv.Atoi(depthString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	queueWaitPerRequest := 0
+	waitString := config.GetTenantEnv(tenant, "QUEUE_WAIT_PER_REQUEST")
+	if waitString != "" {
+		queueWaitPerRequest, err = strconv.Atoi(waitString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//container hostname identifies API replica in request events
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
 
 	return &controller.Controller{
-		DataProvider:     dataProvider,
-		HttpClient:       httpClient,
-		ImageControlPort: imageControlPort,
+		InstanceID:        hostname,
+		DataProvider:      dataProvider,
+		HttpClient:        httpClient,
+		ImageControlPort:  imageControlPort,
+		ReconnectPriority: reconnectPriority,
+
+		MaxQueueDepth:       maxQueueDepth,
+		QueueWaitPerRequest: time.Duration(queueWaitPerRequest) * time.Millisecond,
 	}, nil
 }
@@ -0,0 +1,46 @@
+package tenant
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/api/auth"
+)
+
+// query parameter used to select tenant on admin routes
+const TENANT_QUERY_KEY = "tenant"
+
+// FromIdentity returns tenant of authorized client
+func FromIdentity(c *fiber.Ctx) string {
+	tenant, _ := c.Locals(auth.CLIENT_TENANT_CTX_KEY).(string)
+	return tenant
+}
+
+// WithDefault returns defaultTenant if source returns empty tenant, e.g. for
+// Authorizer that doesn't set tenants
+func WithDefault(source func(c *fiber.Ctx) string, defaultTenant string) func(c *fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		tenant := source(c)
+		if tenant == "" {
+			return defaultTenant
+		}
+
+		return tenant
+	}
+}
+
+// FromQuery returns tenant from TENANT_QUERY_KEY query parameter
+func FromQuery(c *fiber.Ctx) string {
+	return c.Query(TENANT_QUERY_KEY)
+}
+
+// Route calls handler of the controller that serves tenant returned by source,
+// requests of unknown tenants are rejected
+func Route[T any](controllers map[string]T, source func(c *fiber.Ctx) string, handler func(controller T, c *fiber.Ctx) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		controller, ok := controllers[source(c)]
+		if !ok {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+
+		return handler(controller, c)
+	}
+}
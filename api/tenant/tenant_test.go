@@ -0,0 +1,113 @@
+package tenant
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type namedController struct {
+	name string
+}
+
+func (controller *namedController) handle(c *fiber.Ctx) error {
+	return c.SendString(controller.name)
+}
+
+func TestRoute(t *testing.T) {
+	controllers := map[string]*namedController{
+		"game1": {name: "controller1"},
+		"game2": {name: "controller2"},
+	}
+
+	tests := []struct {
+		name   string
+		tenant string
+		code   int
+		body   string
+	}{
+		{name: "first tenant", tenant: "game1", code: fiber.StatusOK, body: "controller1"},
+		{name: "second tenant", tenant: "game2", code: fiber.StatusOK, body: "controller2"},
+		{name: "unknown tenant", tenant: "game3", code: fiber.StatusForbidden},
+		{name: "no tenant", tenant: "", code: fiber.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/request", func(c *fiber.Ctx) error {
+				c.Locals(auth.CLIENT_TENANT_CTX_KEY, test.tenant)
+				return c.Next()
+			}, Route(controllers, FromIdentity, (*namedController).handle))
+			app.Get("/admin", Route(controllers, FromQuery, (*namedController).handle))
+
+			for _, httpRequest := range []*http.Request{
+				httpTestRequest(t, "POST", "/request"),
+				httpTestRequest(t, "GET", "/admin?tenant="+test.tenant),
+			} {
+				response, err := app.Test(httpRequest)
+				assert.NoError(t, err)
+				defer response.Body.Close()
+
+				assert.Equal(t, test.code, response.StatusCode)
+				if test.body != "" {
+					bodyBytes, err := io.ReadAll(response.Body)
+					assert.NoError(t, err)
+					assert.Equal(t, test.body, string(bodyBytes))
+				}
+			}
+		})
+	}
+}
+
+func TestWithDefault(t *testing.T) {
+	controllers := map[string]*namedController{"game1": {name: "controller1"}}
+
+	app := fiber.New()
+	app.Post("/request", Route(controllers, WithDefault(FromIdentity, "game1"), (*namedController).handle))
+
+	//identity without tenant is routed to default tenant
+	response, err := app.Test(httpTestRequest(t, "POST", "/request"))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, response.StatusCode)
+	bodyBytes, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller1", string(bodyBytes))
+}
+
+func TestRouteSignedTenants(t *testing.T) {
+	secret := []byte("secret")
+	controllers := map[string]*namedController{
+		"game1": {name: "controller1"},
+		"game2": {name: "controller2"},
+	}
+
+	app := fiber.New()
+	app.Post("/request", auth.New(&auth.SignedAuthorizer{Secret: secret}), Route(controllers, FromIdentity, (*namedController).handle))
+
+	//tenant is taken from token that game backend signed
+	for tenant, body := range map[string]string{"game1": "controller1", "game2": "controller2"} {
+		token, err := auth.SignToken(secret, auth.TokenClaims{ClientID: "client1", Tenant: tenant})
+		assert.NoError(t, err)
+
+		httpRequest := httpTestRequest(t, "POST", "/request")
+		httpRequest.Header.Set("Authorization", auth.BEARER_PREFIX+token)
+		response, err := app.Test(httpRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, response.StatusCode)
+		bodyBytes, err := io.ReadAll(response.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(bodyBytes))
+	}
+}
+
+func httpTestRequest(t *testing.T, method string, url string) *http.Request {
+	request, err := http.NewRequest(method, url, nil)
+	assert.NoError(t, err)
+
+	return request
+}
@@ -1,6 +1,9 @@
 package common
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 const (
 	CREATED     = "CREATED"
@@ -10,11 +13,174 @@ const (
 	OCCUPIED    = "OCCUPIED"
 )
 
+// requests with higher priority are served first, see data.ProviderOptions
+const (
+	PRIORITY_NORMAL = 0
+	PRIORITY_MAX    = 10
+)
+
 type RequestBody struct {
 	ID         string `json:"id"`
 	Status     string `json:"status"`
 	ServerPort string `json:"port,omitempty"`
 	Container  string `json:"container,omitempty"`
+	//ID of server in Container, empty for servers created without it
+	ServerID string `json:"server_id,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	//number of failed processing attempts
+	Attempts int `json:"attempts,omitempty"`
+	//error of the last failed attempt
+	Reason string `json:"reason,omitempty"`
+	//FAILED request was already reported to the client
+	Reported bool `json:"reported,omitempty"`
+	//unix time in ms when server frees slot if client doesn't join, 0 if server doesn't report it
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	//server confirmed that client joined, slot doesn't expire anymore
+	Joined bool `json:"joined,omitempty"`
+	//signed token that client shows to server on join, see jointoken package
+	JoinToken string `json:"join_token,omitempty"`
+	//signed tokens of party members by client ID, client passes them to its party
+	PartyTokens map[string]string `json:"party_tokens,omitempty"`
+	//sent by client, passed to server in Reservation API v2 request
+	Attributes map[string]string `json:"attributes,omitempty"`
+	//IDs of clients that join server together with the client
+	Party []string `json:"party,omitempty"`
+}
+
+// Reservation API versions, v1 is used if image doesn't set version
+const (
+	RESERVATION_API_V1 = "v1"
+	RESERVATION_API_V2 = "v2"
+)
+
+// env of new containers with Reservation API version that Maker uses
+const RESERVATION_API_ENV = "MATCHMAKER_RESERVATION_API"
+
+// env of new containers with random ID of server, join tokens and control key of the
+// server are bound to it
+const SERVER_ID_ENV = "MATCHMAKER_SERVER_ID"
+
+// results of Reservation API v2 reservation request
+const (
+	//slot is reserved for client and its party
+	RESERVATION_RESERVED = "reserved"
+	//server has no free slots for client and its party
+	RESERVATION_FULL = "full"
+	//server doesn't accept new clients and is going to stop
+	RESERVATION_DRAINING = "draining"
+	//server can't host client, e.g. it runs other game mode
+	RESERVATION_INCOMPATIBLE = "incompatible"
+	//server failed to handle request, request can be retried
+	RESERVATION_ERROR = "error"
+)
+
+// ReservationRequest is body of Reservation API v2 POST /v2/reservation
+type ReservationRequest struct {
+	ClientID   string            `json:"client_id"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Party      []string          `json:"party,omitempty"`
+	JoinToken  string            `json:"join_token,omitempty"`
+	//join tokens of party members by client ID
+	PartyTokens map[string]string `json:"party_tokens,omitempty"`
+}
+
+// ReservationResponse is body of Reservation API v2 response to POST /v2/reservation
+type ReservationResponse struct {
+	Result string `json:"result"`
+	//human readable details of result
+	Message string `json:"message,omitempty"`
+	//slots of server after request
+	Slots *SlotInfo `json:"slots,omitempty"`
+	//how long server holds reserved slots for clients that didn't join yet
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+}
+
+type SlotInfo struct {
+	Total int `json:"total"`
+	Free  int `json:"free"`
+}
+
+// ReservationStatus is optional JSON body of Reservation API responses to
+// POST and GET /reservation/{client-id}
+type ReservationStatus struct {
+	//how long server holds slot for client that didn't join yet
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+	//client connected to server, slot is held until client leaves
+	Joined bool `json:"joined,omitempty"`
+}
+
+// request history events, see data.EventLog
+const (
+	//API queued new request
+	EVENT_CREATED = "CREATED"
+	//API didn't queue request because queue was full
+	EVENT_REJECTED = "REJECTED"
+	//Maker took request from the queue
+	EVENT_POPPED = "POPPED"
+	//Maker asked container for a slot
+	EVENT_RESERVATION = "RESERVATION"
+	//Maker released extra slot reserved by parallel reservation
+	EVENT_RELEASE = "RELEASE"
+	//Maker can't create container because of container limit, request waits for free slot
+	EVENT_LIMITED = "LIMITED"
+	//Maker started new container for request
+	EVENT_CONTAINER_CREATED = "CONTAINER_CREATED"
+	//request was processed, container reserved a slot
+	EVENT_DONE = "DONE"
+	//request processing failed and request was queued again
+	EVENT_RETRY = "RETRY"
+	//request processing failed and request was moved to dead-letter queue
+	EVENT_FAILED = "FAILED"
+	//client was notified about failed request
+	EVENT_REPORTED = "REPORTED"
+	//client came back after container dropped its reservation
+	EVENT_RESERVATION_LOST = "RESERVATION_LOST"
+	//client didn't join server before reservation expired
+	EVENT_RESERVATION_EXPIRED = "RESERVATION_EXPIRED"
+	//server confirmed that client joined
+	EVENT_JOINED = "JOINED"
+	//failed request was queued again by admin
+	EVENT_REPLAYED = "REPLAYED"
+	//Maker stopped before request was processed and queued it again
+	EVENT_REQUEUED = "REQUEUED"
+)
+
+type RequestEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	//API or Maker instance that wrote the event
+	Source    string `json:"source,omitempty"`
+	Container string `json:"container,omitempty"`
+	//Reservation API response code
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	//time spent on request processing for DONE, RETRY and FAILED events
+	DurationMs int64 `json:"duration_ms,omitempty"`
+}
+
+// game server lifecycle states, see data.ServerStore
+const (
+	//Maker asked container backend for a container
+	SERVER_CREATING = "Creating"
+	//container is running, game server doesn't answer yet
+	SERVER_STARTING = "Starting"
+	//game server answers and has no reservations
+	SERVER_READY = "Ready"
+	//game server has reservations, it can still have free slots
+	SERVER_ALLOCATED = "Allocated"
+	//game server doesn't get new reservations and is going to stop
+	SERVER_DRAINING = "Draining"
+	//container is stopped
+	SERVER_SHUTDOWN = "Shutdown"
+)
+
+type ServerStatus struct {
+	//container ID, service ID for Swarm
+	ID string `json:"id"`
+	//empty until container is inspected
+	Address   string    `json:"address,omitempty"`
+	State     string    `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 func HandlePanic(perr interface{}) error {
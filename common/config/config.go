@@ -0,0 +1,355 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+)
+
+// used if SHUTDOWN_TIMEOUT is not set
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// variables of tenant are read with TENANT_<NAME>_ prefix first
+const TENANT_ENV_PREFIX = "TENANT_"
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// GetTenants parses TENANTS variable, single unnamed tenant is used if it's empty
+func GetTenants() ([]string, error) {
+	tenants := getList("TENANTS")
+	if len(tenants) == 0 {
+		return []string{""}, nil
+	}
+
+	//tenants are compared by env prefix, so game-1 and game_1 don't share variables
+	seen := map[string]bool{}
+	for _, tenant := range tenants {
+		if !tenantNameRegexp.MatchString(tenant) {
+			return nil, errors.New("invalid tenant name " + tenant)
+		}
+		if seen[getTenantEnvPrefix(tenant)] {
+			return nil, errors.New("duplicate tenant " + tenant + ", names that differ only in - and _ are the same")
+		}
+		seen[getTenantEnvPrefix(tenant)] = true
+	}
+
+	return tenants, nil
+}
+
+// GetDefaultTenant reads DEFAULT_TENANT variable, it should be one of tenants.
+// Client requests without tenant are served by default tenant
+func GetDefaultTenant(tenants []string) (string, error) {
+	defaultTenant := strings.TrimSpace(os.Getenv("DEFAULT_TENANT"))
+	if defaultTenant == "" {
+		return "", nil
+	}
+
+	for _, tenant := range tenants {
+		if tenant == defaultTenant {
+			return defaultTenant, nil
+		}
+	}
+
+	return "", errors.New("default tenant " + defaultTenant + " is not listed in TENANTS")
+}
+
+// GetTenantEnv reads TENANT_<NAME>_<name> variable, falls back to shared
+// variable if tenant doesn't override it
+func GetTenantEnv(tenant string, name string) string {
+	if tenant != "" {
+		tenantName := getTenantEnvPrefix(tenant) + name
+		if value, ok := os.LookupEnv(tenantName); ok {
+			return value
+		}
+	}
+
+	return os.Getenv(name)
+}
+
+// TENANT_<NAME>_ prefix, name is uppercased and - is replaced with _
+func getTenantEnvPrefix(tenant string) string {
+	return TENANT_ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(tenant, "-", "_")) + "_"
+}
+
+// GetShutdownTimeout reads how long services wait for running work after stop signal
+func GetShutdownTimeout() (time.Duration, error) {
+	if os.Getenv("SHUTDOWN_TIMEOUT") == "" {
+		return DEFAULT_SHUTDOWN_TIMEOUT, nil
+	}
+
+	return getOptionalDuration("SHUTDOWN_TIMEOUT")
+}
+
+// joins non-empty parts of namespace
+func joinNamespace(separator string, parts ...string) string {
+	result := []string{}
+	for _, part := range parts {
+		if part != "" {
+			result = append(result, part)
+		}
+	}
+
+	return strings.Join(result, separator)
+}
+
+// CreateDataProvider connects to backend selected by DATA_BACKEND, data of
+// each tenant is stored in own namespace. Queue is replaced with QUEUE_BACKEND if it's set
+func CreateDataProvider(tenant string) (data.DataProvider, error) {
+	provider, err := createDataBackend(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := os.Getenv("QUEUE_BACKEND")
+	switch backend {
+	case "":
+		return provider, nil
+	case data.NATS_QUEUE_BACKEND:
+		log.Println("Using NATS JetStream queue backend")
+
+		options, err := getNatsOptions(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		queue, err := data.CreateNatsQueue(options)
+		if err != nil {
+			return nil, err
+		}
+
+		return data.WithQueue(provider, queue), nil
+	default:
+		return nil, errors.New("unknown queue backend")
+	}
+}
+
+func createDataBackend(tenant string) (data.DataProvider, error) {
+	backend := os.Getenv("DATA_BACKEND")
+	switch backend {
+	case "", data.REDIS_DATA_BACKEND:
+		log.Println("Using Redis data backend")
+
+		options, err := getRedisOptions(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		return data.CreateRedisDataProvider(options)
+	case data.POSTGRES_DATA_BACKEND:
+		log.Println("Using PostgreSQL data backend")
+
+		options, err := getPostgresOptions(tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		return data.CreatePostgresDataProvider(options)
+	case data.MEMORY_DATA_BACKEND:
+		//API and Maker services are separate processes, so their memory stores would never share requests
+		return nil, errors.New("memory data backend is not shared between API and Maker services, it can be used only when both run in one process, e.g. in tests")
+	default:
+		return nil, errors.New("unknown data backend")
+	}
+}
+
+func getPostgresOptions(tenant string) (data.PostgresDataProviderOptions, error) {
+	result := data.PostgresDataProviderOptions{}
+
+	providerOptions, err := getProviderOptions()
+	if err != nil {
+		return result, err
+	}
+
+	poolSize, err := getOptionalInt("POSTGRES_POOL_SIZE")
+	if err != nil {
+		return result, err
+	}
+
+	result.URL = os.Getenv("POSTGRES_URL")
+	result.PoolSize = poolSize
+	result.Schema = joinNamespace("_", os.Getenv("POSTGRES_SCHEMA"), tenant)
+	result.ProviderOptions = providerOptions
+
+	return result, nil
+}
+
+func getRedisOptions(tenant string) (data.RedisDataProviderOptions, error) {
+	result := data.RedisDataProviderOptions{}
+
+	providerOptions, err := getProviderOptions()
+	if err != nil {
+		return result, err
+	}
+
+	db, err := getOptionalInt("REDIS_DB")
+	if err != nil {
+		return result, err
+	}
+
+	poolSize, err := getOptionalInt("REDIS_POOL_SIZE")
+	if err != nil {
+		return result, err
+	}
+
+	useTLS, err := getOptionalBool("REDIS_TLS")
+	if err != nil {
+		return result, err
+	}
+
+	result.Mode = os.Getenv("REDIS_MODE")
+	result.Addresses = getList("REDIS_SERVER_URL")
+	result.MasterName = os.Getenv("REDIS_MASTER_NAME")
+	result.Username = os.Getenv("REDIS_USERNAME")
+	result.Password = os.Getenv("REDIS_PASSWORD")
+	result.SentinelUsername = os.Getenv("REDIS_SENTINEL_USERNAME")
+	result.SentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+	result.DB = db
+	result.PoolSize = poolSize
+	result.KeyPrefix = joinNamespace(":", os.Getenv("REDIS_KEY_PREFIX"), tenant)
+	result.TLS = useTLS
+	result.TLSCAFile = os.Getenv("REDIS_TLS_CA_FILE")
+	result.TLSCertFile = os.Getenv("REDIS_TLS_CERT_FILE")
+	result.TLSKeyFile = os.Getenv("REDIS_TLS_KEY_FILE")
+	result.ProviderOptions = providerOptions
+
+	return result, nil
+}
+
+func getNatsOptions(tenant string) (data.NatsQueueOptions, error) {
+	result := data.NatsQueueOptions{}
+
+	ackWait, err := getOptionalDuration("NATS_ACK_WAIT")
+	if err != nil {
+		return result, err
+	}
+
+	maxDeliver, err := getOptionalInt("NATS_MAX_DELIVER")
+	if err != nil {
+		return result, err
+	}
+
+	stream := os.Getenv("NATS_STREAM")
+	if stream == "" {
+		stream = data.DEFAULT_NATS_STREAM
+	}
+
+	subject := os.Getenv("NATS_SUBJECT")
+	if subject == "" {
+		subject = data.DEFAULT_NATS_SUBJECT
+	}
+
+	result.URL = os.Getenv("NATS_URL")
+	result.CredentialsFile = os.Getenv("NATS_CREDENTIALS_FILE")
+	result.Stream = joinNamespace("_", stream, tenant)
+	result.Subject = joinNamespace(".", subject, tenant)
+	result.Consumer = os.Getenv("NATS_CONSUMER")
+	result.AckWait = ackWait
+	result.MaxDeliver = maxDeliver
+
+	return result, nil
+}
+
+func getProviderOptions() (data.ProviderOptions, error) {
+	result := data.ProviderOptions{}
+
+	statusTTL, err := getStatusTTL()
+	if err != nil {
+		return result, err
+	}
+
+	starvationTimeout, err := getOptionalDuration("QUEUE_STARVATION_TIMEOUT")
+	if err != nil {
+		return result, err
+	}
+
+	eventLogSize, err := getOptionalInt("EVENT_LOG_SIZE")
+	if err != nil {
+		return result, err
+	}
+
+	eventLogTTL, err := getOptionalDuration("EVENT_LOG_TTL")
+	if err != nil {
+		return result, err
+	}
+
+	result.StatusTTL = statusTTL
+	result.StarvationTimeout = starvationTimeout
+	result.EventLogSize = eventLogSize
+	result.EventLogTTL = eventLogTTL
+
+	return result, nil
+}
+
+func getStatusTTL() (map[string]time.Duration, error) {
+	failedTTL, err := getOptionalDuration("REQUEST_FAILED_TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	occupiedTTL, err := getOptionalDuration("REQUEST_OCCUPIED_TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	//DONE request is useless after server dropped the reservation
+	reservationLifetime, err := getOptionalDuration("RESERVATION_LIFETIME")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]time.Duration{
+		common.FAILED:   failedTTL,
+		common.OCCUPIED: occupiedTTL,
+		common.DONE:     reservationLifetime,
+	}, nil
+}
+
+// parses comma separated list, empty items are skipped
+func getList(name string) []string {
+	result := []string{}
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// empty variable is treated as zero
+func getOptionalInt(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// empty variable is treated as false
+func getOptionalBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+// parses duration in ms, empty variable is treated as zero
+func getOptionalDuration(name string) (time.Duration, error) {
+	number, err := getOptionalInt(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(number) * time.Millisecond, nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTenants(t *testing.T) {
+	t.Setenv("TENANTS", "")
+	tenants, err := GetTenants()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{""}, tenants)
+
+	t.Setenv("TENANTS", "game1, game-2")
+	tenants, err = GetTenants()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"game1", "game-2"}, tenants)
+
+	t.Setenv("TENANTS", "game1,Game:2")
+	_, err = GetTenants()
+	assert.Error(t, err)
+
+	t.Setenv("TENANTS", "game1,game1")
+	_, err = GetTenants()
+	assert.Error(t, err)
+
+	//both tenants would read TENANT_GAME_2_ variables
+	t.Setenv("TENANTS", "game-2,game_2")
+	_, err = GetTenants()
+	assert.Error(t, err)
+}
+
+func TestGetDefaultTenant(t *testing.T) {
+	tenants := []string{"game1", "game2"}
+
+	t.Setenv("DEFAULT_TENANT", "")
+	defaultTenant, err := GetDefaultTenant(tenants)
+	assert.NoError(t, err)
+	assert.Equal(t, "", defaultTenant)
+
+	t.Setenv("DEFAULT_TENANT", "game2")
+	defaultTenant, err = GetDefaultTenant(tenants)
+	assert.NoError(t, err)
+	assert.Equal(t, "game2", defaultTenant)
+
+	t.Setenv("DEFAULT_TENANT", "game3")
+	_, err = GetDefaultTenant(tenants)
+	assert.Error(t, err)
+}
+
+func TestGetTenantEnv(t *testing.T) {
+	t.Setenv("IMAGE_TO_PULL", "shared")
+	t.Setenv("TENANT_GAME_2_IMAGE_TO_PULL", "game2")
+
+	assert.Equal(t, "shared", GetTenantEnv("", "IMAGE_TO_PULL"))
+	assert.Equal(t, "shared", GetTenantEnv("game1", "IMAGE_TO_PULL"))
+	assert.Equal(t, "game2", GetTenantEnv("game-2", "IMAGE_TO_PULL"))
+
+	//set but empty variable still overrides shared one
+	t.Setenv("TENANT_GAME1_IMAGE_TO_PULL", "")
+	assert.Equal(t, "", GetTenantEnv("game1", "IMAGE_TO_PULL"))
+}
+
+func TestDataNamespace(t *testing.T) {
+	t.Setenv("REDIS_KEY_PREFIX", "matchmaker")
+	t.Setenv("POSTGRES_SCHEMA", "")
+
+	redisOptions, err := getRedisOptions("game1")
+	assert.NoError(t, err)
+	assert.Equal(t, "matchmaker:game1", redisOptions.KeyPrefix)
+
+	redisOptions, err = getRedisOptions("")
+	assert.NoError(t, err)
+	assert.Equal(t, "matchmaker", redisOptions.KeyPrefix)
+
+	postgresOptions, err := getPostgresOptions("game1")
+	assert.NoError(t, err)
+	assert.Equal(t, "game1", postgresOptions.Schema)
+
+	t.Setenv("NATS_STREAM", "")
+	t.Setenv("NATS_SUBJECT", "")
+
+	natsOptions, err := getNatsOptions("game1")
+	assert.NoError(t, err)
+	assert.Equal(t, "MATCHMAKER_game1", natsOptions.Stream)
+	assert.Equal(t, "matchmaker.queue.game1", natsOptions.Subject)
+
+	natsOptions, err = getNatsOptions("")
+	assert.NoError(t, err)
+	assert.Equal(t, "MATCHMAKER", natsOptions.Stream)
+	assert.Equal(t, "matchmaker.queue", natsOptions.Subject)
+}
+
+func TestMemoryDataBackend(t *testing.T) {
+	//separate API and Maker processes can't share memory backend
+	t.Setenv("DATA_BACKEND", "memory")
+	t.Setenv("QUEUE_BACKEND", "")
+	_, err := CreateDataProvider("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,15 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+)
+
+func TestConformance(t *testing.T) {
+	for _, backend := range datatest.Backends() {
+		t.Run(backend.Name, func(t *testing.T) {
+			datatest.RunConformance(t, backend.Create)
+		})
+	}
+}
@@ -1,9 +1,239 @@
 package data
 
-import "github.com/st-matskevich/go-matchmaker/common"
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"time"
 
-type DataProvider interface {
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+// returned by backends that can't do the operation, e.g. queue position in NATS
+var ErrNotSupported = errors.New("operation is not supported by data backend")
+
+// used if ProviderOptions.StarvationTimeout is not set
+const DEFAULT_STARVATION_TIMEOUT = time.Minute
+
+// used if ProviderOptions.EventLogSize or ProviderOptions.EventLogTTL is not set
+const DEFAULT_EVENT_LOG_SIZE = 100
+const DEFAULT_EVENT_LOG_TTL = 24 * time.Hour
+
+// DONE record is kept after reservation of not joined client expires, so client
+// that polls late gets expiration reported
+const DONE_EXPIRED_GRACE = time.Minute
+
+type RequestStore interface {
+	Get(ID string) (*common.RequestBody, error)
 	Set(req common.RequestBody) (*common.RequestBody, error)
-	ListPush(ID string) error
-	ListPop() (string, error)
+}
+
+type Queue interface {
+	//ID is not popped before delay passes, pushing already queued ID moves it
+	ListPush(ID string, priority int, delay time.Duration) error
+	//waits until ID is available or ctx is done, ID popped before cancellation is
+	//returned, so it is not lost
+	ListPop(ctx context.Context) (string, error)
+	//confirms that popped ID was processed, queues with redelivery return
+	//not confirmed IDs to the queue
+	ListAck(ID string) error
+	//number of queued IDs, delayed ones included
+	ListLength() (int, error)
+	//number of IDs that are popped before ID, delayed IDs are counted after
+	//visible ones, -1 if ID is not queued
+	ListPosition(ID string) (int, error)
+}
+
+// requests that ran out of attempts, ordered by push time
+type DeadLetterQueue interface {
+	DeadLetterPush(ID string) error
+	DeadLetterList() ([]string, error)
+	DeadLetterRemove(ID string) (bool, error)
+}
+
+// append-only history of request, only last events are kept and the whole
+// log expires after last push
+type EventLog interface {
+	EventPush(ID string, event common.RequestEvent) error
+	EventList(ID string) ([]common.RequestEvent, error)
+}
+
+// LogEvent pushes event with current time and source, events are only for
+// troubleshooting, so failed push is logged and doesn't fail the caller
+func LogEvent(events EventLog, source string, ID string, event common.RequestEvent) {
+	event.Time = time.Now()
+	event.Source = source
+	err := events.EventPush(ID, event)
+	if err != nil {
+		log.Printf("Failed to log request %v event: %v", ID, err)
+	}
+}
+
+// game servers of tenant with lifecycle state, written by Maker for admin view
+type ServerStore interface {
+	//replaces server with same ID
+	ServerSet(server common.ServerStatus) error
+	ServerRemove(ID string) error
+	//ordered by ID
+	ServerList() ([]common.ServerStatus, error)
+}
+
+type DataProvider interface {
+	RequestStore
+	Queue
+	DeadLetterQueue
+	EventLog
+	ServerStore
+}
+
+// ExpiredSweeper is implemented by backends that don't remove expired request
+// records by themselves, they sweep them periodically on writes
+type ExpiredSweeper interface {
+	//removes expired request records, returns number of removed records
+	SweepExpired() (int, error)
+}
+
+// ExhaustibleQueue drops ID after delivery limit, e.g. if Makers crash on its request,
+// and reports it to handler
+type ExhaustibleQueue interface {
+	Queue
+	OnExhausted(handler func(ID string))
+}
+
+// reason of requests dropped by ExhaustibleQueue
+const EXHAUSTED_REASON = "queue delivery limit is reached"
+
+// queueDataProvider uses standalone queue instead of the one from data backend
+type queueDataProvider struct {
+	DataProvider
+	queue Queue
+}
+
+func (provider *queueDataProvider) ListPush(ID string, priority int, delay time.Duration) error {
+	return provider.queue.ListPush(ID, priority, delay)
+}
+
+func (provider *queueDataProvider) ListPop(ctx context.Context) (string, error) {
+	return provider.queue.ListPop(ctx)
+}
+
+func (provider *queueDataProvider) ListAck(ID string) error {
+	return provider.queue.ListAck(ID)
+}
+
+func (provider *queueDataProvider) ListLength() (int, error) {
+	return provider.queue.ListLength()
+}
+
+func (provider *queueDataProvider) ListPosition(ID string) (int, error) {
+	return provider.queue.ListPosition(ID)
+}
+
+// dropped request is failed as request that ran out of attempts, so client gets 503
+// instead of waiting for request that is never popped again
+func (provider *queueDataProvider) failExhausted(ID string) {
+	request, err := provider.Get(ID)
+	if err != nil {
+		log.Printf("Failed to get exhausted request %v: %v", ID, err)
+		return
+	}
+
+	if request == nil || (request.Status != common.CREATED && request.Status != common.IN_PROGRESS) {
+		return
+	}
+
+	request.Status = common.FAILED
+	request.Reason = EXHAUSTED_REASON
+	_, err = provider.Set(*request)
+	if err != nil {
+		log.Printf("Failed to fail exhausted request %v: %v", ID, err)
+		return
+	}
+	LogEvent(provider, "", ID, common.RequestEvent{Type: common.EVENT_FAILED, Message: EXHAUSTED_REASON})
+
+	err = provider.DeadLetterPush(ID)
+	if err != nil {
+		log.Printf("Failed to move exhausted request %v to dead-letter queue: %v", ID, err)
+	}
+}
+
+// WithQueue returns provider that keeps requests in provider and queue in queue,
+// requests dropped by ExhaustibleQueue are moved to dead-letter queue
+func WithQueue(provider DataProvider, queue Queue) DataProvider {
+	result := &queueDataProvider{DataProvider: provider, queue: queue}
+	if exhaustible, ok := queue.(ExhaustibleQueue); ok {
+		exhaustible.OnExhausted(result.failExhausted)
+	}
+
+	return result
+}
+
+// options shared by all data backends
+type ProviderOptions struct {
+	//request records expiration by request status, statuses without TTL never expire
+	StatusTTL map[string]time.Duration
+	//longest time a request can be overtaken by requests with higher priority
+	StarvationTimeout time.Duration
+	//maximum number of events kept per request
+	EventLogSize int
+	//how long request events are kept after last event
+	EventLogTTL time.Duration
+}
+
+func (options ProviderOptions) eventLogSize() int {
+	if options.EventLogSize <= 0 {
+		return DEFAULT_EVENT_LOG_SIZE
+	}
+
+	return options.EventLogSize
+}
+
+func (options ProviderOptions) eventLogTTL() time.Duration {
+	if options.EventLogTTL <= 0 {
+		return DEFAULT_EVENT_LOG_TTL
+	}
+
+	return options.EventLogTTL
+}
+
+// DONE record of not joined client expires with its reservation, StatusTTL is used
+// if server doesn't report reservation expiration. Zero TTL means record never expires
+func (options ProviderOptions) requestTTL(req common.RequestBody, now time.Time) time.Duration {
+	if req.Status != common.DONE || req.Joined || req.ExpiresAt <= 0 {
+		return options.StatusTTL[req.Status]
+	}
+
+	ttl := time.UnixMilli(req.ExpiresAt).Add(DONE_EXPIRED_GRACE).Sub(now)
+	if ttl < time.Millisecond {
+		return time.Millisecond
+	}
+
+	return ttl
+}
+
+// unchanged DONE record is written back on every poll of the client, it keeps
+// its TTL, so polls don't extend it
+func keepsTTL(prev common.RequestBody, req common.RequestBody) bool {
+	return req.Status == common.DONE && reflect.DeepEqual(prev, req)
+}
+
+// queueScore orders queue items, lowest score is popped first. Priority works as
+// extra waiting time, so request with PRIORITY_MAX is served before requests with
+// PRIORITY_NORMAL that were pushed less than starvationTimeout ago, but never
+// before older ones. Microseconds fit into float64 exactly, so score can be
+// used as Redis sorted set score.
+func queueScore(priority int, starvationTimeout time.Duration, now time.Time) int64 {
+	if starvationTimeout <= 0 {
+		starvationTimeout = DEFAULT_STARVATION_TIMEOUT
+	}
+
+	if priority < common.PRIORITY_NORMAL {
+		priority = common.PRIORITY_NORMAL
+	} else if priority > common.PRIORITY_MAX {
+		priority = common.PRIORITY_MAX
+	}
+
+	boost := starvationTimeout.Microseconds() * int64(priority) / common.PRIORITY_MAX
+	return now.UnixMicro() - boost
 }
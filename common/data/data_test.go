@@ -0,0 +1,33 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueScore(t *testing.T) {
+	timeout := 10 * time.Second
+	now := time.Now()
+
+	normal := queueScore(common.PRIORITY_NORMAL, timeout, now)
+	max := queueScore(common.PRIORITY_MAX, timeout, now)
+	assert.Less(t, max, normal)
+
+	//request with max priority overtakes requests pushed less than timeout ago
+	overtaking := queueScore(common.PRIORITY_MAX, timeout, now.Add(timeout-time.Millisecond))
+	assert.Less(t, overtaking, normal)
+
+	//but not older ones, so normal requests are not starved
+	starving := queueScore(common.PRIORITY_MAX, timeout, now.Add(timeout+time.Millisecond))
+	assert.Greater(t, starving, normal)
+
+	//priority is clamped to allowed range
+	assert.Equal(t, max, queueScore(common.PRIORITY_MAX+5, timeout, now))
+	assert.Equal(t, normal, queueScore(-1, timeout, now))
+
+	//default timeout is used if not set
+	assert.Equal(t, queueScore(common.PRIORITY_MAX, DEFAULT_STARVATION_TIMEOUT, now), queueScore(common.PRIORITY_MAX, 0, now))
+}
@@ -0,0 +1,443 @@
+package datatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// PostgreSQL tests are skipped if variable is not set
+const POSTGRES_TEST_URL_ENV = "POSTGRES_TEST_URL"
+
+const POP_TIMEOUT = 5 * time.Second
+
+type Backend struct {
+	Name   string
+	Create func(t *testing.T) data.DataProvider
+}
+
+// Backends returns all data backends that can be started in current environment,
+// each Create call returns provider with empty storage
+func Backends() []Backend {
+	result := []Backend{
+		{Name: data.MEMORY_DATA_BACKEND, Create: createMemory},
+		{Name: data.REDIS_DATA_BACKEND, Create: createRedis},
+	}
+
+	if os.Getenv(POSTGRES_TEST_URL_ENV) != "" {
+		result = append(result, Backend{Name: data.POSTGRES_DATA_BACKEND, Create: createPostgres})
+	}
+
+	return result
+}
+
+func createMemory(t *testing.T) data.DataProvider {
+	return data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+}
+
+func createRedis(t *testing.T) data.DataProvider {
+	server := miniredis.RunT(t)
+	provider, err := data.CreateRedisDataProvider(data.RedisDataProviderOptions{Addresses: []string{server.Addr()}})
+	require.NoError(t, err)
+
+	return provider
+}
+
+func createPostgres(t *testing.T) data.DataProvider {
+	provider, err := data.CreatePostgresDataProvider(PostgresOptions(t))
+	require.NoError(t, err)
+
+	return provider
+}
+
+// PostgresOptions returns options with unique schema, that is dropped after the test
+func PostgresOptions(t *testing.T) data.PostgresDataProviderOptions {
+	url := os.Getenv(POSTGRES_TEST_URL_ENV)
+	if url == "" {
+		t.Skip(POSTGRES_TEST_URL_ENV + " is not set")
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		ctx := context.Background()
+		pool, err := pgxpool.New(ctx, url)
+		if err != nil {
+			return
+		}
+		defer pool.Close()
+
+		pool.Exec(ctx, "DROP SCHEMA IF EXISTS "+pgx.Identifier{schema}.Sanitize()+" CASCADE")
+	})
+
+	return data.PostgresDataProviderOptions{URL: url, Schema: schema}
+}
+
+// NatsQueueOptions starts embedded NATS server with JetStream, that is stopped after the test
+func NatsQueueOptions(t *testing.T) data.NatsQueueOptions {
+	server, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	server.Start()
+	t.Cleanup(server.Shutdown)
+	if !server.ReadyForConnections(POP_TIMEOUT) {
+		require.FailNow(t, "nats server is not ready")
+	}
+
+	return data.NatsQueueOptions{URL: server.ClientURL()}
+}
+
+// GetRequest reads request, fails the test on provider error
+func GetRequest(t *testing.T, provider data.DataProvider, ID string) *common.RequestBody {
+	result, err := provider.Get(ID)
+	require.NoError(t, err)
+
+	return result
+}
+
+// PopN pops exactly count IDs from the queue, fails if queue doesn't have them in POP_TIMEOUT
+func PopN(t *testing.T, provider data.Queue, count int) []string {
+	result := []string{}
+	for i := 0; i < count; i++ {
+		popped := make(chan string, 1)
+		go func() {
+			ID, err := provider.ListPop(context.Background())
+			assert.NoError(t, err)
+			popped <- ID
+		}()
+
+		select {
+		case ID := <-popped:
+			result = append(result, ID)
+		case <-time.After(POP_TIMEOUT):
+			require.FailNow(t, "queue pop timed out")
+		}
+	}
+
+	return result
+}
+
+// RunConformance verifies that provider follows semantics expected by API and Maker services
+func RunConformance(t *testing.T, create func(t *testing.T) data.DataProvider) {
+	t.Run("set returns previous request", func(t *testing.T) {
+		provider := create(t)
+
+		prev, err := provider.Set(common.RequestBody{ID: "client1", Status: common.CREATED})
+		assert.NoError(t, err)
+		assert.Nil(t, prev)
+
+		done := common.RequestBody{ID: "client1", Status: common.DONE, Container: "container1", ServerPort: "45677"}
+		prev, err = provider.Set(done)
+		assert.NoError(t, err)
+		assert.Equal(t, &common.RequestBody{ID: "client1", Status: common.CREATED}, prev)
+
+		prev, err = provider.Set(common.RequestBody{ID: "client1", Status: common.OCCUPIED})
+		assert.NoError(t, err)
+		assert.Equal(t, &done, prev)
+
+		//requests are independent
+		prev, err = provider.Set(common.RequestBody{ID: "client2", Status: common.CREATED})
+		assert.NoError(t, err)
+		assert.Nil(t, prev)
+	})
+
+	t.Run("get returns current request", func(t *testing.T) {
+		provider := create(t)
+
+		result, err := provider.Get("client1")
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+
+		request := common.RequestBody{ID: "client1", Status: common.FAILED, Attempts: 2, Reason: "no capacity"}
+		_, err = provider.Set(request)
+		assert.NoError(t, err)
+
+		result, err = provider.Get("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, &request, result)
+	})
+
+	t.Run("sweep removes expired requests", func(t *testing.T) {
+		provider := create(t)
+		sweeper, ok := provider.(data.ExpiredSweeper)
+		if !ok {
+			t.Skip("backend removes expired requests by itself")
+		}
+
+		//reservation that expired before grace period gets shortest TTL
+		expiresAt := time.Now().Add(-2 * data.DONE_EXPIRED_GRACE).UnixMilli()
+		_, err := provider.Set(common.RequestBody{ID: "client1", Status: common.DONE, ExpiresAt: expiresAt})
+		assert.NoError(t, err)
+		_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.CREATED})
+		assert.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+
+		removed, err := sweeper.SweepExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		removed, err = sweeper.SweepExpired()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, removed)
+		assert.NotNil(t, GetRequest(t, provider, "client2"))
+	})
+
+	RunQueueConformance(t, func(t *testing.T) data.Queue { return create(t) })
+
+	t.Run("higher priority is popped first", func(t *testing.T) {
+		provider := create(t)
+
+		assert.NoError(t, provider.ListPush("normal", common.PRIORITY_NORMAL, 0))
+		assert.NoError(t, provider.ListPush("high", common.PRIORITY_MAX/2, 0))
+		assert.NoError(t, provider.ListPush("max", common.PRIORITY_MAX, 0))
+
+		assert.Equal(t, []string{"max", "high", "normal"}, PopN(t, provider, 3))
+	})
+
+	t.Run("push of queued ID moves it", func(t *testing.T) {
+		provider := create(t)
+
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+		assert.NoError(t, provider.ListPush("client2", common.PRIORITY_NORMAL, 0))
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_MAX, 0))
+
+		assert.Equal(t, []string{"client1", "client2"}, PopN(t, provider, 2))
+	})
+
+	t.Run("push without delay cancels previous delay", func(t *testing.T) {
+		provider := create(t)
+
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, time.Hour))
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+
+		assert.Equal(t, []string{"client1"}, PopN(t, provider, 1))
+	})
+
+	t.Run("dead letter queue keeps push order", func(t *testing.T) {
+		provider := create(t)
+
+		list, err := provider.DeadLetterList()
+		assert.NoError(t, err)
+		assert.Empty(t, list)
+
+		for _, ID := range []string{"client1", "client2", "client3"} {
+			assert.NoError(t, provider.DeadLetterPush(ID))
+		}
+
+		removed, err := provider.DeadLetterRemove("client2")
+		assert.NoError(t, err)
+		assert.True(t, removed)
+
+		removed, err = provider.DeadLetterRemove("client2")
+		assert.NoError(t, err)
+		assert.False(t, removed)
+
+		list, err = provider.DeadLetterList()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"client1", "client3"}, list)
+	})
+
+	t.Run("event log keeps last events", func(t *testing.T) {
+		provider := create(t)
+
+		events, err := provider.EventList("client1")
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+
+		count := data.DEFAULT_EVENT_LOG_SIZE + 5
+		for i := 0; i < count; i++ {
+			event := common.RequestEvent{Time: time.Unix(int64(i), 0).UTC(), Type: common.EVENT_RESERVATION, Code: i}
+			assert.NoError(t, provider.EventPush("client1", event))
+		}
+		assert.NoError(t, provider.EventPush("client2", common.RequestEvent{Type: common.EVENT_CREATED}))
+
+		events, err = provider.EventList("client1")
+		assert.NoError(t, err)
+		if assert.Len(t, events, data.DEFAULT_EVENT_LOG_SIZE) {
+			assert.Equal(t, common.RequestEvent{Time: time.Unix(5, 0).UTC(), Type: common.EVENT_RESERVATION, Code: 5}, events[0])
+			assert.Equal(t, count-1, events[len(events)-1].Code)
+		}
+
+		//logs are independent
+		events, err = provider.EventList("client2")
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("server store replaces servers", func(t *testing.T) {
+		provider := create(t)
+
+		servers, err := provider.ServerList()
+		assert.NoError(t, err)
+		assert.Empty(t, servers)
+
+		updatedAt := time.Unix(10, 0).UTC()
+		assert.NoError(t, provider.ServerSet(common.ServerStatus{ID: "container2", State: common.SERVER_CREATING, UpdatedAt: updatedAt}))
+		assert.NoError(t, provider.ServerSet(common.ServerStatus{ID: "container1", Address: "container1", State: common.SERVER_READY, UpdatedAt: updatedAt}))
+		assert.NoError(t, provider.ServerSet(common.ServerStatus{ID: "container2", Address: "container2", State: common.SERVER_ALLOCATED, UpdatedAt: updatedAt}))
+		assert.NoError(t, provider.ServerSet(common.ServerStatus{ID: "container3", State: common.SERVER_SHUTDOWN, UpdatedAt: updatedAt}))
+		assert.NoError(t, provider.ServerRemove("container3"))
+
+		servers, err = provider.ServerList()
+		assert.NoError(t, err)
+		assert.Equal(t, []common.ServerStatus{
+			{ID: "container1", Address: "container1", State: common.SERVER_READY, UpdatedAt: updatedAt},
+			{ID: "container2", Address: "container2", State: common.SERVER_ALLOCATED, UpdatedAt: updatedAt},
+		}, servers)
+	})
+}
+
+// RunQueueConformance verifies queue semantics that Maker relies on, queues without
+// priority support are verified only with it
+func RunQueueConformance(t *testing.T, create func(t *testing.T) data.Queue) {
+	t.Run("queue is FIFO", func(t *testing.T) {
+		provider := create(t)
+
+		for _, ID := range []string{"client1", "client2", "client3"} {
+			assert.NoError(t, provider.ListPush(ID, common.PRIORITY_NORMAL, 0))
+		}
+
+		assert.Equal(t, []string{"client1", "client2", "client3"}, PopN(t, provider, 3))
+	})
+
+	t.Run("push of queued ID moves it", func(t *testing.T) {
+		provider := create(t)
+
+		for _, ID := range []string{"client1", "client2", "client1"} {
+			assert.NoError(t, provider.ListPush(ID, common.PRIORITY_NORMAL, 0))
+		}
+
+		length, err := provider.ListLength()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, length)
+		assert.Equal(t, []string{"client2", "client1"}, PopN(t, provider, 2))
+	})
+
+	t.Run("pop waits for push", func(t *testing.T) {
+		provider := create(t)
+
+		pushed := make(chan struct{})
+		go func() {
+			defer close(pushed)
+			time.Sleep(100 * time.Millisecond)
+			assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+		}()
+
+		assert.Equal(t, []string{"client1"}, PopN(t, provider, 1))
+		//push can still be running after pushed ID is popped
+		<-pushed
+	})
+
+	t.Run("pop returns on cancel", func(t *testing.T) {
+		provider := create(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+
+		_, err := provider.ListPop(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		//canceled pop should not take pushed ID
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+		assert.Equal(t, []string{"client1"}, PopN(t, provider, 1))
+	})
+
+	t.Run("delayed ID is not popped before delay", func(t *testing.T) {
+		provider := create(t)
+		delay := 500 * time.Millisecond
+
+		start := time.Now()
+		assert.NoError(t, provider.ListPush("delayed", common.PRIORITY_NORMAL, delay))
+		assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+
+		assert.Equal(t, []string{"client1", "delayed"}, PopN(t, provider, 2))
+		assert.GreaterOrEqual(t, time.Since(start), delay)
+	})
+
+	t.Run("queue reports length and position", func(t *testing.T) {
+		provider := create(t)
+
+		assert.NoError(t, provider.ListPush("delayed", common.PRIORITY_NORMAL, time.Hour))
+		for _, ID := range []string{"client1", "client2", "client3"} {
+			assert.NoError(t, provider.ListPush(ID, common.PRIORITY_NORMAL, 0))
+		}
+
+		length, err := provider.ListLength()
+		assert.NoError(t, err)
+		assert.Equal(t, 4, length)
+
+		//queues without position support are checked only for length
+		position, err := provider.ListPosition("client3")
+		if !errors.Is(err, data.ErrNotSupported) {
+			assert.NoError(t, err)
+			assert.Equal(t, 2, position)
+
+			position, err = provider.ListPosition("delayed")
+			assert.NoError(t, err)
+			assert.Equal(t, 3, position)
+
+			position, err = provider.ListPosition("unknown")
+			assert.NoError(t, err)
+			assert.Equal(t, -1, position)
+		}
+
+		ID, err := provider.ListPop(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, provider.ListAck(ID))
+
+		length, err = provider.ListLength()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, length)
+	})
+
+	t.Run("concurrent pops get distinct IDs", func(t *testing.T) {
+		provider := create(t)
+		count := 20
+
+		for i := 0; i < count; i++ {
+			assert.NoError(t, provider.ListPush(fmt.Sprintf("client%d", i), common.PRIORITY_NORMAL, 0))
+		}
+
+		mutex := sync.Mutex{}
+		popped := map[string]bool{}
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				//queue is filled, so pops don't block
+				for j := 0; j < count/4; j++ {
+					ID, err := provider.ListPop(context.Background())
+					assert.NoError(t, err)
+
+					mutex.Lock()
+					popped[ID] = true
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Len(t, popped, count)
+	})
+}
@@ -0,0 +1,351 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+const MEMORY_DATA_BACKEND = "memory"
+
+// expired records are removed from memory once per this number of writes
+const MEMORY_SWEEP_INTERVAL = 1000
+
+type memoryRecord struct {
+	request common.RequestBody
+	//zero value means record never expires
+	expiresAt time.Time
+}
+
+func (record *memoryRecord) expired(now time.Time) bool {
+	return !record.expiresAt.IsZero() && !now.Before(record.expiresAt)
+}
+
+type memoryEventLog struct {
+	events    []common.RequestEvent
+	expiresAt time.Time
+}
+
+type memoryQueueItem struct {
+	ID        string
+	score     int64
+	visibleAt time.Time
+}
+
+// MemoryDataProvider keeps requests and queue in process memory, so it can be
+// shared only by API and Maker running in the same process
+type MemoryDataProvider struct {
+	mutex sync.Mutex
+	//closed and replaced on every push to wake up all waiting pops
+	pushed chan struct{}
+
+	requests map[string]memoryRecord
+	//sorted by score, items with equal score keep push order
+	queue      []memoryQueueItem
+	deadLetter []string
+	events     map[string]memoryEventLog
+	servers    map[string]common.ServerStatus
+	writes     int
+
+	options ProviderOptions
+}
+
+func (provider *MemoryDataProvider) Get(ID string) (*common.RequestBody, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	record, ok := provider.requests[ID]
+	if !ok || record.expired(time.Now()) {
+		return nil, nil
+	}
+
+	return &record.request, nil
+}
+
+func (provider *MemoryDataProvider) Set(req common.RequestBody) (*common.RequestBody, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	now := time.Now()
+	provider.writes++
+	if provider.writes%MEMORY_SWEEP_INTERVAL == 0 {
+		provider.sweepExpired(now)
+	}
+
+	var result *common.RequestBody = nil
+	record := memoryRecord{request: req}
+	if ttl := provider.options.requestTTL(req, now); ttl > 0 {
+		record.expiresAt = now.Add(ttl)
+	}
+
+	if prev, ok := provider.requests[req.ID]; ok && !prev.expired(now) {
+		result = &prev.request
+		if keepsTTL(prev.request, req) {
+			record.expiresAt = prev.expiresAt
+		}
+	}
+	provider.requests[req.ID] = record
+
+	return result, nil
+}
+
+func (provider *MemoryDataProvider) SweepExpired() (int, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return provider.sweepExpired(time.Now()), nil
+}
+
+// should be called with locked mutex, expired event logs are removed too
+func (provider *MemoryDataProvider) sweepExpired(now time.Time) int {
+	removed := 0
+	for ID, record := range provider.requests {
+		if record.expired(now) {
+			delete(provider.requests, ID)
+			removed++
+		}
+	}
+	for ID, log := range provider.events {
+		if !now.Before(log.expiresAt) {
+			delete(provider.events, ID)
+		}
+	}
+
+	return removed
+}
+
+func (provider *MemoryDataProvider) ListPush(ID string, priority int, delay time.Duration) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	for i, item := range provider.queue {
+		if item.ID == ID {
+			provider.queue = append(provider.queue[:i], provider.queue[i+1:]...)
+			break
+		}
+	}
+
+	visibleAt := time.Now().Add(delay)
+	item := memoryQueueItem{
+		ID:        ID,
+		score:     queueScore(priority, provider.options.StarvationTimeout, visibleAt),
+		visibleAt: visibleAt,
+	}
+	index := sort.Search(len(provider.queue), func(i int) bool { return provider.queue[i].score > item.score })
+	provider.queue = append(provider.queue, memoryQueueItem{})
+	copy(provider.queue[index+1:], provider.queue[index:])
+	provider.queue[index] = item
+
+	close(provider.pushed)
+	provider.pushed = make(chan struct{})
+
+	return nil
+}
+
+func (provider *MemoryDataProvider) ListPop(ctx context.Context) (string, error) {
+	for {
+		provider.mutex.Lock()
+		now := time.Now()
+		//negative wait means there are no delayed items
+		wait := time.Duration(-1)
+		for i, item := range provider.queue {
+			if !item.visibleAt.After(now) {
+				provider.queue = append(provider.queue[:i], provider.queue[i+1:]...)
+				provider.mutex.Unlock()
+				return item.ID, nil
+			}
+
+			until := item.visibleAt.Sub(now)
+			if wait < 0 || until < wait {
+				wait = until
+			}
+		}
+		pushed := provider.pushed
+		provider.mutex.Unlock()
+
+		if wait < 0 {
+			select {
+			case <-pushed:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-pushed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// popped IDs are removed from the queue right away
+func (provider *MemoryDataProvider) ListAck(ID string) error {
+	return nil
+}
+
+func (provider *MemoryDataProvider) ListLength() (int, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return len(provider.queue), nil
+}
+
+func (provider *MemoryDataProvider) ListPosition(ID string) (int, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	now := time.Now()
+	var target *memoryQueueItem
+	for i := range provider.queue {
+		if provider.queue[i].ID == ID {
+			target = &provider.queue[i]
+			break
+		}
+	}
+
+	if target == nil {
+		return -1, nil
+	}
+
+	//visible items are popped by score, delayed ones after them by visibility time
+	targetVisible := !target.visibleAt.After(now)
+	position := 0
+	for _, item := range provider.queue {
+		if item.ID == ID {
+			if targetVisible {
+				break
+			}
+			continue
+		}
+
+		visible := !item.visibleAt.After(now)
+		if visible || (!targetVisible && item.visibleAt.Before(target.visibleAt)) {
+			position++
+		}
+	}
+
+	return position, nil
+}
+
+func (provider *MemoryDataProvider) DeadLetterPush(ID string) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	provider.removeDeadLetter(ID)
+	provider.deadLetter = append(provider.deadLetter, ID)
+
+	return nil
+}
+
+func (provider *MemoryDataProvider) DeadLetterList() ([]string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return append([]string{}, provider.deadLetter...), nil
+}
+
+func (provider *MemoryDataProvider) DeadLetterRemove(ID string) (bool, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	return provider.removeDeadLetter(ID), nil
+}
+
+func (provider *MemoryDataProvider) removeDeadLetter(ID string) bool {
+	for i, item := range provider.deadLetter {
+		if item == ID {
+			provider.deadLetter = append(provider.deadLetter[:i], provider.deadLetter[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (provider *MemoryDataProvider) EventPush(ID string, event common.RequestEvent) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	now := time.Now()
+	log, ok := provider.events[ID]
+	if !ok || !now.Before(log.expiresAt) {
+		log = memoryEventLog{}
+	}
+
+	log.events = append(log.events, event)
+	if size := provider.options.eventLogSize(); len(log.events) > size {
+		log.events = append([]common.RequestEvent{}, log.events[len(log.events)-size:]...)
+	}
+	log.expiresAt = now.Add(provider.options.eventLogTTL())
+	provider.events[ID] = log
+
+	return nil
+}
+
+func (provider *MemoryDataProvider) EventList(ID string) ([]common.RequestEvent, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	log, ok := provider.events[ID]
+	if !ok || !time.Now().Before(log.expiresAt) {
+		return []common.RequestEvent{}, nil
+	}
+
+	return append([]common.RequestEvent{}, log.events...), nil
+}
+
+func (provider *MemoryDataProvider) ServerSet(server common.ServerStatus) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	provider.servers[server.ID] = server
+	return nil
+}
+
+func (provider *MemoryDataProvider) ServerRemove(ID string) error {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	delete(provider.servers, ID)
+	return nil
+}
+
+func (provider *MemoryDataProvider) ServerList() ([]common.ServerStatus, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	result := []common.ServerStatus{}
+	for _, server := range provider.servers {
+		result = append(result, server)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result, nil
+}
+
+type MemoryDataProviderOptions struct {
+	ProviderOptions
+}
+
+func CreateMemoryDataProvider(options MemoryDataProviderOptions) DataProvider {
+	provider := MemoryDataProvider{
+		pushed:     make(chan struct{}),
+		requests:   map[string]memoryRecord{},
+		queue:      []memoryQueueItem{},
+		deadLetter: []string{},
+		events:     map[string]memoryEventLog{},
+		servers:    map[string]common.ServerStatus{},
+		options:    options.ProviderOptions,
+	}
+
+	return &provider
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStatusTTL(t *testing.T) {
+	options := MemoryDataProviderOptions{
+		ProviderOptions: ProviderOptions{
+			StatusTTL: map[string]time.Duration{
+				common.FAILED: 50 * time.Millisecond,
+			},
+		},
+	}
+	provider := CreateMemoryDataProvider(options)
+
+	_, err := provider.Set(common.RequestBody{ID: "client1", Status: common.FAILED})
+	assert.NoError(t, err)
+
+	//status without TTL should clear previous expiration
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.FAILED})
+	assert.NoError(t, err)
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.CREATED})
+	assert.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	prev, err := provider.Set(common.RequestBody{ID: "client1", Status: common.OCCUPIED})
+	assert.NoError(t, err)
+	assert.Nil(t, prev)
+
+	prev, err = provider.Set(common.RequestBody{ID: "client2", Status: common.OCCUPIED})
+	assert.NoError(t, err)
+	assert.Equal(t, &common.RequestBody{ID: "client2", Status: common.CREATED}, prev)
+}
+
+func TestMemoryDoneTTL(t *testing.T) {
+	options := MemoryDataProviderOptions{
+		ProviderOptions: ProviderOptions{
+			StatusTTL: map[string]time.Duration{common.DONE: 100 * time.Millisecond},
+		},
+	}
+	provider := CreateMemoryDataProvider(options)
+
+	//unchanged DONE record written back by poll should keep its TTL
+	done := common.RequestBody{ID: "client1", Status: common.DONE}
+	_, err := provider.Set(done)
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	_, err = provider.Set(done)
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+
+	result, err := provider.Get("client1")
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+
+	//not joined reservation expiration should be used instead of static TTL
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.DONE, ExpiresAt: expiresAt})
+	assert.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+
+	result, err = provider.Get("client2")
+	assert.NoError(t, err)
+	assert.NotNil(t, result)
+}
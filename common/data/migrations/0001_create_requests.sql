@@ -0,0 +1,12 @@
+CREATE TABLE requests (
+    id         TEXT PRIMARY KEY,
+    status     TEXT NOT NULL,
+    body       JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- NULL for statuses without TTL
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX requests_status_idx ON requests (status);
+CREATE INDEX requests_expires_at_idx ON requests (expires_at) WHERE expires_at IS NOT NULL;
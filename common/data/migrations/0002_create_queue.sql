@@ -0,0 +1,5 @@
+CREATE TABLE queue (
+    seq        BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,9 @@
+-- pushing already queued request moves it instead of adding a duplicate
+DELETE FROM queue a USING queue b WHERE a.request_id = b.request_id AND a.seq < b.seq;
+
+ALTER TABLE queue ADD COLUMN score BIGINT;
+UPDATE queue SET score = (extract(epoch FROM created_at) * 1000000)::BIGINT;
+ALTER TABLE queue ALTER COLUMN score SET NOT NULL;
+
+CREATE UNIQUE INDEX queue_request_id_idx ON queue (request_id);
+CREATE INDEX queue_score_idx ON queue (score, seq);
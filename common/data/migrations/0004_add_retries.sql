@@ -0,0 +1,7 @@
+-- microseconds since epoch, delayed items are not popped before this time
+ALTER TABLE queue ADD COLUMN visible_at BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE dead_letter (
+    request_id TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
@@ -0,0 +1,10 @@
+CREATE TABLE request_events (
+    seq        BIGSERIAL PRIMARY KEY,
+    request_id TEXT NOT NULL,
+    body       JSONB NOT NULL,
+    -- the whole log of request shares expiration of the last event
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX request_events_request_id_idx ON request_events (request_id, seq);
+CREATE INDEX request_events_expires_at_idx ON request_events (expires_at);
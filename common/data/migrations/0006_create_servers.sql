@@ -0,0 +1,4 @@
+CREATE TABLE servers (
+    id   TEXT PRIMARY KEY,
+    body JSONB NOT NULL
+);
@@ -1,6 +1,9 @@
 package data
 
 import (
+	"context"
+	"time"
+
 	"github.com/st-matskevich/go-matchmaker/common"
 	"github.com/stretchr/testify/mock"
 )
@@ -9,6 +12,16 @@ type MockDataProvider struct {
 	mock.Mock
 }
 
+func (provider *MockDataProvider) Get(ID string) (*common.RequestBody, error) {
+	args := provider.Called(ID)
+
+	var result *common.RequestBody = nil
+	if pointer, ok := args.Get(0).(*common.RequestBody); ok {
+		result = pointer
+	}
+	return result, args.Error(1)
+}
+
 func (provider *MockDataProvider) Set(req common.RequestBody) (*common.RequestBody, error) {
 	args := provider.Called(req)
 
@@ -19,12 +32,82 @@ func (provider *MockDataProvider) Set(req common.RequestBody) (*common.RequestBo
 	return result, args.Error(1)
 }
 
-func (provider *MockDataProvider) ListPush(ID string) error {
-	args := provider.Called(ID)
+func (provider *MockDataProvider) ListPush(ID string, priority int, delay time.Duration) error {
+	args := provider.Called(ID, priority, delay)
 	return args.Error(0)
 }
 
-func (provider *MockDataProvider) ListPop() (string, error) {
+func (provider *MockDataProvider) ListPop(ctx context.Context) (string, error) {
 	args := provider.Called()
 	return args.String(0), args.Error(1)
 }
+
+func (provider *MockDataProvider) ListAck(ID string) error {
+	args := provider.Called(ID)
+	return args.Error(0)
+}
+
+func (provider *MockDataProvider) ListLength() (int, error) {
+	args := provider.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (provider *MockDataProvider) ListPosition(ID string) (int, error) {
+	args := provider.Called(ID)
+	return args.Int(0), args.Error(1)
+}
+
+func (provider *MockDataProvider) DeadLetterPush(ID string) error {
+	args := provider.Called(ID)
+	return args.Error(0)
+}
+
+func (provider *MockDataProvider) DeadLetterList() ([]string, error) {
+	args := provider.Called()
+
+	var result []string = nil
+	if list, ok := args.Get(0).([]string); ok {
+		result = list
+	}
+	return result, args.Error(1)
+}
+
+func (provider *MockDataProvider) DeadLetterRemove(ID string) (bool, error) {
+	args := provider.Called(ID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (provider *MockDataProvider) EventPush(ID string, event common.RequestEvent) error {
+	args := provider.Called(ID, event)
+	return args.Error(0)
+}
+
+func (provider *MockDataProvider) EventList(ID string) ([]common.RequestEvent, error) {
+	args := provider.Called(ID)
+
+	var result []common.RequestEvent = nil
+	if list, ok := args.Get(0).([]common.RequestEvent); ok {
+		result = list
+	}
+	return result, args.Error(1)
+}
+
+func (provider *MockDataProvider) ServerSet(server common.ServerStatus) error {
+	args := provider.Called(server)
+	return args.Error(0)
+}
+
+func (provider *MockDataProvider) ServerRemove(ID string) error {
+	args := provider.Called(ID)
+	return args.Error(0)
+}
+
+func (provider *MockDataProvider) ServerList() ([]common.ServerStatus, error) {
+	args := provider.Called()
+
+	var result []common.ServerStatus = nil
+	if list, ok := args.Get(0).([]common.ServerStatus); ok {
+		result = list
+	}
+	return result, args.Error(1)
+}
@@ -0,0 +1,335 @@
+package data
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+const NATS_QUEUE_BACKEND = "nats"
+
+// used if NatsQueueOptions fields are not set
+const (
+	DEFAULT_NATS_STREAM      = "MATCHMAKER"
+	DEFAULT_NATS_SUBJECT     = "matchmaker.queue"
+	DEFAULT_NATS_CONSUMER    = "maker"
+	DEFAULT_NATS_ACK_WAIT    = 5 * time.Minute
+	DEFAULT_NATS_MAX_DELIVER = 5
+)
+
+// microseconds since epoch, message is redelivered with delay if it's popped before this time
+const NATS_VISIBLE_AT_HEADER = "Matchmaker-Visible-At"
+
+// pop fetches messages in loop and checks cancellation between fetches
+const NATS_FETCH_TIMEOUT = time.Second
+
+// each ID is published to own subject <subject>.id.<base64 ID>, so push of queued ID
+// can remove its previous message
+const NATS_ID_SUBJECT_TOKEN = "id"
+
+// NatsQueue keeps queue in JetStream stream with work queue retention. Messages
+// are delivered in publish order, priority is not supported. Popped messages
+// are removed from the stream only after ListAck, so work of crashed Maker is
+// redelivered after AckWait, popped messages are marked as in progress while
+// Maker is running, so long jobs are not redelivered. Message is dropped after MaxDeliver deliveries and
+// its ID is passed to exhausted handler.
+type NatsQueue struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	stream   jetstream.Stream
+	consumer jetstream.Consumer
+	subject  string
+	//deliveries after which message is dropped
+	maxDeliver int
+	ackWait    time.Duration
+
+	mutex sync.Mutex
+	//popped but not acknowledged messages by ID
+	pending map[string]*pendingMsgs
+	//called with IDs of dropped messages, see OnExhausted
+	exhausted func(ID string)
+}
+
+// requests with priority above common.PRIORITY_NORMAL are rejected with ErrNotSupported,
+// push of queued ID removes its previous message, so ID is moved to the end of the queue
+func (queue *NatsQueue) ListPush(ID string, priority int, delay time.Duration) error {
+	if priority > common.PRIORITY_NORMAL {
+		return ErrNotSupported
+	}
+
+	ctx := context.Background()
+	subject := queue.idSubject(ID)
+	msg := nats.NewMsg(subject)
+	msg.Data = []byte(ID)
+	if delay > 0 {
+		visibleAt := time.Now().Add(delay).UnixMicro()
+		msg.Header.Set(NATS_VISIBLE_AT_HEADER, strconv.FormatInt(visibleAt, 10))
+	}
+
+	_, err := queue.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	//acknowledge of removed message that is being processed still succeeds
+	return queue.stream.Purge(ctx, jetstream.WithPurgeSubject(subject), jetstream.WithPurgeKeep(1))
+}
+
+func (queue *NatsQueue) ListPop(ctx context.Context) (string, error) {
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		msg, err := queue.consumer.Next(jetstream.FetchMaxWait(NATS_FETCH_TIMEOUT))
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, jetstream.ErrNoMessages) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		//consumer delivers messages without limit, so dropped messages are reported
+		metadata, err := msg.Metadata()
+		if err != nil {
+			return "", err
+		}
+
+		if metadata.NumDelivered > uint64(queue.maxDeliver) {
+			err = msg.Term()
+			if err != nil {
+				return "", err
+			}
+			queue.reportExhausted(string(msg.Data()))
+			continue
+		}
+
+		//delayed message is returned to the stream until it's visible
+		if visibleAt := msg.Headers().Get(NATS_VISIBLE_AT_HEADER); visibleAt != "" {
+			micros, err := strconv.ParseInt(visibleAt, 10, 64)
+			if err != nil {
+				return "", err
+			}
+
+			wait := time.Until(time.UnixMicro(micros))
+			if wait > 0 {
+				err = msg.NakWithDelay(wait)
+				if err != nil {
+					return "", err
+				}
+				continue
+			}
+		}
+
+		ID := string(msg.Data())
+		queue.mutex.Lock()
+		pending := queue.pending[ID]
+		if pending == nil {
+			pending = &pendingMsgs{done: make(chan struct{})}
+			queue.pending[ID] = pending
+		}
+		pending.msgs = append(pending.msgs, msg)
+		queue.mutex.Unlock()
+
+		go queue.keepInProgress(msg, pending.done)
+		return ID, nil
+	}
+}
+
+// pendingMsgs are popped messages of ID, done is closed by ListAck
+type pendingMsgs struct {
+	msgs []jetstream.Msg
+	done chan struct{}
+}
+
+// ack deadline of popped message is reset every half of AckWait until it's acknowledged
+func (queue *NatsQueue) keepInProgress(msg jetstream.Msg, done <-chan struct{}) {
+	ticker := time.NewTicker(queue.ackWait / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := msg.InProgress()
+			if err != nil {
+				log.Printf("Failed to extend ack deadline of request %v: %v", string(msg.Data()), err)
+			}
+		}
+	}
+}
+
+// OnExhausted sets handler of IDs that are dropped after MaxDeliver deliveries,
+// WithQueue uses it to move their requests to dead-letter queue
+func (queue *NatsQueue) OnExhausted(handler func(ID string)) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.exhausted = handler
+}
+
+func (queue *NatsQueue) reportExhausted(ID string) {
+	queue.mutex.Lock()
+	handler := queue.exhausted
+	queue.mutex.Unlock()
+
+	log.Printf("Dropped request %v after %v deliveries", ID, queue.maxDeliver)
+	if handler != nil {
+		handler(ID)
+	}
+}
+
+// ID is encoded, so it can't contain subject wildcards and separators
+func (queue *NatsQueue) idSubject(ID string) string {
+	return queue.subject + "." + NATS_ID_SUBJECT_TOKEN + "." + base64.RawURLEncoding.EncodeToString([]byte(ID))
+}
+
+func (queue *NatsQueue) ListAck(ID string) error {
+	queue.mutex.Lock()
+	pending := queue.pending[ID]
+	delete(queue.pending, ID)
+	queue.mutex.Unlock()
+
+	if pending == nil {
+		return nil
+	}
+	close(pending.done)
+
+	//ack is confirmed by server, so acknowledged message is not counted in ListLength
+	ctx := context.Background()
+	for _, msg := range pending.msgs {
+		err := msg.DoubleAck(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close stops marking popped messages as in progress and closes connection, so they
+// are redelivered after AckWait like messages of crashed Maker
+func (queue *NatsQueue) Close() {
+	queue.mutex.Lock()
+	for _, pending := range queue.pending {
+		close(pending.done)
+	}
+	queue.pending = map[string]*pendingMsgs{}
+	queue.mutex.Unlock()
+
+	queue.conn.Close()
+}
+
+// messages that are popped but not acknowledged are counted too
+func (queue *NatsQueue) ListLength() (int, error) {
+	ctx := context.Background()
+	info, err := queue.consumer.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(info.NumPending) + info.NumAckPending, nil
+}
+
+// stream can be read only in publish order, so position is not known
+func (queue *NatsQueue) ListPosition(ID string) (int, error) {
+	return 0, ErrNotSupported
+}
+
+type NatsQueueOptions struct {
+	URL string
+	//credentials file for NATS authentication, leave empty if not needed
+	CredentialsFile string
+
+	Stream   string
+	Subject  string
+	Consumer string
+
+	//popped message is redelivered if it's not acknowledged or marked as in progress
+	//in this time, Maker marks its popped messages every half of AckWait
+	AckWait time.Duration
+	//message is dropped after this number of deliveries and its ID is reported to
+	//exhausted handler, delayed messages use one extra delivery
+	MaxDeliver int
+}
+
+func CreateNatsQueue(options NatsQueueOptions) (*NatsQueue, error) {
+	ctx := context.Background()
+	if options.Stream == "" {
+		options.Stream = DEFAULT_NATS_STREAM
+	}
+	if options.Subject == "" {
+		options.Subject = DEFAULT_NATS_SUBJECT
+	}
+	if options.Consumer == "" {
+		options.Consumer = DEFAULT_NATS_CONSUMER
+	}
+	if options.AckWait <= 0 {
+		options.AckWait = DEFAULT_NATS_ACK_WAIT
+	}
+	if options.MaxDeliver <= 0 {
+		options.MaxDeliver = DEFAULT_NATS_MAX_DELIVER
+	}
+
+	natsOptions := []nats.Option{nats.MaxReconnects(-1)}
+	if options.CredentialsFile != "" {
+		natsOptions = append(natsOptions, nats.UserCredentials(options.CredentialsFile))
+	}
+
+	conn, err := nats.Connect(options.URL, natsOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	//work queue retention removes acknowledged messages, so stream holds only queued work
+	subjects := []string{options.Subject + "." + NATS_ID_SUBJECT_TOKEN + ".*"}
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      options.Stream,
+		Subjects:  subjects,
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, options.Stream, jetstream.ConsumerConfig{
+		Durable:        options.Consumer,
+		FilterSubjects: subjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        options.AckWait,
+		//delivery limit is checked by ListPop, so dropped messages are not lost silently
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	queue := NatsQueue{
+		conn:       conn,
+		js:         js,
+		stream:     stream,
+		consumer:   consumer,
+		subject:    options.Subject,
+		maxDeliver: options.MaxDeliver,
+		ackWait:    options.AckWait,
+		pending:    map[string]*pendingMsgs{},
+	}
+
+	return &queue, nil
+}
@@ -0,0 +1,131 @@
+package data_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createNatsQueue(t *testing.T, options data.NatsQueueOptions) *data.NatsQueue {
+	queue, err := data.CreateNatsQueue(options)
+	require.NoError(t, err)
+	t.Cleanup(queue.Close)
+
+	return queue
+}
+
+func TestNatsQueueConformance(t *testing.T) {
+	datatest.RunQueueConformance(t, func(t *testing.T) data.Queue {
+		return createNatsQueue(t, datatest.NatsQueueOptions(t))
+	})
+}
+
+func TestNatsRedelivery(t *testing.T) {
+	options := datatest.NatsQueueOptions(t)
+	options.AckWait = 200 * time.Millisecond
+	queue := createNatsQueue(t, options)
+
+	assert.NoError(t, queue.ListPush("client1", common.PRIORITY_NORMAL, 0))
+	assert.NoError(t, queue.ListPush("client2", common.PRIORITY_NORMAL, 0))
+	assert.Equal(t, []string{"client1", "client2"}, datatest.PopN(t, queue, 2))
+
+	//only not acknowledged ID of closed queue should be redelivered
+	assert.NoError(t, queue.ListAck("client1"))
+	queue.Close()
+	queue = createNatsQueue(t, options)
+	assert.Equal(t, []string{"client2"}, datatest.PopN(t, queue, 1))
+	assert.NoError(t, queue.ListAck("client2"))
+
+	assert.NoError(t, queue.ListPush("client3", common.PRIORITY_NORMAL, 0))
+	assert.Equal(t, []string{"client3"}, datatest.PopN(t, queue, 1))
+}
+
+func TestNatsInProgress(t *testing.T) {
+	options := datatest.NatsQueueOptions(t)
+	options.AckWait = 200 * time.Millisecond
+	queue := createNatsQueue(t, options)
+
+	assert.NoError(t, queue.ListPush("client1", common.PRIORITY_NORMAL, 0))
+	assert.Equal(t, []string{"client1"}, datatest.PopN(t, queue, 1))
+
+	//popped ID shouldn't be redelivered while it's processed longer than AckWait
+	ctx, cancel := context.WithTimeout(context.Background(), 3*options.AckWait)
+	defer cancel()
+	_, err := queue.ListPop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, queue.ListAck("client1"))
+	length, err := queue.ListLength()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+}
+
+func TestNatsMaxDeliver(t *testing.T) {
+	options := datatest.NatsQueueOptions(t)
+	options.AckWait = 100 * time.Millisecond
+	options.MaxDeliver = 2
+	queue := createNatsQueue(t, options)
+	provider := data.WithQueue(data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{}), queue)
+
+	_, err := provider.Set(common.RequestBody{ID: "client1", Status: common.IN_PROGRESS})
+	assert.NoError(t, err)
+	assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+
+	//each delivery is popped by queue that is closed before ack
+	for i := 0; i < options.MaxDeliver; i++ {
+		crashed := createNatsQueue(t, options)
+		assert.Equal(t, []string{"client1"}, datatest.PopN(t, crashed, 1))
+		crashed.Close()
+	}
+
+	//client1 should be dropped after MaxDeliver attempts and moved to dead-letter queue
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := provider.ListPop(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	}()
+
+	assert.Eventually(t, func() bool {
+		request := datatest.GetRequest(t, provider, "client1")
+		return request.Status == common.FAILED
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	request := datatest.GetRequest(t, provider, "client1")
+	assert.Equal(t, data.EXHAUSTED_REASON, request.Reason)
+	deadLetter, err := provider.DeadLetterList()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"client1"}, deadLetter)
+}
+
+func TestNatsPriority(t *testing.T) {
+	queue := createNatsQueue(t, datatest.NatsQueueOptions(t))
+
+	//stream keeps publish order, so priority is rejected instead of being ignored
+	assert.ErrorIs(t, queue.ListPush("client1", common.PRIORITY_MAX, 0), data.ErrNotSupported)
+	length, err := queue.ListLength()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+}
+
+func TestNatsWithQueue(t *testing.T) {
+	queue := createNatsQueue(t, datatest.NatsQueueOptions(t))
+	provider := data.WithQueue(data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{}), queue)
+
+	_, err := provider.Set(common.RequestBody{ID: "client1", Status: common.CREATED})
+	assert.NoError(t, err)
+	assert.NoError(t, provider.ListPush("client1", common.PRIORITY_NORMAL, 0))
+
+	assert.Equal(t, []string{"client1"}, datatest.PopN(t, provider, 1))
+	assert.NoError(t, provider.ListAck("client1"))
+	assert.Equal(t, common.CREATED, datatest.GetRequest(t, provider, "client1").Status)
+}
@@ -0,0 +1,491 @@
+package data
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+const POSTGRES_DATA_BACKEND = "postgres"
+
+const POSTGRES_QUEUE_CHANNEL = "matchmaker_queue"
+
+// notifications can be lost on reconnect, so queue is polled even without them
+const POSTGRES_QUEUE_POLL_INTERVAL = time.Second
+
+// expired request rows are removed once per this number of writes of each provider,
+// Get and Set skip them until then
+const POSTGRES_SWEEP_INTERVAL = 1000
+
+// any constant shared between API and Maker services, protects migrations from concurrent runs
+const POSTGRES_MIGRATIONS_LOCK_ID = 7243521
+
+//go:embed migrations/*.sql
+var postgresMigrations embed.FS
+
+type PostgresDataProvider struct {
+	pool   *pgxpool.Pool
+	writes atomic.Int64
+
+	options ProviderOptions
+}
+
+func (provider *PostgresDataProvider) Get(ID string) (*common.RequestBody, error) {
+	ctx := context.Background()
+	var bytes []byte
+	err := provider.pool.QueryRow(ctx, "SELECT body FROM requests WHERE id = $1 AND (expires_at IS NULL OR expires_at > now())", ID).Scan(&bytes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	request := common.RequestBody{}
+	err = json.Unmarshal(bytes, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func (provider *PostgresDataProvider) Set(req common.RequestBody) (*common.RequestBody, error) {
+	ctx := context.Background()
+	bytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := provider.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	//row lock is not enough when request doesn't exist yet, serialize writers by request ID
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))", req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevBytes []byte
+	err = tx.QueryRow(ctx, "SELECT body FROM requests WHERE id = $1 AND (expires_at IS NULL OR expires_at > now())", req.ID).Scan(&prevBytes)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	//make_interval returns NULL for NULL input, so zero TTL clears expiration.
+	//Unchanged DONE record keeps its expiration, see keepsTTL
+	ttlSeconds := provider.options.requestTTL(req, time.Now()).Seconds()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO requests (id, status, body, expires_at)
+		VALUES ($1, $2, $3, now() + make_interval(secs => NULLIF($4::float8, 0)))
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status,
+			body = excluded.body,
+			updated_at = now(),
+			expires_at = CASE
+				WHEN excluded.status = $5 AND requests.body = excluded.body
+					AND (requests.expires_at IS NULL OR requests.expires_at > now())
+				THEN requests.expires_at
+				ELSE excluded.expires_at
+			END`,
+		req.ID, req.Status, bytes, ttlSeconds, common.DONE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//request is already written, so failed sweep is only logged
+	if provider.writes.Add(1)%POSTGRES_SWEEP_INTERVAL == 0 {
+		_, err = provider.SweepExpired()
+		if err != nil {
+			log.Printf("Failed to sweep expired requests: %v", err)
+		}
+	}
+
+	if prevBytes == nil {
+		return nil, nil
+	}
+
+	prev := common.RequestBody{}
+	err = json.Unmarshal(prevBytes, &prev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prev, nil
+}
+
+func (provider *PostgresDataProvider) SweepExpired() (int, error) {
+	ctx := context.Background()
+	tag, err := provider.pool.Exec(ctx, "DELETE FROM requests WHERE expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (provider *PostgresDataProvider) ListPush(ID string, priority int, delay time.Duration) error {
+	ctx := context.Background()
+	visibleAt := time.Now().Add(delay)
+	score := queueScore(priority, provider.options.StarvationTimeout, visibleAt)
+	//single statement is run in one transaction, notification is sent on commit
+	_, err := provider.pool.Exec(ctx, `
+		WITH pushed AS (
+			INSERT INTO queue (request_id, score, visible_at) VALUES ($1, $2, $3)
+			ON CONFLICT (request_id) DO UPDATE SET score = excluded.score, visible_at = excluded.visible_at
+			RETURNING seq
+		)
+		SELECT pg_notify($4, seq::text) FROM pushed`,
+		ID, score, visibleAt.UnixMicro(), POSTGRES_QUEUE_CHANNEL)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (provider *PostgresDataProvider) ListPop(ctx context.Context) (string, error) {
+	//canceled pop can lose deleted row, so ctx is used only for waiting
+	popCtx := context.Background()
+	conn, err := provider.pool.Acquire(popCtx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Release()
+
+	//start listening before first pop, so push between pop and wait is not missed
+	_, err = conn.Exec(popCtx, "LISTEN "+POSTGRES_QUEUE_CHANNEL)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Exec(popCtx, "UNLISTEN "+POSTGRES_QUEUE_CHANNEL)
+
+	for {
+		var ID string
+		//listening connection is reused for pops, so each job holds only one connection
+		err = conn.QueryRow(popCtx, `
+			DELETE FROM queue WHERE seq = (
+				SELECT seq FROM queue WHERE visible_at <= $1
+				ORDER BY score, seq FOR UPDATE SKIP LOCKED LIMIT 1
+			) RETURNING request_id`, time.Now().UnixMicro()).Scan(&ID)
+		if err == nil {
+			return ID, nil
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return "", err
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, POSTGRES_QUEUE_POLL_INTERVAL)
+		_, err = conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		} else if err != nil && waitCtx.Err() == nil {
+			return "", err
+		}
+	}
+}
+
+// popped IDs are removed from the queue right away
+func (provider *PostgresDataProvider) ListAck(ID string) error {
+	return nil
+}
+
+func (provider *PostgresDataProvider) ListLength() (int, error) {
+	ctx := context.Background()
+	var length int
+	err := provider.pool.QueryRow(ctx, "SELECT count(*) FROM queue").Scan(&length)
+	if err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+func (provider *PostgresDataProvider) ListPosition(ID string) (int, error) {
+	ctx := context.Background()
+	var position int
+	//visible rows are popped by score, delayed ones after them by visibility time
+	err := provider.pool.QueryRow(ctx, `
+		WITH target AS (SELECT score, seq, visible_at FROM queue WHERE request_id = $1)
+		SELECT CASE WHEN t.visible_at <= $2 THEN
+			(SELECT count(*) FROM queue q WHERE q.visible_at <= $2 AND (q.score, q.seq) < (t.score, t.seq))
+		ELSE
+			(SELECT count(*) FROM queue q WHERE q.visible_at <= $2 OR (q.visible_at, q.seq) < (t.visible_at, t.seq))
+		END FROM target t`, ID, time.Now().UnixMicro()).Scan(&position)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+func (provider *PostgresDataProvider) DeadLetterPush(ID string) error {
+	ctx := context.Background()
+	_, err := provider.pool.Exec(ctx, `
+		INSERT INTO dead_letter (request_id) VALUES ($1)
+		ON CONFLICT (request_id) DO UPDATE SET created_at = excluded.created_at`, ID)
+
+	return err
+}
+
+func (provider *PostgresDataProvider) DeadLetterList() ([]string, error) {
+	ctx := context.Background()
+	rows, err := provider.pool.Query(ctx, "SELECT request_id FROM dead_letter ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (provider *PostgresDataProvider) DeadLetterRemove(ID string) (bool, error) {
+	ctx := context.Background()
+	tag, err := provider.pool.Exec(ctx, "DELETE FROM dead_letter WHERE request_id = $1", ID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (provider *PostgresDataProvider) EventPush(ID string, event common.RequestEvent) error {
+	ctx := context.Background()
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	tx, err := provider.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	expiresAt := time.Now().Add(provider.options.eventLogTTL())
+	_, err = tx.Exec(ctx, "INSERT INTO request_events (request_id, body, expires_at) VALUES ($1, $2, $3)", ID, bytes, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	//keep only last events of request and prolong their expiration
+	_, err = tx.Exec(ctx, `
+		DELETE FROM request_events WHERE request_id = $1 AND seq <= (
+			SELECT seq FROM request_events WHERE request_id = $1
+			ORDER BY seq DESC OFFSET $2 LIMIT 1
+		)`, ID, provider.options.eventLogSize())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE request_events SET expires_at = $2 WHERE request_id = $1", ID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	//logs of other requests are not touched after expiration, so they are cleaned here
+	_, err = tx.Exec(ctx, "DELETE FROM request_events WHERE expires_at <= now()")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (provider *PostgresDataProvider) EventList(ID string) ([]common.RequestEvent, error) {
+	ctx := context.Background()
+	rows, err := provider.pool.Query(ctx, "SELECT body FROM request_events WHERE request_id = $1 AND expires_at > now() ORDER BY seq", ID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, err
+	}
+
+	result := []common.RequestEvent{}
+	for _, item := range items {
+		event := common.RequestEvent{}
+		err = json.Unmarshal(item, &event)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	return result, nil
+}
+
+func (provider *PostgresDataProvider) ServerSet(server common.ServerStatus) error {
+	ctx := context.Background()
+	bytes, err := json.Marshal(server)
+	if err != nil {
+		return err
+	}
+
+	_, err = provider.pool.Exec(ctx, `
+		INSERT INTO servers (id, body) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET body = EXCLUDED.body`, server.ID, bytes)
+	return err
+}
+
+func (provider *PostgresDataProvider) ServerRemove(ID string) error {
+	ctx := context.Background()
+	_, err := provider.pool.Exec(ctx, "DELETE FROM servers WHERE id = $1", ID)
+	return err
+}
+
+func (provider *PostgresDataProvider) ServerList() ([]common.ServerStatus, error) {
+	ctx := context.Background()
+	rows, err := provider.pool.Query(ctx, "SELECT body FROM servers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+	if err != nil {
+		return nil, err
+	}
+
+	result := []common.ServerStatus{}
+	for _, item := range items {
+		server := common.ServerStatus{}
+		err = json.Unmarshal(item, &server)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, server)
+	}
+
+	return result, nil
+}
+
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool, schema string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", POSTGRES_MIGRATIONS_LOCK_ID)
+	if err != nil {
+		return err
+	}
+
+	if schema != "" {
+		_, err = tx.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{schema}.Sanitize())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return err
+	}
+
+	entries, err := postgresMigrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		version := entry.Name()
+		var applied bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+
+		if applied {
+			continue
+		}
+
+		script, err := postgresMigrations.ReadFile(path.Join("migrations", version))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, string(script))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+type PostgresDataProviderOptions struct {
+	//connection string in URL or DSN format
+	URL      string
+	PoolSize int
+	//schema for matchmaker tables, search_path from URL is used if empty
+	Schema string
+
+	ProviderOptions
+}
+
+func CreatePostgresDataProvider(options PostgresDataProviderOptions) (DataProvider, error) {
+	ctx := context.Background()
+	config, err := pgxpool.ParseConfig(options.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PoolSize > 0 {
+		config.MaxConns = int32(options.PoolSize)
+	}
+
+	if options.Schema != "" {
+		//quoted same way as in migrations, so names with any characters match
+		config.ConnConfig.RuntimeParams["search_path"] = pgx.Identifier{options.Schema}.Sanitize()
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pool.Ping(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	err = migratePostgres(ctx, pool, options.Schema)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &PostgresDataProvider{pool: pool, options: options.ProviderOptions}, nil
+}
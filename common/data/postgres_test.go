@@ -0,0 +1,40 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStatusTTL(t *testing.T) {
+	options := datatest.PostgresOptions(t)
+	options.StatusTTL = map[string]time.Duration{
+		common.FAILED: 200 * time.Millisecond,
+	}
+	provider, err := data.CreatePostgresDataProvider(options)
+	require.NoError(t, err)
+
+	_, err = provider.Set(common.RequestBody{ID: "client1", Status: common.FAILED})
+	assert.NoError(t, err)
+
+	//status without TTL should clear previous expiration
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.FAILED})
+	assert.NoError(t, err)
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.CREATED})
+	assert.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+
+	prev, err := provider.Set(common.RequestBody{ID: "client1", Status: common.OCCUPIED})
+	assert.NoError(t, err)
+	assert.Nil(t, prev)
+
+	prev, err = provider.Set(common.RequestBody{ID: "client2", Status: common.OCCUPIED})
+	assert.NoError(t, err)
+	assert.NotNil(t, prev)
+}
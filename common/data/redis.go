@@ -2,28 +2,194 @@ package data
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/st-matskevich/go-matchmaker/common"
 )
 
-const REDIS_DB_ID = 0
-const REDIS_QUEUE_LIST_KEY = "queue"
+const REDIS_DATA_BACKEND = "redis"
+
+const (
+	REDIS_MODE_SINGLE   = "single"
+	REDIS_MODE_SENTINEL = "sentinel"
+	REDIS_MODE_CLUSTER  = "cluster"
+)
+
+// sorted set, score is calculated with redisQueueScore
+const REDIS_QUEUE_KEY = "queue:priority"
+
+// counter of pushes, breaks ties of items with same queue score
+const REDIS_QUEUE_SEQUENCE_KEY = "queue:sequence"
+
+// list used as FIFO queue before priorities were added, its items are moved
+// to REDIS_QUEUE_KEY by pops, so requests queued before upgrade are not lost
+const REDIS_LEGACY_QUEUE_KEY = "queue"
+
+// sorted set scores are counted in ms since 2024-01-01 with push sequence in lower
+// bits, so they fit float64 exactly
+const REDIS_SCORE_EPOCH_MS = 1704067200000
+const REDIS_SCORE_SEQUENCE_SIZE = 1024
+
+// delayed items are kept in sorted set by visibility time, their queue scores
+// are kept in hash until items are moved to the queue
+const REDIS_DELAYED_KEY = "queue:delayed"
+const REDIS_DELAYED_SCORES_KEY = "queue:delayed:scores"
+
+// sorted set, score is push time
+const REDIS_DEAD_LETTER_KEY = "dead-letter"
+
+// capped list of JSON events per request
+const REDIS_EVENTS_KEY_PREFIX = "events:"
+
+// hash of JSON server statuses by server ID
+const REDIS_SERVERS_KEY = "servers"
+
+// delayed items are moved to the queue only by waiting pops, so they wait at most this long
+const REDIS_QUEUE_POLL_INTERVAL = time.Second
+
+// sets request and returns previous one, TTL in ms is cleared if it's 0. Unchanged
+// DONE record keeps its TTL, see keepsTTL
+var redisSetScript = redis.NewScript(`
+local prev = redis.call('GET', KEYS[1])
+if ARGV[3] == '1' and prev == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'KEEPTTL')
+elseif tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return prev
+`)
+
+// moves visible delayed items to the queue, returns number of moved items
+var redisPromoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, id in ipairs(due) do
+	local score = redis.call('HGET', KEYS[2], id)
+	if score then
+		redis.call('ZADD', KEYS[3], score, id)
+	end
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('HDEL', KEYS[2], id)
+end
+return #due
+`)
+
+// moves items of legacy list to the queue, oldest first, items that are already
+// queued keep their score. Returns number of moved items
+var redisMigrateScript = redis.NewScript(`
+local moved = 0
+while true do
+	local id = redis.call('RPOP', KEYS[1])
+	if not id then
+		break
+	end
+	local sequence = redis.call('INCR', KEYS[3])
+	redis.call('ZADD', KEYS[2], 'NX', ARGV[1] + sequence % ARGV[2], id)
+	moved = moved + 1
+end
+return moved
+`)
+
+// redisQueueScore converts queueScore to sorted set score, items with the same score
+// in ms are popped in push order. Ties are broken by REDIS_SCORE_SEQUENCE_SIZE pushes
+func redisQueueScore(score int64, sequence int64) float64 {
+	ms := score/1000 - REDIS_SCORE_EPOCH_MS
+	return float64(ms*REDIS_SCORE_SEQUENCE_SIZE + sequence%REDIS_SCORE_SEQUENCE_SIZE)
+}
+
+// request records are namespaced, so client ID can't collide with other keys
+const REDIS_REQUEST_KEY_PREFIX = "request:"
+
+// all keys in cluster mode share one hash slot, so commands touching
+// queue and request keys together are not rejected with CROSSSLOT,
+// key prefix is used as hash tag if set
+const REDIS_CLUSTER_HASH_TAG = "matchmaker"
 
 type RedisDataProvider struct {
-	client *redis.Client
+	client redis.UniversalClient
+
+	keyTag  string
+	options ProviderOptions
+}
+
+func (provider *RedisDataProvider) requestKey(ID string) string {
+	return provider.keyTag + REDIS_REQUEST_KEY_PREFIX + ID
+}
+
+func (provider *RedisDataProvider) queueKey() string {
+	return provider.keyTag + REDIS_QUEUE_KEY
+}
+
+func (provider *RedisDataProvider) queueSequenceKey() string {
+	return provider.keyTag + REDIS_QUEUE_SEQUENCE_KEY
+}
+
+func (provider *RedisDataProvider) legacyQueueKey() string {
+	return provider.keyTag + REDIS_LEGACY_QUEUE_KEY
+}
+
+func (provider *RedisDataProvider) delayedKey() string {
+	return provider.keyTag + REDIS_DELAYED_KEY
+}
+
+func (provider *RedisDataProvider) delayedScoresKey() string {
+	return provider.keyTag + REDIS_DELAYED_SCORES_KEY
+}
+
+func (provider *RedisDataProvider) deadLetterKey() string {
+	return provider.keyTag + REDIS_DEAD_LETTER_KEY
+}
+
+func (provider *RedisDataProvider) eventsKey(ID string) string {
+	return provider.keyTag + REDIS_EVENTS_KEY_PREFIX + ID
+}
+
+func (provider *RedisDataProvider) serversKey() string {
+	return provider.keyTag + REDIS_SERVERS_KEY
+}
+
+func (provider *RedisDataProvider) Get(ID string) (*common.RequestBody, error) {
+	ctx := context.Background()
+	result, err := provider.client.Get(ctx, provider.requestKey(ID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	request := common.RequestBody{}
+	err = json.Unmarshal([]byte(result), &request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
 }
 
 func (provider *RedisDataProvider) Set(req common.RequestBody) (*common.RequestBody, error) {
 	ctx := context.Background()
-	setArgs := redis.SetArgs{Get: true}
+	//TTL is applied in the same SET call, zero TTL clears expiration of previous status
+	ttl := provider.options.requestTTL(req, time.Now())
 	bytes, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := provider.client.SetArgs(ctx, req.ID, bytes, setArgs).Result()
+	//encoding is deterministic, so unchanged record is compared by its bytes
+	keep := "0"
+	if req.Status == common.DONE {
+		keep = "1"
+	}
+	keys := []string{provider.requestKey(req.ID)}
+	result, err := redisSetScript.Run(ctx, provider.client, keys, bytes, ttl.Milliseconds(), keep).Text()
 	if err == redis.Nil {
 		return nil, nil
 	} else if err != nil {
@@ -39,37 +205,334 @@ func (provider *RedisDataProvider) Set(req common.RequestBody) (*common.RequestB
 	return &prev, nil
 }
 
-func (provider *RedisDataProvider) ListPush(ID string) error {
+func (provider *RedisDataProvider) ListPush(ID string, priority int, delay time.Duration) error {
 	ctx := context.Background()
-	err := provider.client.LPush(ctx, REDIS_QUEUE_LIST_KEY, ID).Err()
+	now := time.Now()
+	sequence, err := provider.client.Incr(ctx, provider.queueSequenceKey()).Result()
 	if err != nil {
 		return err
 	}
+	score := redisQueueScore(queueScore(priority, provider.options.StarvationTimeout, now.Add(delay)), sequence)
+
+	_, err = provider.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if delay <= 0 {
+			pipe.ZRem(ctx, provider.delayedKey(), ID)
+			pipe.HDel(ctx, provider.delayedScoresKey(), ID)
+			pipe.ZAdd(ctx, provider.queueKey(), redis.Z{Score: score, Member: ID})
+		} else {
+			visibleAt := now.Add(delay).UnixMicro()
+			pipe.ZRem(ctx, provider.queueKey(), ID)
+			pipe.HSet(ctx, provider.delayedScoresKey(), ID, score)
+			pipe.ZAdd(ctx, provider.delayedKey(), redis.Z{Score: float64(visibleAt), Member: ID})
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (provider *RedisDataProvider) ListPop(ctx context.Context) (string, error) {
+	//canceled BZPOPMIN can lose popped member, so ctx is checked only between polls
+	popCtx := context.Background()
+	for {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		keys := []string{provider.delayedKey(), provider.delayedScoresKey(), provider.queueKey()}
+		err := redisPromoteScript.Run(popCtx, provider.client, keys, time.Now().UnixMicro()).Err()
+		if err != nil {
+			return "", err
+		}
+
+		//legacy list is checked on each poll, so items pushed by old API replicas during upgrade are moved too
+		keys = []string{provider.legacyQueueKey(), provider.queueKey(), provider.queueSequenceKey()}
+		base := redisQueueScore(queueScore(common.PRIORITY_NORMAL, provider.options.StarvationTimeout, time.Now()), 0)
+		err = redisMigrateScript.Run(popCtx, provider.client, keys, base, REDIS_SCORE_SEQUENCE_SIZE).Err()
+		if err != nil {
+			return "", err
+		}
+
+		val, err := provider.client.BZPopMin(popCtx, REDIS_QUEUE_POLL_INTERVAL, provider.queueKey()).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		ID, ok := val.Member.(string)
+		if !ok {
+			return "", errors.New("unexpected queue member type")
+		}
+
+		return ID, nil
+	}
+}
 
+// popped IDs are removed from the queue right away
+func (provider *RedisDataProvider) ListAck(ID string) error {
 	return nil
 }
 
-func (provider *RedisDataProvider) ListPop() (string, error) {
+func (provider *RedisDataProvider) ListLength() (int, error) {
+	ctx := context.Background()
+	var queued, delayed *redis.IntCmd
+	_, err := provider.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		queued = pipe.ZCard(ctx, provider.queueKey())
+		delayed = pipe.ZCard(ctx, provider.delayedKey())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(queued.Val() + delayed.Val()), nil
+}
+
+func (provider *RedisDataProvider) ListPosition(ID string) (int, error) {
+	ctx := context.Background()
+	var queued, rank, delayedRank *redis.IntCmd
+	_, err := provider.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		queued = pipe.ZCard(ctx, provider.queueKey())
+		rank = pipe.ZRank(ctx, provider.queueKey(), ID)
+		delayedRank = pipe.ZRank(ctx, provider.delayedKey(), ID)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	if rank.Err() == nil {
+		return int(rank.Val()), nil
+	} else if rank.Err() != redis.Nil {
+		return 0, rank.Err()
+	}
+
+	if delayedRank.Err() == nil {
+		return int(queued.Val() + delayedRank.Val()), nil
+	} else if delayedRank.Err() != redis.Nil {
+		return 0, delayedRank.Err()
+	}
+
+	return -1, nil
+}
+
+func (provider *RedisDataProvider) DeadLetterPush(ID string) error {
+	ctx := context.Background()
+	score := float64(time.Now().UnixMicro())
+	return provider.client.ZAdd(ctx, provider.deadLetterKey(), redis.Z{Score: score, Member: ID}).Err()
+}
+
+func (provider *RedisDataProvider) DeadLetterList() ([]string, error) {
+	ctx := context.Background()
+	return provider.client.ZRange(ctx, provider.deadLetterKey(), 0, -1).Result()
+}
+
+func (provider *RedisDataProvider) DeadLetterRemove(ID string) (bool, error) {
 	ctx := context.Background()
-	val, err := provider.client.BRPop(ctx, 0, REDIS_QUEUE_LIST_KEY).Result()
+	removed, err := provider.client.ZRem(ctx, provider.deadLetterKey(), ID).Result()
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	return val[1], nil
+	return removed > 0, nil
 }
 
-func CreateRedisDataProvider(url string) (DataProvider, error) {
+func (provider *RedisDataProvider) EventPush(ID string, event common.RequestEvent) error {
 	ctx := context.Background()
-	clientRedis := redis.NewClient(&redis.Options{
-		Addr: url,
-		DB:   REDIS_DB_ID,
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := provider.eventsKey(ID)
+	_, err = provider.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, bytes)
+		pipe.LTrim(ctx, key, int64(-provider.options.eventLogSize()), -1)
+		pipe.PExpire(ctx, key, provider.options.eventLogTTL())
+		return nil
 	})
 
-	_, err := clientRedis.Ping(ctx).Result()
+	return err
+}
+
+func (provider *RedisDataProvider) EventList(ID string) ([]common.RequestEvent, error) {
+	ctx := context.Background()
+	items, err := provider.client.LRange(ctx, provider.eventsKey(ID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []common.RequestEvent{}
+	for _, item := range items {
+		event := common.RequestEvent{}
+		err = json.Unmarshal([]byte(item), &event)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+
+	return result, nil
+}
+
+func (provider *RedisDataProvider) ServerSet(server common.ServerStatus) error {
+	ctx := context.Background()
+	bytes, err := json.Marshal(server)
+	if err != nil {
+		return err
+	}
+
+	return provider.client.HSet(ctx, provider.serversKey(), server.ID, bytes).Err()
+}
+
+func (provider *RedisDataProvider) ServerRemove(ID string) error {
+	ctx := context.Background()
+	return provider.client.HDel(ctx, provider.serversKey(), ID).Err()
+}
+
+func (provider *RedisDataProvider) ServerList() ([]common.ServerStatus, error) {
+	ctx := context.Background()
+	items, err := provider.client.HGetAll(ctx, provider.serversKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []common.ServerStatus{}
+	for _, item := range items {
+		server := common.ServerStatus{}
+		err = json.Unmarshal([]byte(item), &server)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, server)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	return result, nil
+}
+
+type RedisDataProviderOptions struct {
+	//one of REDIS_MODE_* values, REDIS_MODE_SINGLE is used if empty
+	Mode string
+	//server address for single mode, sentinels or cluster seed nodes otherwise
+	Addresses  []string
+	MasterName string
+
+	Username         string
+	Password         string
+	SentinelUsername string
+	SentinelPassword string
+
+	DB       int
+	PoolSize int
+
+	//namespace for all keys, allows deployments and tenants to share one Redis
+	KeyPrefix string
+
+	TLS         bool
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	ProviderOptions
+}
+
+func createRedisTLSConfig(options RedisDataProviderOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if options.TLSCAFile != "" {
+		caBytes, err := os.ReadFile(options.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("no certificates found in REDIS_TLS_CA_FILE")
+		}
+		config.RootCAs = pool
+	}
+
+	if options.TLSCertFile != "" || options.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func createRedisClient(options RedisDataProviderOptions) (redis.UniversalClient, error) {
+	universalOptions := redis.UniversalOptions{
+		Addrs:            options.Addresses,
+		MasterName:       options.MasterName,
+		Username:         options.Username,
+		Password:         options.Password,
+		SentinelUsername: options.SentinelUsername,
+		SentinelPassword: options.SentinelPassword,
+		DB:               options.DB,
+		PoolSize:         options.PoolSize,
+	}
+
+	if options.TLS {
+		config, err := createRedisTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		universalOptions.TLSConfig = config
+	}
+
+	switch options.Mode {
+	case "", REDIS_MODE_SINGLE:
+		if len(options.Addresses) != 1 {
+			return nil, errors.New("single mode expects exactly one redis address")
+		}
+		return redis.NewClient(universalOptions.Simple()), nil
+	case REDIS_MODE_SENTINEL:
+		if options.MasterName == "" {
+			return nil, errors.New("sentinel mode requires master name")
+		}
+		return redis.NewFailoverClient(universalOptions.Failover()), nil
+	case REDIS_MODE_CLUSTER:
+		if options.DB != 0 {
+			return nil, errors.New("cluster mode supports only DB 0")
+		}
+		return redis.NewClusterClient(universalOptions.Cluster()), nil
+	default:
+		return nil, errors.New("unknown redis mode")
+	}
+}
+
+// getRedisKeyTag returns string prepended to all keys
+func getRedisKeyTag(mode string, prefix string) string {
+	if mode == REDIS_MODE_CLUSTER {
+		if prefix == "" {
+			prefix = REDIS_CLUSTER_HASH_TAG
+		}
+		return "{" + prefix + "}:"
+	}
+
+	if prefix == "" {
+		return ""
+	}
+	return prefix + ":"
+}
+
+func CreateRedisDataProvider(options RedisDataProviderOptions) (DataProvider, error) {
+	ctx := context.Background()
+	clientRedis, err := createRedisClient(options)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = clientRedis.Ping(ctx).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	return &RedisDataProvider{client: clientRedis}, nil
+	keyTag := getRedisKeyTag(options.Mode, options.KeyPrefix)
+	return &RedisDataProvider{client: clientRedis, keyTag: keyTag, options: options.ProviderOptions}, nil
 }
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStatusTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	options := RedisDataProviderOptions{
+		ProviderOptions: ProviderOptions{
+			StatusTTL: map[string]time.Duration{
+				common.FAILED: time.Minute,
+				common.DONE:   time.Hour,
+			},
+		},
+	}
+	options.Addresses = []string{server.Addr()}
+	provider, err := CreateRedisDataProvider(options)
+	assert.NoError(t, err)
+
+	requestID := "client1"
+
+	_, err = provider.Set(common.RequestBody{ID: requestID, Status: common.DONE})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, server.TTL("request:"+requestID))
+
+	_, err = provider.Set(common.RequestBody{ID: requestID, Status: common.FAILED})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, server.TTL("request:"+requestID))
+
+	//status without TTL should clear previous expiration
+	_, err = provider.Set(common.RequestBody{ID: requestID, Status: common.CREATED})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), server.TTL("request:"+requestID))
+
+	_, err = provider.Set(common.RequestBody{ID: requestID, Status: common.FAILED})
+	assert.NoError(t, err)
+
+	//expired record should look like no record at all
+	server.FastForward(2 * time.Minute)
+	prev, err := provider.Set(common.RequestBody{ID: requestID, Status: common.OCCUPIED})
+	assert.NoError(t, err)
+	assert.Nil(t, prev)
+}
+
+func TestRedisDoneTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	options := RedisDataProviderOptions{
+		ProviderOptions: ProviderOptions{
+			StatusTTL: map[string]time.Duration{common.DONE: time.Hour},
+		},
+	}
+	options.Addresses = []string{server.Addr()}
+	provider, err := CreateRedisDataProvider(options)
+	assert.NoError(t, err)
+
+	//unchanged DONE record written back by poll should keep its TTL
+	done := common.RequestBody{ID: "client1", Status: common.DONE, Container: "container1"}
+	_, err = provider.Set(done)
+	assert.NoError(t, err)
+	server.FastForward(time.Minute)
+	_, err = provider.Set(done)
+	assert.NoError(t, err)
+	assert.Equal(t, 59*time.Minute, server.TTL("request:client1"))
+
+	done.Joined = true
+	_, err = provider.Set(done)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, server.TTL("request:client1"))
+
+	//not joined reservation expiration should be used instead of static TTL
+	expiresAt := time.Now().Add(10 * time.Minute)
+	_, err = provider.Set(common.RequestBody{ID: "client2", Status: common.DONE, ExpiresAt: expiresAt.UnixMilli()})
+	assert.NoError(t, err)
+	ttl := server.TTL("request:client2")
+	assert.InDelta(t, 10*time.Minute+DONE_EXPIRED_GRACE, ttl, float64(time.Second))
+}
+
+func TestRedisConnectionOptions(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("matchmaker", "password")
+
+	options := RedisDataProviderOptions{
+		Addresses: []string{server.Addr()},
+		Username:  "matchmaker",
+		Password:  "wrong",
+	}
+	_, err := CreateRedisDataProvider(options)
+	assert.Error(t, err)
+
+	options.Password = "password"
+	options.DB = 3
+	provider, err := CreateRedisDataProvider(options)
+	assert.NoError(t, err)
+
+	_, err = provider.Set(common.RequestBody{ID: "client1", Status: common.CREATED})
+	assert.NoError(t, err)
+	assert.True(t, server.DB(3).Exists("request:client1"))
+	assert.False(t, server.DB(0).Exists("request:client1"))
+}
+
+func TestRedisKeys(t *testing.T) {
+	provider := RedisDataProvider{keyTag: getRedisKeyTag(REDIS_MODE_SINGLE, "")}
+	assert.Equal(t, "queue:priority", provider.queueKey())
+	assert.Equal(t, "request:client1", provider.requestKey("client1"))
+
+	//client ID equal to other key doesn't collide with it
+	assert.NotEqual(t, provider.queueKey(), provider.requestKey(REDIS_QUEUE_KEY))
+
+	provider = RedisDataProvider{keyTag: getRedisKeyTag(REDIS_MODE_SINGLE, "game1")}
+	assert.Equal(t, "game1:queue:priority", provider.queueKey())
+	assert.Equal(t, "game1:request:client1", provider.requestKey("client1"))
+	assert.Equal(t, "game1:events:client1", provider.eventsKey("client1"))
+}
+
+func TestRedisClusterKeys(t *testing.T) {
+	provider := RedisDataProvider{keyTag: getRedisKeyTag(REDIS_MODE_CLUSTER, "")}
+
+	//hash slot is calculated only from {...} part of the key
+	assert.Equal(t, "{matchmaker}:queue:priority", provider.queueKey())
+	assert.Equal(t, "{matchmaker}:request:client1", provider.requestKey("client1"))
+	assert.Equal(t, "{matchmaker}:events:client1", provider.eventsKey("client1"))
+
+	//each namespace gets own hash slot
+	provider = RedisDataProvider{keyTag: getRedisKeyTag(REDIS_MODE_CLUSTER, "game1")}
+	assert.Equal(t, "{game1}:queue:priority", provider.queueKey())
+	assert.Equal(t, "{game1}:request:client1", provider.requestKey("client1"))
+}
+
+func TestRedisQueueOrder(t *testing.T) {
+	server := miniredis.RunT(t)
+	provider, err := CreateRedisDataProvider(RedisDataProviderOptions{Addresses: []string{server.Addr()}})
+	assert.NoError(t, err)
+
+	//requests queued as list before upgrade are moved to the queue by pops, oldest first
+	server.Lpush("queue", "legacy1")
+	server.Lpush("queue", "legacy2")
+
+	//same priority requests are served in push order, not by ID
+	pushed := []string{"client9", "client1", "client5"}
+	for _, ID := range pushed {
+		assert.NoError(t, provider.ListPush(ID, common.PRIORITY_NORMAL, 0))
+	}
+
+	//legacy items are moved on pop, so they go after already queued items
+	for _, ID := range append(pushed, "legacy1", "legacy2") {
+		popped, err := provider.ListPop(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, ID, popped)
+	}
+	assert.False(t, server.Exists("queue"))
+}
+
+func TestRedisKeyPrefixIsolation(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	providers := []DataProvider{}
+	for _, prefix := range []string{"game1", "game2"} {
+		provider, err := CreateRedisDataProvider(RedisDataProviderOptions{Addresses: []string{server.Addr()}, KeyPrefix: prefix})
+		assert.NoError(t, err)
+		providers = append(providers, provider)
+	}
+
+	_, err := providers[0].Set(common.RequestBody{ID: "client1", Status: common.CREATED})
+	assert.NoError(t, err)
+	assert.NoError(t, providers[0].DeadLetterPush("client1"))
+
+	request, err := providers[1].Get("client1")
+	assert.NoError(t, err)
+	assert.Nil(t, request)
+
+	deadLetter, err := providers[1].DeadLetterList()
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetter)
+
+	assert.True(t, server.Exists("game1:request:client1"))
+}
+
+func TestRedisEventLogTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	options := RedisDataProviderOptions{
+		ProviderOptions: ProviderOptions{EventLogTTL: time.Hour},
+	}
+	options.Addresses = []string{server.Addr()}
+	provider, err := CreateRedisDataProvider(options)
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.EventPush("client1", common.RequestEvent{Type: common.EVENT_CREATED}))
+	assert.Equal(t, time.Hour, server.TTL("events:client1"))
+
+	//expiration is prolonged by every push
+	server.FastForward(30 * time.Minute)
+	assert.NoError(t, provider.EventPush("client1", common.RequestEvent{Type: common.EVENT_POPPED}))
+	assert.Equal(t, time.Hour, server.TTL("events:client1"))
+
+	server.FastForward(2 * time.Hour)
+	events, err := provider.EventList("client1")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
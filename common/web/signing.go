@@ -0,0 +1,25 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/controlauth"
+)
+
+// SigningClient signs each request with control key of game server that is derived from
+// image secret and server ID in request header, see controlauth
+type SigningClient struct {
+	Client HTTPClient
+	Secret string
+}
+
+func (client *SigningClient) Do(req *http.Request) (*http.Response, error) {
+	key := controlauth.DeriveKey(client.Secret, req.Header.Get(controlauth.SERVER_ID_HEADER))
+	err := controlauth.Sign(req, key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Client.Do(req)
+}
@@ -0,0 +1,151 @@
+// Package controlauth signs Reservation API requests of Maker and API with HMAC-SHA256,
+// so game server can reject requests from other containers on the network. Secret is
+// configured per image, each new container gets own key derived from it in SECRET_ENV,
+// so server can't sign requests to other servers
+package controlauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// env of new containers with control key of the server, see DeriveKey
+const SECRET_ENV = "MATCHMAKER_CONTROL_SECRET"
+
+const TIMESTAMP_HEADER = "X-Matchmaker-Timestamp"
+const SIGNATURE_HEADER = "X-Matchmaker-Signature"
+
+// ID of server that request is sent to, signer derives key of the server from it
+const SERVER_ID_HEADER = "X-Matchmaker-Server"
+
+// longer bodies are rejected before they are read for verification
+const MAX_BODY_SIZE = 64 * 1024
+
+// requests signed earlier or later than this are rejected, so captured request
+// can't be replayed later
+const MAX_CLOCK_SKEW = 30 * time.Second
+
+var ErrUnsigned = errors.New("request is not signed")
+var ErrBadSignature = errors.New("request signature is invalid")
+var ErrStale = errors.New("request timestamp is out of allowed skew")
+var ErrTooLarge = errors.New("request body is too large")
+
+// DeriveKey returns control key of server with ID, image secret is used for servers
+// created without ID
+func DeriveKey(secret string, serverID string) string {
+	if serverID == "" {
+		return secret
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(serverID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds timestamp and signature headers to request
+func Sign(req *http.Request, secret string, now time.Time) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+	req.Header.Set(TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(SIGNATURE_HEADER, signature(secret, req.Method, getHost(req), req.URL.Path, timestamp, body))
+	return nil
+}
+
+// Verify checks signature and timestamp of request
+func Verify(req *http.Request, secret string, now time.Time) error {
+	timestamp := req.Header.Get(TIMESTAMP_HEADER)
+	received := req.Header.Get(SIGNATURE_HEADER)
+	if timestamp == "" || received == "" {
+		return ErrUnsigned
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = http.MaxBytesReader(nil, req.Body, MAX_BODY_SIZE)
+	}
+
+	body, err := readBody(req)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrTooLarge
+	} else if err != nil {
+		return ErrBadSignature
+	}
+
+	expected := signature(secret, req.Method, getHost(req), req.URL.Path, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(received)) {
+		return ErrBadSignature
+	}
+
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+
+	skew := now.Sub(time.UnixMilli(signed))
+	if skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		return ErrStale
+	}
+
+	return nil
+}
+
+// Middleware responds with 401 to requests that are not signed with secret, secret
+// of server is its key from SECRET_ENV
+func Middleware(secret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := Verify(r, secret, time.Now())
+		if errors.Is(err, ErrTooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// method, host, path, timestamp and body hash are signed, v1 requests have no body
+func signature(secret string, method string, host string, path string, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + host + "\n" + path + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Host header of received request, or host of URL for request that is being sent
+func getHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+// readBody reads body of request and replaces it with a copy, so request can still be sent or handled
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
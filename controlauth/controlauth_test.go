@@ -0,0 +1,115 @@
+package controlauth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		sign   func(req *http.Request)
+		verify time.Time
+		err    error
+	}{
+		{
+			name:   "signed request",
+			sign:   func(req *http.Request) { Sign(req, "secret", now) },
+			verify: now,
+		},
+		{
+			name:   "unsigned request",
+			sign:   func(req *http.Request) {},
+			verify: now,
+			err:    ErrUnsigned,
+		},
+		{
+			name:   "other secret",
+			sign:   func(req *http.Request) { Sign(req, "other", now) },
+			verify: now,
+			err:    ErrBadSignature,
+		},
+		{
+			name: "changed path",
+			sign: func(req *http.Request) {
+				Sign(req, "secret", now)
+				req.URL.Path = "/reservation/client2"
+			},
+			verify: now,
+			err:    ErrBadSignature,
+		},
+		{
+			name: "changed body",
+			sign: func(req *http.Request) {
+				Sign(req, "secret", now)
+				req.Body = io.NopCloser(strings.NewReader(`{"client_id":"client2"}`))
+			},
+			verify: now,
+			err:    ErrBadSignature,
+		},
+		{
+			name: "changed host",
+			sign: func(req *http.Request) {
+				Sign(req, "secret", now)
+				req.Host = "container2:3000"
+			},
+			verify: now,
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "replayed request",
+			sign:   func(req *http.Request) { Sign(req, "secret", now) },
+			verify: now.Add(time.Minute),
+			err:    ErrStale,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/reservation/client1", strings.NewReader(`{"client_id":"client1"}`))
+			test.sign(req)
+			assert.Equal(t, test.err, Verify(req, "secret", test.verify))
+
+			//body is still readable by handler
+			body, err := io.ReadAll(req.Body)
+			assert.Nil(t, err)
+			assert.NotEmpty(t, body)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := Middleware("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/capacity", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/capacity", nil)
+	Sign(req, "secret", time.Now())
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestVerifyLargeBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v2/reservation", strings.NewReader(strings.Repeat("a", MAX_BODY_SIZE+1)))
+	Sign(req, "secret", time.Now())
+	assert.Equal(t, ErrTooLarge, Verify(req, "secret", time.Now()))
+}
+
+func TestDeriveKey(t *testing.T) {
+	//each server gets own key, servers without ID use image secret
+	assert.NotEqual(t, DeriveKey("secret", "server1"), DeriveKey("secret", "server2"))
+	assert.NotEqual(t, "secret", DeriveKey("secret", "server1"))
+	assert.Equal(t, "secret", DeriveKey("secret", ""))
+}
@@ -0,0 +1,516 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/api/auth"
+	"github.com/st-matskevich/go-matchmaker/api/controller"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/controlauth"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/st-matskevich/go-matchmaker/maker/processor"
+	"github.com/st-matskevich/go-matchmaker/reservation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const CONTAINER_CONTROL_PORT = "3000"
+const CONTAINER_CAPACITY = 2
+const WAIT_TIMEOUT = 5 * time.Second
+
+// fakeServer serves Reservation API with the library game servers use
+type fakeServer struct {
+	port string
+	//from env that Maker passed to the container
+	serverID string
+	slots    *reservation.SlotManager
+	handler  http.Handler
+}
+
+// fakeCluster is both container interactor for Maker and network for API and Maker
+type fakeCluster struct {
+	mutex   sync.Mutex
+	servers map[string]*fakeServer
+	order   []string
+	//containers are numbered by creation, so removed container IDs are not reused
+	created int
+	//each WatchEvents call gets own channel, as Docker events stream
+	watchers []chan interactor.ContainerEvent
+	//reservation timeout of new servers, minute if 0
+	reservationTimeout time.Duration
+	//servers reject unsigned requests if set
+	controlSecret string
+}
+
+// should be called with locked mutex, events are dropped if watcher doesn't read
+// them, resync fixes it same way as for Docker
+func (cluster *fakeCluster) notify(event interactor.ContainerEvent) {
+	for _, watcher := range cluster.watchers {
+		select {
+		case watcher <- event:
+		default:
+		}
+	}
+}
+
+func (cluster *fakeCluster) ListContainers() ([]string, error) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	return append([]string{}, cluster.order...), nil
+}
+
+func (cluster *fakeCluster) InspectContainer(id string) (interactor.ContainerInfo, error) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	server, ok := cluster.servers[id]
+	if !ok {
+		return interactor.ContainerInfo{}, errors.New("no such container")
+	}
+
+	return interactor.ContainerInfo{Address: id, ExposedPort: server.port, ServerID: server.serverID}, nil
+}
+
+func (cluster *fakeCluster) CreateContainer(env map[string]string) (string, error) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	cluster.created++
+	id := fmt.Sprintf("container%d", cluster.created)
+	timeout := cluster.reservationTimeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	slots := reservation.CreateSlotManager(reservation.SlotManagerOptions{Capacity: CONTAINER_CAPACITY, Timeout: timeout})
+	cluster.servers[id] = &fakeServer{
+		port:     strconv.Itoa(40000 + cluster.created - 1),
+		serverID: env[common.SERVER_ID_ENV],
+		slots:    slots,
+		handler:  &reservation.Handler{Slots: slots},
+	}
+	cluster.order = append(cluster.order, id)
+	cluster.notify(interactor.ContainerEvent{ID: id, Type: interactor.CONTAINER_STARTED})
+
+	return id, nil
+}
+
+func (cluster *fakeCluster) StopContainer(id string) error {
+	return nil
+}
+
+func (cluster *fakeCluster) RemoveContainer(id string) error {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	delete(cluster.servers, id)
+	for i, listed := range cluster.order {
+		if listed == id {
+			cluster.order = append(cluster.order[:i], cluster.order[i+1:]...)
+			break
+		}
+	}
+	cluster.notify(interactor.ContainerEvent{ID: id, Type: interactor.CONTAINER_REMOVED})
+
+	return nil
+}
+
+func (cluster *fakeCluster) GetFleetUsage() (interactor.FleetUsage, error) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	return interactor.FleetUsage{Total: len(cluster.order), Nodes: map[string]int{"node": len(cluster.order)}}, nil
+}
+
+func (cluster *fakeCluster) Do(req *http.Request) (*http.Response, error) {
+	cluster.mutex.Lock()
+	server, ok := cluster.servers[req.URL.Hostname()]
+	secret := cluster.controlSecret
+	cluster.mutex.Unlock()
+
+	if !ok || req.URL.Port() != CONTAINER_CONTROL_PORT {
+		return nil, errors.New("connection refused")
+	}
+
+	handler := server.handler
+	if secret != "" {
+		handler = controlauth.Middleware(controlauth.DeriveKey(secret, server.serverID), handler)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Result(), nil
+}
+
+// dropReservation makes servers lose reservation of client, as if server restarted
+func (cluster *fakeCluster) dropReservation(clientID string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	for _, server := range cluster.servers {
+		server.slots.Cancel(clientID)
+	}
+}
+
+// join connects client to servers that reserved a slot for it
+func (cluster *fakeCluster) join(clientID string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	for _, server := range cluster.servers {
+		server.slots.Join(clientID)
+	}
+}
+
+// drain makes server reject new reservations
+func (cluster *fakeCluster) drain(id string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	cluster.servers[id].slots.Drain()
+}
+
+func (cluster *fakeCluster) setReservationTimeout(timeout time.Duration) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	cluster.reservationTimeout = timeout
+}
+
+func (cluster *fakeCluster) setControlSecret(secret string) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	cluster.controlSecret = secret
+}
+
+func (cluster *fakeCluster) WatchEvents(ctx context.Context) (<-chan interactor.ContainerEvent, <-chan error) {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+
+	events := make(chan interactor.ContainerEvent, 100)
+	cluster.watchers = append(cluster.watchers, events)
+	return events, make(chan error)
+}
+
+// configure changes Maker options before it's started
+func startMatchmaker(t *testing.T, configure ...func(maker *processor.Processor)) (*fiber.App, *fakeCluster) {
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	cluster := &fakeCluster{servers: map[string]*fakeServer{}}
+	registry := interactor.CreateContainerRegistry(cluster, interactor.ContainerRegistryOptions{ResyncInterval: 100 * time.Millisecond})
+
+	maker := &processor.Processor{
+		DataProvider:           dataProvider,
+		DockerClient:           registry,
+		HttpClient:             cluster,
+		MaxJobs:                3,
+		ImageControlPort:       CONTAINER_CONTROL_PORT,
+		LookupCooldown:         10,
+		ReservationCooldown:    10,
+		ReservationRetries:     3,
+		ReservationParallelism: 2,
+		MaxCreations:           2,
+		ContainerSlots:         CONTAINER_CAPACITY,
+		IdleTimeout:            50 * time.Millisecond,
+		ReapInterval:           10 * time.Millisecond,
+		ServerSyncInterval:     10 * time.Millisecond,
+	}
+	for _, apply := range configure {
+		apply(maker)
+	}
+
+	//jobs are stopped after the test
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		assert.NoError(t, maker.Process(ctx))
+		close(stopped)
+	}()
+	go maker.Reap(ctx)
+	go registry.Run(ctx)
+	go maker.WatchServers(ctx)
+	t.Cleanup(func() {
+		stop()
+		<-stopped
+	})
+
+	//API reaches servers same way as Maker
+	api := &controller.Controller{
+		DataProvider:     dataProvider,
+		HttpClient:       maker.HttpClient,
+		ImageControlPort: CONTAINER_CONTROL_PORT,
+	}
+
+	app := fiber.New()
+	app.Use(auth.New(&auth.DummyAuthorizer{}))
+	app.Post("/request", api.HandleCreateRequest)
+
+	return app, cluster
+}
+
+func sendRequest(t *testing.T, app *fiber.App, clientID string) *http.Response {
+	req, err := http.NewRequest("POST", "/request", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", clientID)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+// waitForServer polls API as a client would, returns server address
+func waitForServer(t *testing.T, app *fiber.App, clientID string) string {
+	deadline := time.Now().Add(WAIT_TIMEOUT)
+	for time.Now().Before(deadline) {
+		resp := sendRequest(t, app, clientID)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case fiber.StatusOK:
+			return string(body)
+		case fiber.StatusAccepted:
+			time.Sleep(10 * time.Millisecond)
+		default:
+			require.FailNow(t, "unexpected response", "code %v", resp.StatusCode)
+		}
+	}
+
+	require.FailNow(t, "server was not found in time")
+	return ""
+}
+
+func TestSingleClient(t *testing.T) {
+	app, cluster := startMatchmaker(t)
+
+	address := waitForServer(t, app, "client1")
+	assert.Equal(t, ":40000", address)
+
+	//repeated requests return the same server
+	assert.Equal(t, address, waitForServer(t, app, "client1"))
+
+	containers, err := cluster.ListContainers()
+	assert.NoError(t, err)
+	assert.Len(t, containers, 1)
+}
+
+func TestContainersScaleWithClients(t *testing.T) {
+	app, cluster := startMatchmaker(t)
+
+	clients := 5
+	wg := sync.WaitGroup{}
+	addresses := make([]string, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addresses[i] = waitForServer(t, app, fmt.Sprintf("client%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	perServer := map[string]int{}
+	for _, address := range addresses {
+		perServer[address]++
+	}
+
+	for address, count := range perServer {
+		assert.LessOrEqual(t, count, CONTAINER_CAPACITY, "server %v is overbooked", address)
+	}
+
+	containers, err := cluster.ListContainers()
+	assert.NoError(t, err)
+	//job can create a container after scan that missed one created concurrently
+	assert.GreaterOrEqual(t, len(containers), (clients+CONTAINER_CAPACITY-1)/CONTAINER_CAPACITY)
+}
+
+func TestLostReservationIsRequeued(t *testing.T) {
+	app, cluster := startMatchmaker(t)
+
+	address := waitForServer(t, app, "client1")
+	assert.Equal(t, ":40000", address)
+
+	cluster.dropReservation("client1")
+
+	//slot is free again, so the same server is reserved
+	assert.Equal(t, address, waitForServer(t, app, "client1"))
+}
+
+func TestIdleContainerIsRemoved(t *testing.T) {
+	app, cluster := startMatchmaker(t)
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+
+	cluster.dropReservation("client1")
+	assert.Eventually(t, func() bool {
+		containers, err := cluster.ListContainers()
+		return err == nil && len(containers) == 0
+	}, WAIT_TIMEOUT, 10*time.Millisecond)
+
+	//client that lost reservation gets new server
+	assert.Equal(t, ":40001", waitForServer(t, app, "client1"))
+}
+
+func TestContainerLimitKeepsRequestsQueued(t *testing.T) {
+	app, cluster := startMatchmaker(t, func(maker *processor.Processor) {
+		maker.MaxContainers = 1
+		//container is not removed while request waits for it
+		maker.IdleTimeout = 0
+	})
+
+	for i := 0; i < CONTAINER_CAPACITY; i++ {
+		assert.Equal(t, ":40000", waitForServer(t, app, fmt.Sprintf("client%d", i)))
+	}
+
+	//container is full and no more containers can be created
+	waited := make(chan string, 1)
+	go func() {
+		waited <- waitForServer(t, app, "waiting")
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case address := <-waited:
+		assert.Fail(t, "request was served over container limit", "server %v", address)
+	default:
+	}
+
+	//slot is freed, so waiting request gets it
+	cluster.dropReservation("client0")
+	assert.Equal(t, ":40000", <-waited)
+
+	containers, err := cluster.ListContainers()
+	assert.NoError(t, err)
+	assert.Len(t, containers, 1)
+}
+
+func TestExpiredReservationIsReported(t *testing.T) {
+	app, cluster := startMatchmaker(t, func(maker *processor.Processor) {
+		//server is not removed after reservation expired
+		maker.IdleTimeout = 0
+	})
+	cluster.setReservationTimeout(50 * time.Millisecond)
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+	time.Sleep(100 * time.Millisecond)
+
+	resp := sendRequest(t, app, "client1")
+	resp.Body.Close()
+	assert.Equal(t, fiber.StatusGone, resp.StatusCode)
+
+	//next call queues new request
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+}
+
+func TestJoinedReservationDoesNotExpire(t *testing.T) {
+	app, cluster := startMatchmaker(t)
+	cluster.setReservationTimeout(50 * time.Millisecond)
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+	cluster.join("client1")
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+}
+
+func TestJoinTokenIsIssued(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	app, cluster := startMatchmaker(t, func(maker *processor.Processor) {
+		maker.JoinTokenKey = key
+	})
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+	resp := sendRequest(t, app, "client1")
+	resp.Body.Close()
+
+	//server verifies token that client got with its ID
+	cluster.mutex.Lock()
+	serverID := cluster.servers["container1"].serverID
+	cluster.mutex.Unlock()
+	verifier := jointoken.CreateVerifier(jointoken.VerifierOptions{Key: public, Container: serverID})
+	claims, err := verifier.Verify(resp.Header.Get(jointoken.HEADER))
+	assert.NoError(t, err)
+	assert.Equal(t, "client1", claims.ClientID)
+}
+
+func TestControlRequestsAreSigned(t *testing.T) {
+	app, cluster := startMatchmaker(t, func(maker *processor.Processor) {
+		maker.HttpClient = &web.SigningClient{Client: maker.HttpClient, Secret: "secret"}
+	})
+	cluster.setControlSecret("secret")
+
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+
+	//other containers on the network can't reserve slots
+	req, err := http.NewRequest("POST", "http://container1:"+CONTAINER_CONTROL_PORT+"/reservation/client2", nil)
+	require.NoError(t, err)
+	resp, err := cluster.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	//server can't sign requests to other servers with its own key
+	req, err = http.NewRequest("POST", "http://container1:"+CONTAINER_CONTROL_PORT+"/reservation/client2", nil)
+	require.NoError(t, err)
+	require.NoError(t, controlauth.Sign(req, controlauth.DeriveKey("secret", "other"), time.Now()))
+	resp, err = cluster.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestReservationAPIV2(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	app, cluster := startMatchmaker(t, func(maker *processor.Processor) {
+		maker.ReservationAPI = common.RESERVATION_API_V2
+		maker.JoinTokenKey = key
+	})
+
+	//party takes the second slot of the same server
+	req, err := http.NewRequest("POST", "/request", strings.NewReader(`{"party":["client2"]}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "client1")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, ":40000", waitForServer(t, app, "client1"))
+
+	cluster.mutex.Lock()
+	assert.True(t, cluster.servers["container1"].slots.IsReserved("client2"))
+	serverID := cluster.servers["container1"].serverID
+	cluster.mutex.Unlock()
+
+	//party member gets own join token through the client
+	resp = sendRequest(t, app, "client1")
+	resp.Body.Close()
+	tokens, err := jointoken.ParsePartyTokens(resp.Header.Get(jointoken.PARTY_HEADER))
+	require.NoError(t, err)
+	claims, err := jointoken.Parse(public, tokens["client2"], time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "client2", claims.ClientID)
+	assert.Equal(t, serverID, claims.Container)
+
+	assert.Equal(t, ":40001", waitForServer(t, app, "client3"))
+
+	//draining server is not used even with free slot
+	cluster.drain("container2")
+	assert.Equal(t, ":40002", waitForServer(t, app, "client4"))
+}
@@ -0,0 +1,152 @@
+// Package jointoken implements signed one-time join tokens. Maker signs token for each
+// reservation with Ed25519 key and sends it to game server and to client, game server
+// verifies token that client shows on join with public key
+package jointoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// header of Reservation API request and of API response to client with join token
+const HEADER = "X-Join-Token"
+
+// header of API response to client with tokens of its party members, see FormatPartyTokens
+const PARTY_HEADER = "X-Party-Join-Tokens"
+
+// env of new containers with public key of Maker, see ParsePublicKey
+const KEY_ENV = "MATCHMAKER_JOIN_KEY"
+
+var ErrInvalidToken = errors.New("invalid join token")
+var ErrTokenExpired = errors.New("join token expired")
+
+// Claims are signed content of join token
+type Claims struct {
+	ClientID string `json:"client"`
+	//server ID from common.SERVER_ID_ENV, or server address as Maker sees it for servers
+	//created without server ID: container hostname on Docker and task IP on Swarm
+	Container string `json:"container"`
+	//unix time in ms
+	ExpiresAt int64 `json:"exp"`
+	//random value, so token can be used only once
+	Nonce string `json:"nonce"`
+}
+
+// Sign returns token with claims and random nonce, token is base64 encoded claims
+// and signature separated by dot
+func Sign(key ed25519.PrivateKey, clientID string, container string, expiresAt time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(Claims{
+		ClientID:  clientID,
+		Container: container,
+		ExpiresAt: expiresAt.UnixMilli(),
+		Nonce:     hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signature := ed25519.Sign(key, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Parse verifies signature and expiry of token, use Verifier to also reject used tokens
+func Parse(key ed25519.PublicKey, token string, now time.Time) (Claims, error) {
+	claims := Claims{}
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return claims, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !ed25519.Verify(key, payload, signature) {
+		return claims, ErrInvalidToken
+	}
+
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Nonce == "" {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if now.UnixMilli() >= claims.ExpiresAt {
+		return claims, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// FormatPartyTokens encodes tokens of party members by client ID as URL query,
+// so client IDs with any characters can be passed in PARTY_HEADER
+func FormatPartyTokens(tokens map[string]string) string {
+	values := url.Values{}
+	for clientID, token := range tokens {
+		values.Set(clientID, token)
+	}
+
+	return values.Encode()
+}
+
+// ParsePartyTokens decodes value of PARTY_HEADER
+func ParsePartyTokens(header string) (map[string]string, error) {
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := map[string]string{}
+	for clientID := range values {
+		tokens[clientID] = values.Get(clientID)
+	}
+
+	return tokens, nil
+}
+
+// ParsePrivateKey decodes base64 encoded 32 bytes seed of Maker key,
+// e.g. generated with openssl rand -base64 32
+func ParsePrivateKey(seed string) (ed25519.PrivateKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != ed25519.SeedSize {
+		return nil, errors.New("join token key should be 32 bytes")
+	}
+
+	return ed25519.NewKeyFromSeed(decoded), nil
+}
+
+// ParsePublicKey decodes base64 encoded public key passed to servers in KEY_ENV
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, errors.New("join token public key should be 32 bytes")
+	}
+
+	return ed25519.PublicKey(decoded), nil
+}
+
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
@@ -0,0 +1,52 @@
+package jointoken
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndParse(t *testing.T) {
+	key, err := ParsePrivateKey("AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	assert.NoError(t, err)
+	public, err := ParsePublicKey(EncodePublicKey(key.Public().(ed25519.PublicKey)))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	token, err := Sign(key, "client1", "container1", now.Add(time.Minute))
+	assert.NoError(t, err)
+
+	claims, err := Parse(public, token, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "client1", claims.ClientID)
+	assert.Equal(t, "container1", claims.Container)
+	assert.Equal(t, now.Add(time.Minute).UnixMilli(), claims.ExpiresAt)
+	assert.NotEmpty(t, claims.Nonce)
+
+	_, err = Parse(public, token, now.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	//claims can't be changed without signature
+	other, err := Sign(key, "client2", "container1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	payload, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	_, err = Parse(public, payload+"."+signature, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = Parse(public, "garbage", now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = ParsePrivateKey("c2hvcnQ=")
+	assert.Error(t, err)
+}
+
+func TestPartyTokens(t *testing.T) {
+	tokens := map[string]string{"client1": "token1", "client=2&": "token2"}
+	parsed, err := ParsePartyTokens(FormatPartyTokens(tokens))
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, parsed)
+}
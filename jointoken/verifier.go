@@ -0,0 +1,61 @@
+package jointoken
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrTokenUsed = errors.New("join token is already used")
+var ErrWrongContainer = errors.New("join token is issued for other server")
+
+// Verifier checks join tokens on game server, each token is accepted only once
+type Verifier struct {
+	options VerifierOptions
+
+	mutex sync.Mutex
+	//expiry of used tokens by nonce, expired nonces are dropped since their tokens are rejected anyway
+	used map[string]int64
+}
+
+// Verify returns claims of valid token and marks it as used, client ID of claims
+// should be used as ID of joined player
+func (verifier *Verifier) Verify(token string) (Claims, error) {
+	now := time.Now()
+	claims, err := Parse(verifier.options.Key, token, now)
+	if err != nil {
+		return claims, err
+	}
+
+	if verifier.options.Container != "" && claims.Container != verifier.options.Container {
+		return claims, ErrWrongContainer
+	}
+
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
+	for nonce, expiresAt := range verifier.used {
+		if now.UnixMilli() >= expiresAt {
+			delete(verifier.used, nonce)
+		}
+	}
+
+	if _, ok := verifier.used[claims.Nonce]; ok {
+		return claims, ErrTokenUsed
+	}
+	verifier.used[claims.Nonce] = claims.ExpiresAt
+
+	return claims, nil
+}
+
+type VerifierOptions struct {
+	//public key of Maker
+	Key ed25519.PublicKey
+	//ID of this server from common.SERVER_ID_ENV, not checked if empty
+	Container string
+}
+
+func CreateVerifier(options VerifierOptions) *Verifier {
+	return &Verifier{options: options, used: map[string]int64{}}
+}
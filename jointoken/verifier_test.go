@@ -0,0 +1,31 @@
+package jointoken
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifier(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	verifier := CreateVerifier(VerifierOptions{Key: public, Container: "container1"})
+
+	token, err := Sign(key, "client1", "container1", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	claims, err := verifier.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "client1", claims.ClientID)
+
+	//shared token is rejected
+	_, err = verifier.Verify(token)
+	assert.ErrorIs(t, err, ErrTokenUsed)
+
+	token, err = Sign(key, "client1", "container2", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	_, err = verifier.Verify(token)
+	assert.ErrorIs(t, err, ErrWrongContainer)
+}
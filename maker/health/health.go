@@ -0,0 +1,31 @@
+package health
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/maker/processor"
+)
+
+type TenantHealth struct {
+	//state of queue circuit breaker
+	Queue string `json:"queue"`
+}
+
+type Controller struct {
+	//processors by tenant
+	Processors map[string]*processor.Processor
+}
+
+// HandleHealth responds with 503 if queue of any tenant is not available
+func (controller *Controller) HandleHealth(c *fiber.Ctx) error {
+	code := fiber.StatusOK
+	result := map[string]TenantHealth{}
+	for tenant, tenantProcessor := range controller.Processors {
+		state := tenantProcessor.QueueState()
+		if state != processor.BREAKER_CLOSED {
+			code = fiber.StatusServiceUnavailable
+		}
+		result[tenant] = TenantHealth{Queue: state}
+	}
+
+	return c.Status(code).JSON(result)
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/maker/processor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	failing := processor.CreateCircuitBreaker(processor.CircuitBreakerOptions{Threshold: 1, Backoff: time.Minute})
+	failing.Failure()
+
+	tests := []struct {
+		name       string
+		processors map[string]*processor.Processor
+		code       int
+		want       map[string]TenantHealth
+	}{
+		{
+			name:       "queue available",
+			processors: map[string]*processor.Processor{"": {}},
+			code:       fiber.StatusOK,
+			want:       map[string]TenantHealth{"": {Queue: processor.BREAKER_CLOSED}},
+		},
+		{
+			name: "queue of one tenant is not available",
+			processors: map[string]*processor.Processor{
+				"shooter": {},
+				"racing":  {QueueBreaker: failing},
+			},
+			code: fiber.StatusServiceUnavailable,
+			want: map[string]TenantHealth{
+				"shooter": {Queue: processor.BREAKER_CLOSED},
+				"racing":  {Queue: processor.BREAKER_OPEN},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := Controller{Processors: test.processors}
+			app := fiber.New()
+			app.Get("/health", controller.HandleHealth)
+
+			httpRequest, err := http.NewRequest("GET", "/health", nil)
+			assert.NoError(t, err)
+
+			response, err := app.Test(httpRequest)
+			assert.NoError(t, err)
+			defer response.Body.Close()
+			assert.Equal(t, test.code, response.StatusCode)
+
+			result := map[string]TenantHealth{}
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+			assert.Equal(t, test.want, result)
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
@@ -17,11 +18,18 @@ import (
 
 const DOCKER_INTERACTOR = "docker"
 
+// node ID of FleetUsage, all containers run on the same Docker host
+const DOCKER_NODE = "local"
+
 type DockerInteractor struct {
 	dockerClient *client.Client
 
 	network string
+	tenant  string
 	image   ImageInfo
+	//containers of all tenants on the host, unlimited if 0
+	maxContainersPerNode int
+	nodeCreations        *NodeCreations
 }
 
 func (interactor *DockerInteractor) ListContainers() ([]string, error) {
@@ -29,6 +37,9 @@ func (interactor *DockerInteractor) ListContainers() ([]string, error) {
 	ctx := context.Background()
 
 	args := filters.NewArgs(filters.KeyValuePair{Key: "ancestor", Value: interactor.image.ImageName}, filters.KeyValuePair{Key: "status", Value: "running"})
+	if interactor.tenant != "" {
+		args.Add("label", TENANT_LABEL+"="+interactor.tenant)
+	}
 	containers, err := interactor.dockerClient.ContainerList(ctx, types.ContainerListOptions{Filters: args})
 	if err != nil {
 		return result, err
@@ -57,12 +68,25 @@ func (interactor *DockerInteractor) InspectContainer(id string) (ContainerInfo,
 
 	result.Address = containerInfo.Config.Hostname
 	result.ExposedPort = binding[0].HostPort
+	result.ServerID = getServerID(containerInfo.Config.Env)
 
 	return result, nil
 }
 
-func (interactor *DockerInteractor) CreateContainer() (string, error) {
+func (interactor *DockerInteractor) CreateContainer(env map[string]string) (string, error) {
 	ctx := context.Background()
+	if interactor.maxContainersPerNode > 0 {
+		usage, err := interactor.GetFleetUsage()
+		if err != nil {
+			return "", err
+		}
+
+		if !interactor.nodeCreations.Reserve(DOCKER_NODE, usage.Total, interactor.maxContainersPerNode) {
+			return "", ErrFleetLimit
+		}
+		defer interactor.nodeCreations.Release(DOCKER_NODE)
+	}
+
 	pullOptions := types.ImagePullOptions{}
 	if interactor.image.ImageRegistryUsername != "" {
 		authConfig := registry.AuthConfig{
@@ -106,7 +130,13 @@ func (interactor *DockerInteractor) CreateContainer() (string, error) {
 	hostConfig.NetworkMode = container.NetworkMode(interactor.network)
 
 	log.Println("Creating continer")
-	resp, err := interactor.dockerClient.ContainerCreate(ctx, &container.Config{Image: interactor.image.ImageName}, &hostConfig, nil, nil, "")
+	containerConfig := container.Config{Image: interactor.image.ImageName, Env: interactor.image.containerEnv(env)}
+	containerConfig.Labels = map[string]string{MANAGED_LABEL: "true"}
+	if interactor.tenant != "" {
+		containerConfig.Labels[TENANT_LABEL] = interactor.tenant
+	}
+
+	resp, err := interactor.dockerClient.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, "")
 	if err != nil {
 		return "", err
 	}
@@ -114,14 +144,99 @@ func (interactor *DockerInteractor) CreateContainer() (string, error) {
 
 	err = interactor.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
 	if err != nil {
+		//container is not listed as running, so reaper would never remove it
+		removeErr := interactor.dockerClient.ContainerRemove(ctx, resp.ID, container.RemoveOptions{Force: true})
+		if removeErr != nil {
+			log.Printf("Failed to remove container %v: %v", resp.ID, removeErr)
+		}
 		return "", err
 	}
 
 	return resp.ID, nil
 }
 
+// server gets SIGTERM and is killed after default timeout of the container
+func (interactor *DockerInteractor) StopContainer(id string) error {
+	ctx := context.Background()
+	log.Printf("Stopping container %v", id)
+	return interactor.dockerClient.ContainerStop(ctx, id, container.StopOptions{})
+}
+
+func (interactor *DockerInteractor) RemoveContainer(id string) error {
+	ctx := context.Background()
+	log.Printf("Removing container %v", id)
+	return interactor.dockerClient.ContainerRemove(ctx, id, container.RemoveOptions{})
+}
+
+func (interactor *DockerInteractor) GetFleetUsage() (FleetUsage, error) {
+	ctx := context.Background()
+	args := filters.NewArgs(filters.KeyValuePair{Key: "label", Value: MANAGED_LABEL}, filters.KeyValuePair{Key: "status", Value: "running"})
+	containers, err := interactor.dockerClient.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return FleetUsage{}, err
+	}
+
+	return FleetUsage{Total: len(containers), Nodes: map[string]int{DOCKER_NODE: len(containers)}}, nil
+}
+
+func (interactor *DockerInteractor) WatchEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	result := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+
+	args := filters.NewArgs(
+		filters.KeyValuePair{Key: "type", Value: string(events.ContainerEventType)},
+		filters.KeyValuePair{Key: "image", Value: interactor.image.ImageName},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionCreate)},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionStart)},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionDie)},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionDestroy)},
+	)
+	if interactor.tenant != "" {
+		args.Add("label", TENANT_LABEL+"="+interactor.tenant)
+	}
+	messages, messageErrs := interactor.dockerClient.Events(ctx, types.EventsOptions{Filters: args})
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-messageErrs:
+				errs <- err
+				return
+			case message := <-messages:
+				event := ContainerEvent{ID: message.Actor.ID}
+				switch message.Action {
+				case events.ActionCreate:
+					event.Type = CONTAINER_CREATED
+				case events.ActionStart:
+					event.Type = CONTAINER_STARTED
+				case events.ActionDie:
+					event.Type = CONTAINER_STOPPED
+				default:
+					event.Type = CONTAINER_REMOVED
+				}
+
+				select {
+				case result <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return result, errs
+}
+
 type DockerContainerInteractorOptions struct {
 	DockerNetwork string
+	//containers of all tenants on the host, unlimited if 0
+	MaxContainersPerNode int
+	//shared by interactors of all tenants, own counter is used if nil
+	NodeCreations *NodeCreations
+	//empty for single tenant setup
+	Tenant string
 }
 
 func CreateDockerContainerInteractor(image ImageInfo, options DockerContainerInteractorOptions) (ContainerInteractor, error) {
@@ -130,10 +245,19 @@ func CreateDockerContainerInteractor(image ImageInfo, options DockerContainerInt
 		return nil, err
 	}
 
+	nodeCreations := options.NodeCreations
+	if nodeCreations == nil {
+		nodeCreations = CreateNodeCreations()
+	}
+
 	interactor := DockerInteractor{
 		dockerClient: docker,
 		image:        image,
 		network:      options.DockerNetwork,
+		tenant:       options.Tenant,
+
+		maxContainersPerNode: options.MaxContainersPerNode,
+		nodeCreations:        nodeCreations,
 	}
 
 	return &interactor, nil
@@ -1,6 +1,26 @@
 package interactor
 
-import "github.com/docker/go-connections/nat"
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/controlauth"
+)
+
+// containers of tenant are labeled with tenant name, so tenants that use
+// same image don't reserve slots in each other's containers
+const TENANT_LABEL = "go-matchmaker.tenant"
+
+// containers of all tenants are labeled, so fleet limits count them together
+const MANAGED_LABEL = "go-matchmaker.managed"
+
+// returned by CreateContainer if all nodes have MaxContainersPerNode containers
+var ErrFleetLimit = errors.New("fleet container limit is reached")
 
 type ImageInfo struct {
 	ImageRegistryUsername string
@@ -9,15 +29,117 @@ type ImageInfo struct {
 	ImageName        string
 	ImageExposedPort nat.Port
 	ImageControlPort nat.Port
+	//secret of Reservation API request signatures passed to new containers, requests are not signed if empty
+	ControlSecret string
+	//version of Reservation API implemented by image, passed to new containers, v1 if empty
+	ReservationAPI string
 }
 
 type ContainerInfo struct {
 	Address     string
 	ExposedPort string
+	//from common.SERVER_ID_ENV, empty for containers created without it
+	ServerID string
+}
+
+// FleetUsage is number of running containers created by go-matchmaker for all tenants
+type FleetUsage struct {
+	Total int
+	//by node ID, single node is used for Docker
+	Nodes map[string]int
+}
+
+// types of ContainerEvent
+const (
+	CONTAINER_CREATED = "created"
+	CONTAINER_STARTED = "started"
+	CONTAINER_STOPPED = "stopped"
+	CONTAINER_REMOVED = "removed"
+)
+
+// ContainerEvent is change of container listed by ListContainers. Swarm reports
+// only service creation and removal, service is listed when its task is running
+type ContainerEvent struct {
+	ID   string
+	Type string
+}
+
+// containerEnv adds control key of server and Reservation API version of image to env from Maker and formats it as KEY=VALUE
+// list sorted by key, so container spec doesn't depend on map order
+func (image ImageInfo) containerEnv(env map[string]string) []string {
+	result := []string{}
+	for key, value := range env {
+		result = append(result, key+"="+value)
+	}
+
+	if image.ControlSecret != "" {
+		result = append(result, controlauth.SECRET_ENV+"="+controlauth.DeriveKey(image.ControlSecret, env[common.SERVER_ID_ENV]))
+	}
+
+	if image.ReservationAPI != "" {
+		result = append(result, common.RESERVATION_API_ENV+"="+image.ReservationAPI)
+	}
+	sort.Strings(result)
+
+	return result
 }
 
 type ContainerInteractor interface {
 	ListContainers() ([]string, error)
 	InspectContainer(id string) (ContainerInfo, error)
-	CreateContainer() (string, error)
+	//env is set in addition to environment of the image
+	CreateContainer(env map[string]string) (string, error)
+	//stopped container is not listed, but keeps its resources until it's removed
+	StopContainer(id string) error
+	RemoveContainer(id string) error
+	GetFleetUsage() (FleetUsage, error)
+	//events are sent until ctx is done or error is sent, events of other tenants
+	//and images are skipped
+	WatchEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error)
+}
+
+// env is in KEY=VALUE form as Docker returns it
+func getServerID(env []string) string {
+	for _, variable := range env {
+		value, found := strings.CutPrefix(variable, common.SERVER_ID_ENV+"=")
+		if found {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// NodeCreations counts containers being created on each node, they are not
+// running yet, so they are not included in FleetUsage
+type NodeCreations struct {
+	mutex sync.Mutex
+	nodes map[string]int
+}
+
+// reserves creation on node if running and pending containers are below limit
+func (creations *NodeCreations) Reserve(node string, used int, limit int) bool {
+	creations.mutex.Lock()
+	defer creations.mutex.Unlock()
+
+	if used+creations.nodes[node] >= limit {
+		return false
+	}
+
+	creations.nodes[node]++
+	return true
+}
+
+func (creations *NodeCreations) Release(node string) {
+	creations.mutex.Lock()
+	defer creations.mutex.Unlock()
+
+	creations.nodes[node]--
+	if creations.nodes[node] <= 0 {
+		delete(creations.nodes, node)
+	}
+}
+
+func CreateNodeCreations() *NodeCreations {
+	return &NodeCreations{nodes: map[string]int{}}
 }
@@ -0,0 +1,20 @@
+package interactor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeCreations(t *testing.T) {
+	creations := CreateNodeCreations()
+
+	assert.True(t, creations.Reserve("a", 1, 3))
+	//pending creation is counted with running containers
+	assert.False(t, creations.Reserve("a", 2, 3))
+	assert.True(t, creations.Reserve("b", 2, 3))
+
+	creations.Release("a")
+	assert.True(t, creations.Reserve("a", 2, 3))
+	assert.False(t, creations.Reserve("a", 2, 3))
+}
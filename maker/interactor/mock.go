@@ -1,6 +1,8 @@
 package interactor
 
 import (
+	"context"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -22,7 +24,27 @@ func (mocked *MockInteractor) InspectContainer(id string) (ContainerInfo, error)
 	return args.Get(0).(ContainerInfo), args.Error(1)
 }
 
-func (mocked *MockInteractor) CreateContainer() (string, error) {
-	args := mocked.Called()
+func (mocked *MockInteractor) CreateContainer(env map[string]string) (string, error) {
+	args := mocked.Called(env)
 	return args.String(0), args.Error(1)
 }
+
+func (mocked *MockInteractor) StopContainer(id string) error {
+	args := mocked.Called(id)
+	return args.Error(0)
+}
+
+func (mocked *MockInteractor) RemoveContainer(id string) error {
+	args := mocked.Called(id)
+	return args.Error(0)
+}
+
+func (mocked *MockInteractor) GetFleetUsage() (FleetUsage, error) {
+	args := mocked.Called()
+	return args.Get(0).(FleetUsage), args.Error(1)
+}
+
+func (mocked *MockInteractor) WatchEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	args := mocked.Called(ctx)
+	return args.Get(0).(chan ContainerEvent), args.Get(1).(chan error)
+}
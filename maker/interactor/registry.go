@@ -0,0 +1,340 @@
+package interactor
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// used if ContainerRegistryOptions fields are not set
+const DEFAULT_REGISTRY_RESYNC_INTERVAL = time.Minute
+const DEFAULT_REGISTRY_WATCH_COOLDOWN = 5 * time.Second
+const DEFAULT_REGISTRY_INSPECT_COOLDOWN = time.Second
+
+// new container can have no port binding or task yet, so it's inspected again
+const REGISTRY_INSPECT_RETRIES = 5
+
+type registryEntry struct {
+	info ContainerInfo
+	//version of the change that added container, also used as registration order
+	version uint64
+}
+
+// ContainerRegistry keeps running containers of wrapped interactor in memory, so
+// lookups don't list and inspect containers on each request. Containers are synced
+// on first use, Run keeps them in sync with container events and full resyncs
+type ContainerRegistry struct {
+	interactor ContainerInteractor
+
+	resyncInterval  time.Duration
+	watchCooldown   time.Duration
+	inspectCooldown time.Duration
+
+	mutex  sync.Mutex
+	synced bool
+	//incremented on each change, so resync doesn't revert changes that were made
+	//while it was listing containers
+	version    uint64
+	containers map[string]registryEntry
+	//version of container removal by container ID
+	removed map[string]uint64
+
+	syncMutex sync.Mutex
+}
+
+func (registry *ContainerRegistry) ListContainers() ([]string, error) {
+	err := registry.ensureSynced()
+	if err != nil {
+		return []string{}, err
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	result := make([]string, 0, len(registry.containers))
+	for id := range registry.containers {
+		result = append(result, id)
+	}
+
+	//map order is random, containers are listed in order they were registered
+	sort.Slice(result, func(i, j int) bool {
+		return registry.containers[result[i]].version < registry.containers[result[j]].version
+	})
+
+	return result, nil
+}
+
+// not registered container is inspected by interactor, so container that was just
+// created is registered before its start event is received
+func (registry *ContainerRegistry) InspectContainer(id string) (ContainerInfo, error) {
+	registry.mutex.Lock()
+	entry, found := registry.containers[id]
+	since := registry.version
+	registry.mutex.Unlock()
+
+	if found {
+		return entry.info, nil
+	}
+
+	info, err := registry.interactor.InspectContainer(id)
+	if err != nil {
+		return info, err
+	}
+
+	registry.add(id, info, since)
+	return info, nil
+}
+
+func (registry *ContainerRegistry) CreateContainer(env map[string]string) (string, error) {
+	return registry.interactor.CreateContainer(env)
+}
+
+func (registry *ContainerRegistry) StopContainer(id string) error {
+	err := registry.interactor.StopContainer(id)
+	if err != nil {
+		return err
+	}
+
+	registry.remove(id)
+	return nil
+}
+
+func (registry *ContainerRegistry) RemoveContainer(id string) error {
+	err := registry.interactor.RemoveContainer(id)
+	if err != nil {
+		return err
+	}
+
+	registry.remove(id)
+	return nil
+}
+
+// containers of all tenants are not registered, so usage is always requested
+func (registry *ContainerRegistry) GetFleetUsage() (FleetUsage, error) {
+	return registry.interactor.GetFleetUsage()
+}
+
+func (registry *ContainerRegistry) WatchEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	return registry.interactor.WatchEvents(ctx)
+}
+
+// Run keeps registry in sync until ctx is done, events are watched again after
+// watch cooldown if events stream fails
+func (registry *ContainerRegistry) Run(ctx context.Context) {
+	for {
+		err := registry.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Container events watch failed: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registry.watchCooldown):
+		}
+	}
+}
+
+func (registry *ContainerRegistry) watch(ctx context.Context) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//events are watched before resync, so changes made during resync are not missed
+	events, errs := registry.interactor.WatchEvents(watchCtx)
+	err := registry.resync()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(registry.resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case event := <-events:
+			registry.handleEvent(watchCtx, event)
+		case <-ticker.C:
+			err := registry.resync()
+			if err != nil {
+				log.Printf("Failed to resync container registry: %v", err)
+			}
+		}
+	}
+}
+
+func (registry *ContainerRegistry) handleEvent(ctx context.Context, event ContainerEvent) {
+	switch event.Type {
+	//Swarm doesn't report start, so created service is inspected until its task is running
+	case CONTAINER_CREATED, CONTAINER_STARTED:
+		registry.mutex.Lock()
+		since := registry.version
+		registry.mutex.Unlock()
+
+		//inspection with retries doesn't block following events
+		go registry.register(ctx, event.ID, since)
+	case CONTAINER_STOPPED, CONTAINER_REMOVED:
+		registry.remove(event.ID)
+	}
+}
+
+func (registry *ContainerRegistry) register(ctx context.Context, id string, since uint64) {
+	for i := 0; i < REGISTRY_INSPECT_RETRIES; i++ {
+		info, err := registry.interactor.InspectContainer(id)
+		if err == nil {
+			registry.add(id, info, since)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registry.inspectCooldown):
+		}
+	}
+
+	log.Printf("Failed to register container %v", id)
+}
+
+func (registry *ContainerRegistry) ensureSynced() error {
+	registry.mutex.Lock()
+	synced := registry.synced
+	registry.mutex.Unlock()
+
+	if synced {
+		return nil
+	}
+
+	return registry.resync()
+}
+
+// resync replaces registered containers with listed ones, containers are inspected
+// again since Swarm can reschedule service task to other address
+func (registry *ContainerRegistry) resync() error {
+	registry.syncMutex.Lock()
+	defer registry.syncMutex.Unlock()
+
+	registry.mutex.Lock()
+	since := registry.version
+	registry.mutex.Unlock()
+
+	ids, err := registry.interactor.ListContainers()
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]ContainerInfo{}
+	for _, id := range ids {
+		info, err := registry.interactor.InspectContainer(id)
+		if err != nil {
+			log.Printf("Failed InspectContainer on container %v: %v", id, err)
+			continue
+		}
+
+		listed[id] = info
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	containers := map[string]registryEntry{}
+	for _, id := range ids {
+		info, found := listed[id]
+		if !found {
+			continue
+		}
+
+		entry, registered := registry.containers[id]
+		if !registered {
+			registry.version++
+			entry.version = registry.version
+		}
+		entry.info = info
+		containers[id] = entry
+	}
+
+	//changes made during resync are newer than listed containers
+	for id, entry := range registry.containers {
+		if entry.version > since {
+			containers[id] = entry
+		}
+	}
+
+	for id, version := range registry.removed {
+		if version > since {
+			delete(containers, id)
+		} else {
+			delete(registry.removed, id)
+		}
+	}
+
+	registry.containers = containers
+	registry.synced = true
+	return nil
+}
+
+// container removed after since is not added back by stale inspection
+func (registry *ContainerRegistry) add(id string, info ContainerInfo, since uint64) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.removed[id] > since {
+		return
+	}
+
+	entry, registered := registry.containers[id]
+	if !registered {
+		registry.version++
+		entry.version = registry.version
+	}
+	entry.info = info
+	registry.containers[id] = entry
+}
+
+func (registry *ContainerRegistry) remove(id string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.version++
+	delete(registry.containers, id)
+	registry.removed[id] = registry.version
+}
+
+type ContainerRegistryOptions struct {
+	//events missed by watcher are fixed by full resync
+	ResyncInterval time.Duration
+	//delay before events are watched again after events stream failed
+	WatchCooldown time.Duration
+	//delay between inspections of started container
+	InspectCooldown time.Duration
+}
+
+func CreateContainerRegistry(interactor ContainerInteractor, options ContainerRegistryOptions) *ContainerRegistry {
+	registry := ContainerRegistry{
+		interactor:      interactor,
+		resyncInterval:  options.ResyncInterval,
+		watchCooldown:   options.WatchCooldown,
+		inspectCooldown: options.InspectCooldown,
+		containers:      map[string]registryEntry{},
+		removed:         map[string]uint64{},
+	}
+
+	if registry.resyncInterval <= 0 {
+		registry.resyncInterval = DEFAULT_REGISTRY_RESYNC_INTERVAL
+	}
+
+	if registry.watchCooldown <= 0 {
+		registry.watchCooldown = DEFAULT_REGISTRY_WATCH_COOLDOWN
+	}
+
+	if registry.inspectCooldown <= 0 {
+		registry.inspectCooldown = DEFAULT_REGISTRY_INSPECT_COOLDOWN
+	}
+
+	return &registry
+}
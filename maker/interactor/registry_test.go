@@ -0,0 +1,92 @@
+package interactor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRegistryCache(t *testing.T) {
+	mocked := new(MockInteractor)
+	mocked.On("ListContainers").Return([]string{"a", "b"}, nil).Once()
+	mocked.On("InspectContainer", "a").Return(ContainerInfo{Address: "a"}, nil).Once()
+	mocked.On("InspectContainer", "b").Return(ContainerInfo{}, errors.New("no binding")).Once()
+	mocked.On("InspectContainer", "c").Return(ContainerInfo{Address: "c"}, nil).Once()
+	mocked.On("RemoveContainer", "a").Return(nil).Once()
+	registry := CreateContainerRegistry(mocked, ContainerRegistryOptions{})
+
+	//containers that failed inspection are not registered
+	for i := 0; i < 2; i++ {
+		containers, err := registry.ListContainers()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, containers)
+
+		info, err := registry.InspectContainer("a")
+		assert.NoError(t, err)
+		assert.Equal(t, "a", info.Address)
+	}
+
+	//created container is registered on first inspection
+	_, err := registry.InspectContainer("c")
+	assert.NoError(t, err)
+	err = registry.RemoveContainer("a")
+	assert.NoError(t, err)
+
+	containers, err := registry.ListContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, containers)
+	mocked.AssertExpectations(t)
+}
+
+func TestRegistryEvents(t *testing.T) {
+	events := make(chan ContainerEvent)
+	errs := make(chan error)
+	mocked := new(MockInteractor)
+	mocked.On("WatchEvents", mock.Anything).Return(events, errs).Once()
+	mocked.On("ListContainers").Return([]string{"a"}, nil).Once()
+	mocked.On("InspectContainer", "a").Return(ContainerInfo{Address: "a"}, nil).Once()
+	//started container has no binding on first inspection
+	mocked.On("InspectContainer", "b").Return(ContainerInfo{}, errors.New("no binding")).Once()
+	mocked.On("InspectContainer", "b").Return(ContainerInfo{Address: "b"}, nil).Once()
+	registry := CreateContainerRegistry(mocked, ContainerRegistryOptions{InspectCooldown: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go registry.Run(ctx)
+
+	events <- ContainerEvent{ID: "b", Type: CONTAINER_STARTED}
+	events <- ContainerEvent{ID: "a", Type: CONTAINER_REMOVED}
+	assert.Eventually(t, func() bool {
+		containers, err := registry.ListContainers()
+		return err == nil && len(containers) == 1 && containers[0] == "b"
+	}, time.Second, 10*time.Millisecond)
+	mocked.AssertExpectations(t)
+}
+
+func TestRegistryResync(t *testing.T) {
+	mocked := new(MockInteractor)
+	registry := CreateContainerRegistry(mocked, ContainerRegistryOptions{})
+
+	//a is removed and c is created while containers are listed
+	mocked.On("ListContainers").Return([]string{"a", "b"}, nil).Once().Run(func(args mock.Arguments) {
+		registry.remove("a")
+		registry.add("c", ContainerInfo{Address: "c"}, 0)
+	})
+	mocked.On("InspectContainer", "a").Return(ContainerInfo{Address: "a"}, nil).Once()
+	mocked.On("InspectContainer", "b").Return(ContainerInfo{Address: "b"}, nil).Once()
+
+	containers, err := registry.ListContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b"}, containers)
+
+	//stale inspection doesn't add removed container back
+	registry.add("a", ContainerInfo{Address: "a"}, 0)
+	containers, err = registry.ListContainers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "b"}, containers)
+	mocked.AssertExpectations(t)
+}
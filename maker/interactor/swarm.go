@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/api/types/swarm"
@@ -24,8 +25,11 @@ type SwarmInteractor struct {
 
 	image                  ImageInfo
 	network                string
+	tenant                 string
 	ConvergeVerifyCooldown int
 	ConvergeVerifyRetries  int
+	//containers of all tenants on one node, unlimited if 0
+	maxContainersPerNode int
 }
 
 func (interactor *SwarmInteractor) ListContainers() ([]string, error) {
@@ -43,7 +47,7 @@ func (interactor *SwarmInteractor) ListContainers() ([]string, error) {
 			continue
 		}
 
-		if !strings.HasPrefix(service.Spec.TaskTemplate.ContainerSpec.Image, interactor.image.ImageName) {
+		if !interactor.isTenantService(service.Spec) {
 			continue
 		}
 
@@ -114,10 +118,13 @@ func (interactor *SwarmInteractor) InspectContainer(id string) (ContainerInfo, e
 
 	result.Address = containerIP
 	result.ExposedPort = exposedPort
+	if service.Spec.TaskTemplate.ContainerSpec != nil {
+		result.ServerID = getServerID(service.Spec.TaskTemplate.ContainerSpec.Env)
+	}
 	return result, nil
 }
 
-func (interactor *SwarmInteractor) CreateContainer() (string, error) {
+func (interactor *SwarmInteractor) CreateContainer(env map[string]string) (string, error) {
 	ctx := context.Background()
 	serviceCreateOptions := types.ServiceCreateOptions{}
 	if interactor.image.ImageRegistryUsername != "" {
@@ -138,6 +145,7 @@ func (interactor *SwarmInteractor) CreateContainer() (string, error) {
 
 	containerSpec := swarm.ContainerSpec{}
 	containerSpec.Image = interactor.image.ImageName
+	containerSpec.Env = interactor.image.containerEnv(env)
 
 	//range of ports used for bindings can be limited in
 	///proc/sys/net/ipv4/ip_local_port_range
@@ -156,6 +164,20 @@ func (interactor *SwarmInteractor) CreateContainer() (string, error) {
 	restartPolicy := swarm.RestartPolicy{}
 	restartPolicy.Condition = swarm.RestartPolicyConditionNone
 
+	serviceSpec.Labels = map[string]string{MANAGED_LABEL: "true"}
+	if interactor.tenant != "" {
+		serviceSpec.Labels[TENANT_LABEL] = interactor.tenant
+	}
+
+	//full nodes are excluded with placement constraints
+	if interactor.maxContainersPerNode > 0 {
+		constraints, err := interactor.getNodeConstraints()
+		if err != nil {
+			return "", err
+		}
+		serviceSpec.TaskTemplate.Placement = &swarm.Placement{Constraints: constraints}
+	}
+
 	replicas := uint64(1)
 	serviceSpec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
 	serviceSpec.TaskTemplate.ContainerSpec = &containerSpec
@@ -195,6 +217,144 @@ func (interactor *SwarmInteractor) CreateContainer() (string, error) {
 	return response.ID, nil
 }
 
+// service can't be stopped without changing its spec, so it's stopped on removal
+func (interactor *SwarmInteractor) StopContainer(id string) error {
+	return nil
+}
+
+func (interactor *SwarmInteractor) RemoveContainer(id string) error {
+	ctx := context.Background()
+	log.Printf("Removing service %v", id)
+	return interactor.dockerClient.ServiceRemove(ctx, id)
+}
+
+// tasks without node are not scheduled yet, they are counted only in total
+func (interactor *SwarmInteractor) GetFleetUsage() (FleetUsage, error) {
+	result := FleetUsage{Nodes: map[string]int{}}
+	ctx := context.Background()
+
+	serviceArgs := filters.NewArgs(filters.KeyValuePair{Key: "label", Value: MANAGED_LABEL})
+	services, err := interactor.dockerClient.ServiceList(ctx, types.ServiceListOptions{Filters: serviceArgs})
+	if err != nil {
+		return result, err
+	}
+
+	managed := map[string]bool{}
+	for _, service := range services {
+		managed[service.ID] = true
+	}
+
+	taskArgs := filters.NewArgs(filters.KeyValuePair{Key: "desired-state", Value: "running"})
+	tasks, err := interactor.dockerClient.TaskList(ctx, types.TaskListOptions{Filters: taskArgs})
+	if err != nil {
+		return result, err
+	}
+
+	for _, task := range tasks {
+		if !managed[task.ServiceID] {
+			continue
+		}
+
+		result.Total++
+		if task.NodeID != "" {
+			result.Nodes[task.NodeID]++
+		}
+	}
+
+	return result, nil
+}
+
+// returns constraints that exclude nodes with maxContainersPerNode containers
+func (interactor *SwarmInteractor) getNodeConstraints() ([]string, error) {
+	ctx := context.Background()
+	usage, err := interactor.GetFleetUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := interactor.dockerClient.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	constraints := []string{}
+	available := 0
+	for _, node := range nodes {
+		if node.Status.State != swarm.NodeStateReady || node.Spec.Availability != swarm.NodeAvailabilityActive {
+			continue
+		}
+
+		if usage.Nodes[node.ID] >= interactor.maxContainersPerNode {
+			constraints = append(constraints, "node.id!="+node.ID)
+		} else {
+			available++
+		}
+	}
+
+	if available == 0 {
+		return nil, ErrFleetLimit
+	}
+
+	return constraints, nil
+}
+
+// service events have no labels, so created services are inspected to skip other
+// tenants and images, removal of unknown service is reported as is
+func (interactor *SwarmInteractor) WatchEvents(ctx context.Context) (<-chan ContainerEvent, <-chan error) {
+	result := make(chan ContainerEvent)
+	errs := make(chan error, 1)
+
+	args := filters.NewArgs(
+		filters.KeyValuePair{Key: "type", Value: string(events.ServiceEventType)},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionCreate)},
+		filters.KeyValuePair{Key: "event", Value: string(events.ActionRemove)},
+	)
+	messages, messageErrs := interactor.dockerClient.Events(ctx, types.EventsOptions{Filters: args})
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-messageErrs:
+				errs <- err
+				return
+			case message := <-messages:
+				event := ContainerEvent{ID: message.Actor.ID, Type: CONTAINER_REMOVED}
+				if message.Action == events.ActionCreate {
+					service, _, err := interactor.dockerClient.ServiceInspectWithRaw(ctx, message.Actor.ID, types.ServiceInspectOptions{})
+					if err != nil {
+						log.Printf("Failed to inspect created service %v: %v", message.Actor.ID, err)
+						continue
+					}
+
+					if !interactor.isTenantService(service.Spec) {
+						continue
+					}
+
+					event.Type = CONTAINER_CREATED
+				}
+
+				select {
+				case result <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return result, errs
+}
+
+func (interactor *SwarmInteractor) isTenantService(spec swarm.ServiceSpec) bool {
+	if !strings.HasPrefix(spec.TaskTemplate.ContainerSpec.Image, interactor.image.ImageName) {
+		return false
+	}
+
+	return interactor.tenant == "" || spec.Labels[TENANT_LABEL] == interactor.tenant
+}
+
 func (interactor *SwarmInteractor) getServiceTask(id string) (*swarm.Task, error) {
 	ctx := context.Background()
 	args := filters.NewArgs(filters.KeyValuePair{Key: "service", Value: id})
@@ -217,6 +377,10 @@ type SwarmContainerInteractorOptions struct {
 	DockerNetwork          string
 	ConvergeVerifyCooldown int
 	ConvergeVerifyRetries  int
+	//containers of all tenants on one node, unlimited if 0
+	MaxContainersPerNode int
+	//empty for single tenant setup
+	Tenant string
 }
 
 func CreateSwarmContainerInteractor(image ImageInfo, options SwarmContainerInteractorOptions) (ContainerInteractor, error) {
@@ -229,6 +393,8 @@ func CreateSwarmContainerInteractor(image ImageInfo, options SwarmContainerInter
 		dockerClient:           docker,
 		image:                  image,
 		network:                options.DockerNetwork,
+		tenant:                 options.Tenant,
+		maxContainersPerNode:   options.MaxContainersPerNode,
 		ConvergeVerifyCooldown: options.ConvergeVerifyCooldown,
 		ConvergeVerifyRetries:  options.ConvergeVerifyRetries,
 	}
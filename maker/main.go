@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/docker/go-connections/nat"
+	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/config"
 	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/st-matskevich/go-matchmaker/maker/health"
 	"github.com/st-matskevich/go-matchmaker/maker/interactor"
 	"github.com/st-matskevich/go-matchmaker/maker/processor"
+	"github.com/st-matskevich/go-matchmaker/maker/sdk"
 )
 
 func main() {
@@ -22,113 +35,402 @@ func main() {
 		log.Println("No .env file found")
 	}
 
-	redisServerURL := os.Getenv("REDIS_SERVER_URL")
-	clientRedis, err := data.CreateRedisDataProvider(redisServerURL)
+	tenants, err := config.GetTenants()
 	if err != nil {
-		log.Fatalf("Redis connection error: %v", err)
+		log.Fatalf("Failed to parse tenants: %v", err)
 	}
-	log.Println("Connected to Redis")
 
-	image, err := getImageInfo()
+	processors := map[string]*processor.Processor{}
+	registries := []*interactor.ContainerRegistry{}
+	//nodes are shared by tenants, so creations in flight are counted for all of them
+	nodeCreations := interactor.CreateNodeCreations()
+	for _, tenant := range tenants {
+		processor, registry, err := initTenant(tenant, nodeCreations)
+		if err != nil {
+			log.Fatalf("Failed to initialize tenant %q: %v", tenant, err)
+		}
+
+		processors[tenant] = processor
+		registries = append(registries, registry)
+	}
+
+	//processors stop popping requests on SIGTERM and finish running jobs
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	//health endpoint is disabled if port is not set
+	healthPort := os.Getenv("HEALTH_PORT")
+	if healthPort != "" {
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		app.Get("/health", (&health.Controller{Processors: processors}).HandleHealth)
+		go func() {
+			<-ctx.Done()
+			app.Shutdown()
+		}()
+		go func() {
+			err := app.Listen(":" + healthPort)
+			if err != nil {
+				log.Fatalf("Health endpoint error: %v", err)
+			}
+		}()
+		log.Printf("Enabled health endpoint on port %v", healthPort)
+	}
+
+	//SDK endpoint is disabled if port is not set, servers reach it with SDK_URL of tenant
+	sdkPort := os.Getenv("SDK_PORT")
+	if sdkPort != "" {
+		app := fiber.New(fiber.Config{DisableStartupMessage: true})
+		app.Post("/sdk/:report", (&sdk.Controller{Processors: processors}).HandleReport)
+		go func() {
+			<-ctx.Done()
+			app.Shutdown()
+		}()
+		go func() {
+			err := app.Listen(":" + sdkPort)
+			if err != nil {
+				log.Fatalf("SDK endpoint error: %v", err)
+			}
+		}()
+		log.Printf("Enabled SDK endpoint on port %v", sdkPort)
+	}
+
+	for _, registry := range registries {
+		go registry.Run(ctx)
+	}
+
+	//each tenant is served by own processor, first processor error stops the service
+	errs := make(chan error, len(processors))
+	for _, tenantProcessor := range processors {
+		tenantProcessor := tenantProcessor
+		go func() {
+			errs <- tenantProcessor.Process(ctx)
+		}()
+		go tenantProcessor.Reap(ctx)
+		go tenantProcessor.KeepWarmPool(ctx)
+		go tenantProcessor.WatchServers(ctx)
+	}
+
+	var result error
+	for range processors {
+		err := <-errs
+		if err != nil && result == nil {
+			result = err
+			stop()
+		}
+	}
+
+	if result != nil {
+		log.Fatal(result)
+	}
+
+	log.Println("Maker service stopped")
+}
+
+func initTenant(tenant string, nodeCreations *interactor.NodeCreations) (*processor.Processor, *interactor.ContainerRegistry, error) {
+	dataProvider, err := config.CreateDataProvider(tenant)
 	if err != nil {
-		log.Fatalf("Failed to parse image info: %v", err)
+		return nil, nil, err
 	}
-	log.Println("Parsed image info")
+	log.Printf("Connected to data backend for tenant %q", tenant)
 
-	containerInteractor, err := initInteractor(image)
+	image, err := getImageInfo(tenant)
 	if err != nil {
-		log.Fatalf("Failed to create container interactor: %v", err)
+		return nil, nil, err
 	}
-	log.Println("Created container interactor")
+	log.Printf("Parsed image info for tenant %q", tenant)
 
-	processor, err := initProcessor(image, clientRedis, containerInteractor)
+	containerInteractor, err := initInteractor(tenant, image, nodeCreations)
 	if err != nil {
-		log.Fatalf("Failed to initialize Processor: %v", err)
+		return nil, nil, err
 	}
+	log.Printf("Created container interactor for tenant %q", tenant)
 
-	log.Fatal(processor.Process())
+	//lookups read containers from registry, it's kept in sync by container events
+	resyncInterval, err := getOptionalInt(tenant, "REGISTRY_RESYNC_INTERVAL", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry := interactor.CreateContainerRegistry(containerInteractor, interactor.ContainerRegistryOptions{
+		ResyncInterval: time.Duration(resyncInterval) * time.Millisecond,
+	})
+
+	processor, err := initProcessor(tenant, image, dataProvider, registry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return processor, registry, nil
 }
 
-func getImageInfo() (interactor.ImageInfo, error) {
-	imageName := os.Getenv("IMAGE_TO_PULL")
-	imageRegistryUsername := os.Getenv("IMAGE_REGISTRY_USERNAME")
-	imageRegisrtyPassword := os.Getenv("IMAGE_REGISTRY_PASSWORD")
+func getImageInfo(tenant string) (interactor.ImageInfo, error) {
+	imageName := config.GetTenantEnv(tenant, "IMAGE_TO_PULL")
+	imageRegistryUsername := config.GetTenantEnv(tenant, "IMAGE_REGISTRY_USERNAME")
+	imageRegisrtyPassword := config.GetTenantEnv(tenant, "IMAGE_REGISTRY_PASSWORD")
 
-	portString := os.Getenv("IMAGE_EXPOSE_PORT")
+	portString := config.GetTenantEnv(tenant, "IMAGE_EXPOSE_PORT")
 	port, err := nat.NewPort(nat.SplitProtoPort(portString))
 	if err != nil {
 		return interactor.ImageInfo{}, err
 	}
 	imageExposedPort := port
 
-	portString = os.Getenv("IMAGE_CONTROL_PORT")
+	portString = config.GetTenantEnv(tenant, "IMAGE_CONTROL_PORT")
 	port, err = nat.NewPort(nat.SplitProtoPort(portString))
 	if err != nil {
 		return interactor.ImageInfo{}, err
 	}
 	imageControlPort := port
 
+	reservationAPI := config.GetTenantEnv(tenant, "IMAGE_RESERVATION_API")
+	if reservationAPI != "" && reservationAPI != common.RESERVATION_API_V1 && reservationAPI != common.RESERVATION_API_V2 {
+		return interactor.ImageInfo{}, errors.New("unknown Reservation API version " + reservationAPI)
+	}
+
 	return interactor.ImageInfo{
 		ImageRegistryUsername: imageRegistryUsername,
 		ImageRegisrtyPassword: imageRegisrtyPassword,
 		ImageName:             imageName,
 		ImageExposedPort:      imageExposedPort,
 		ImageControlPort:      imageControlPort,
+		ControlSecret:         config.GetTenantEnv(tenant, "IMAGE_CONTROL_SECRET"),
+		ReservationAPI:        reservationAPI,
 	}, nil
 }
 
-func initProcessor(image interactor.ImageInfo, dataProvider data.DataProvider, containerInteractor interactor.ContainerInteractor) (*processor.Processor, error) {
-	maxJobs, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_JOBS"))
+func initProcessor(tenant string, image interactor.ImageInfo, dataProvider data.DataProvider, containerInteractor interactor.ContainerInteractor) (*processor.Processor, error) {
+	maxJobs, err := strconv.Atoi(config.GetTenantEnv(tenant, "MAX_CONCURRENT_JOBS"))
 	if err != nil {
 		return nil, err
 	}
 
-	numberString := os.Getenv("RESERVATION_TIMEOUT")
+	numberString := config.GetTenantEnv(tenant, "RESERVATION_TIMEOUT")
 	reservationTimeout, err := strconv.Atoi(numberString)
 	if err != nil {
 		return nil, err
 	}
-	httpClient := &http.Client{Timeout: time.Duration(reservationTimeout) * time.Millisecond}
+	var httpClient web.HTTPClient = &http.Client{Timeout: time.Duration(reservationTimeout) * time.Millisecond}
+	//servers of image get the same secret at creation
+	if image.ControlSecret != "" {
+		httpClient = &web.SigningClient{Client: httpClient, Secret: image.ControlSecret}
+	}
 
-	numberString = os.Getenv("RESERVATION_COOLDOWN")
+	numberString = config.GetTenantEnv(tenant, "RESERVATION_COOLDOWN")
 	reservationCooldown, err := strconv.Atoi(numberString)
 	if err != nil {
 		return nil, err
 	}
 
-	numberString = os.Getenv("RESERVATION_RETRY_TIMES")
+	numberString = config.GetTenantEnv(tenant, "RESERVATION_RETRY_TIMES")
 	reservationRetries, err := strconv.Atoi(numberString)
 	if err != nil {
 		return nil, err
 	}
 
-	cooldownString := os.Getenv("LOOKUP_COOLDOWN")
+	cooldownString := config.GetTenantEnv(tenant, "LOOKUP_COOLDOWN")
 	lookupCooldown, err := strconv.Atoi(cooldownString)
 	if err != nil {
 		return nil, err
 	}
 
+	//retries are disabled by default
+	retryMaxAttempts, err := getOptionalInt(tenant, "RETRY_MAX_ATTEMPTS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	retryBackoff, err := getOptionalInt(tenant, "RETRY_BACKOFF", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	retryBackoffMax, err := getOptionalInt(tenant, "RETRY_BACKOFF_MAX", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownTimeout, err := config.GetShutdownTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	queueBreakerThreshold, err := getOptionalInt(tenant, "QUEUE_BREAKER_THRESHOLD", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	queueBackoff, err := getOptionalInt(tenant, "QUEUE_BACKOFF", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	queueBackoffMax, err := getOptionalInt(tenant, "QUEUE_BACKOFF_MAX", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//idle containers are not removed by default
+	idleTimeout, err := getOptionalInt(tenant, "IDLE_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reapInterval, err := getOptionalInt(tenant, "REAP_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//warm pool is disabled by default
+	warmPoolSize, err := getOptionalInt(tenant, "WARM_POOL_SIZE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	warmPoolBuffer, err := getOptionalInt(tenant, "WARM_POOL_BUFFER", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	warmPoolInterval, err := getOptionalInt(tenant, "WARM_POOL_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reservationParallelism, err := getOptionalInt(tenant, "RESERVATION_PARALLELISM", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCreations, err := getOptionalInt(tenant, "MAX_CONCURRENT_CREATIONS", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	containerSlots, err := getOptionalInt(tenant, "CONTAINER_SLOTS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	maxContainers, err := getOptionalInt(tenant, "MAX_CONTAINERS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//all tenants share the fleet, so limit is not overridden by tenant
+	fleetMaxContainers, err := getOptionalInt("", "FLEET_MAX_CONTAINERS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	placement, err := processor.CreatePlacementStrategy(config.GetTenantEnv(tenant, "PLACEMENT_STRATEGY"))
+	if err != nil {
+		return nil, err
+	}
+
+	capacityCacheTTL, err := getOptionalInt(tenant, "CAPACITY_CACHE_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	serverSyncInterval, err := getOptionalInt(tenant, "SERVER_SYNC_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sdkHeartbeatTimeout, err := getOptionalInt(tenant, "SDK_HEARTBEAT_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//join tokens are not issued if key is not set
+	var joinTokenKey ed25519.PrivateKey
+	joinTokenSeed := config.GetTenantEnv(tenant, "JOIN_TOKEN_KEY")
+	if joinTokenSeed != "" {
+		joinTokenKey, err = jointoken.ParsePrivateKey(joinTokenSeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	joinTokenTTL, err := getOptionalInt(tenant, "JOIN_TOKEN_TTL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	//zero values are replaced with breaker defaults
+	queueBreaker := processor.CreateCircuitBreaker(processor.CircuitBreakerOptions{
+		Threshold:  queueBreakerThreshold,
+		Backoff:    time.Duration(queueBackoff) * time.Millisecond,
+		BackoffMax: time.Duration(queueBackoffMax) * time.Millisecond,
+	})
+
+	//container hostname identifies Maker replica in request events
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
 	return &processor.Processor{
-		DataProvider:        dataProvider,
-		DockerClient:        containerInteractor,
-		HttpClient:          httpClient,
-		MaxJobs:             maxJobs,
-		ImageControlPort:    image.ImageControlPort.Port(),
-		LookupCooldown:      lookupCooldown,
-		ReservationCooldown: reservationCooldown,
-		ReservationRetries:  reservationRetries,
+		InstanceID:             hostname,
+		DataProvider:           dataProvider,
+		DockerClient:           containerInteractor,
+		HttpClient:             httpClient,
+		MaxJobs:                maxJobs,
+		ImageControlPort:       image.ImageControlPort.Port(),
+		LookupCooldown:         lookupCooldown,
+		ReservationCooldown:    reservationCooldown,
+		ReservationRetries:     reservationRetries,
+		ReservationParallelism: reservationParallelism,
+		RetryMaxAttempts:       retryMaxAttempts,
+		RetryBackoff:           retryBackoff,
+		RetryBackoffMax:        retryBackoffMax,
+		ShutdownTimeout:        shutdownTimeout,
+		QueueBreaker:           queueBreaker,
+		IdleTimeout:            time.Duration(idleTimeout) * time.Millisecond,
+		ReapInterval:           time.Duration(reapInterval) * time.Millisecond,
+		WarmPool:               processor.WarmPoolPolicy{Min: warmPoolSize, BufferPercent: warmPoolBuffer},
+		WarmPoolInterval:       time.Duration(warmPoolInterval) * time.Millisecond,
+		MaxCreations:           maxCreations,
+		ContainerSlots:         containerSlots,
+		MaxContainers:          maxContainers,
+		FleetMaxContainers:     fleetMaxContainers,
+		Placement:              placement,
+		CapacityCacheTTL:       time.Duration(capacityCacheTTL) * time.Millisecond,
+		ServerSyncInterval:     time.Duration(serverSyncInterval) * time.Millisecond,
+		SDKURL:                 strings.ReplaceAll(config.GetTenantEnv(tenant, "SDK_URL"), processor.SDK_URL_HOSTNAME, hostname),
+		SDKHeartbeatTimeout:    time.Duration(sdkHeartbeatTimeout) * time.Millisecond,
+		JoinTokenKey:           joinTokenKey,
+		JoinTokenTTL:           time.Duration(joinTokenTTL) * time.Millisecond,
+		ReservationAPI:         image.ReservationAPI,
 	}, nil
 }
 
-func initInteractor(image interactor.ImageInfo) (interactor.ContainerInteractor, error) {
-	interactorType := os.Getenv("CONTAINER_BACKEND")
+func getOptionalInt(tenant string, name string, defaultValue int) (int, error) {
+	value := config.GetTenantEnv(tenant, name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+func initInteractor(tenant string, image interactor.ImageInfo, nodeCreations *interactor.NodeCreations) (interactor.ContainerInteractor, error) {
+	//nodes are shared by tenants, so limit is not overridden by tenant
+	maxContainersPerNode, err := getOptionalInt("", "MAX_CONTAINERS_PER_NODE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	interactorType := config.GetTenantEnv(tenant, "CONTAINER_BACKEND")
 	switch interactorType {
 	case interactor.DOCKER_INTERACTOR:
 		log.Println("Starting on docker")
 
-		dockerNetwork := os.Getenv("DOCKER_NETWORK")
+		dockerNetwork := config.GetTenantEnv(tenant, "DOCKER_NETWORK")
 		options := interactor.DockerContainerInteractorOptions{
-			DockerNetwork: dockerNetwork,
+			DockerNetwork:        dockerNetwork,
+			MaxContainersPerNode: maxContainersPerNode,
+			NodeCreations:        nodeCreations,
+			Tenant:               tenant,
 		}
 
 		interactor, err := interactor.CreateDockerContainerInteractor(image, options)
@@ -140,13 +442,13 @@ func initInteractor(image interactor.ImageInfo) (interactor.ContainerInteractor,
 	case interactor.SWARM_INTERACTOR:
 		log.Println("Starting on swarm")
 
-		dockerNetwork := os.Getenv("DOCKER_NETWORK")
-		numberString := os.Getenv("CONVERGE_VERIFY_COOLDOWN")
+		dockerNetwork := config.GetTenantEnv(tenant, "DOCKER_NETWORK")
+		numberString := config.GetTenantEnv(tenant, "CONVERGE_VERIFY_COOLDOWN")
 		convergeVerifyCooldown, err := strconv.Atoi(numberString)
 		if err != nil {
 			return nil, err
 		}
-		numberString = os.Getenv("CONVERGE_VERIFY_RETRY_TIMES")
+		numberString = config.GetTenantEnv(tenant, "CONVERGE_VERIFY_RETRY_TIMES")
 		convergeVerifyRetries, err := strconv.Atoi(numberString)
 		if err != nil {
 			return nil, err
@@ -155,6 +457,8 @@ func initInteractor(image interactor.ImageInfo) (interactor.ContainerInteractor,
 			DockerNetwork:          dockerNetwork,
 			ConvergeVerifyCooldown: convergeVerifyCooldown,
 			ConvergeVerifyRetries:  convergeVerifyRetries,
+			MaxContainersPerNode:   maxContainersPerNode,
+			Tenant:                 tenant,
 		}
 
 		interactor, err := interactor.CreateSwarmContainerInteractor(image, options)
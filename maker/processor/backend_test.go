@@ -0,0 +1,261 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendContainerReservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        ContainerReservationArgs
+		maxAttempts int
+		want        *common.RequestBody
+		queued      bool
+		deadLetter  []string
+		events      []string
+	}{
+		{
+			name: "reserve running",
+			args: ContainerReservationArgs{
+				reserveType: RESERVE_RUNNING,
+			},
+			want:   &common.RequestBody{ID: "request1", Status: common.DONE, Container: "container", ServerPort: "34999"},
+			events: []string{common.EVENT_POPPED, common.EVENT_RESERVATION, common.EVENT_DONE},
+		},
+		{
+			name: "reserve new",
+			args: ContainerReservationArgs{
+				reserveType: RESERVE_NEW,
+			},
+			want:   &common.RequestBody{ID: "request1", Status: common.DONE, Container: "container", ServerPort: "34999"},
+			events: []string{common.EVENT_POPPED, common.EVENT_CONTAINER_CREATED, common.EVENT_RESERVATION, common.EVENT_DONE},
+		},
+		{
+			name: "error on docker list",
+			args: ContainerReservationArgs{
+				reserveType: RESERVE_RUNNING,
+				err:         errors.New("reserve error"),
+			},
+			want:       &common.RequestBody{ID: "request1", Status: common.FAILED, Attempts: 1, Reason: "reserve error"},
+			deadLetter: []string{"request1"},
+			events:     []string{common.EVENT_POPPED, common.EVENT_FAILED},
+		},
+		{
+			name: "error on docker list with retries",
+			args: ContainerReservationArgs{
+				reserveType: RESERVE_RUNNING,
+				err:         errors.New("reserve error"),
+			},
+			maxAttempts: 2,
+			want:        &common.RequestBody{ID: "request1", Status: common.CREATED, Attempts: 1, Reason: "reserve error"},
+			queued:      true,
+			deadLetter:  []string{},
+			events:      []string{common.EVENT_POPPED, common.EVENT_RETRY},
+		},
+		{
+			name: "error on docker list after retries",
+			args: ContainerReservationArgs{
+				reserveType: RESERVE_RUNNING,
+				err:         errors.New("reserve error"),
+			},
+			maxAttempts: 2,
+			want:        &common.RequestBody{ID: "request1", Status: common.FAILED, Attempts: 2, Reason: "reserve error"},
+			deadLetter:  []string{"request1"},
+			events:      []string{common.EVENT_POPPED, common.EVENT_FAILED},
+		},
+	}
+
+	for _, backend := range datatest.Backends() {
+		for _, test := range tests {
+			t.Run(backend.Name+" "+test.name, func(t *testing.T) {
+				requestID := "request1"
+
+				dataProvider := backend.Create(t)
+				dockerMock := interactor.MockInteractor{}
+				httpMock := web.HTTPClientMock{}
+
+				processor := Processor{
+					InstanceID:   "maker1",
+					DataProvider: dataProvider,
+					DockerClient: &dockerMock,
+					HttpClient:   &httpMock,
+
+					ImageControlPort: "3000",
+
+					RetryMaxAttempts: test.maxAttempts,
+					RetryBackoff:     10,
+				}
+
+				//retried request keeps attempts of previous failures
+				created := common.RequestBody{ID: requestID, Status: common.CREATED}
+				if test.want.Attempts > 1 {
+					created.Attempts = test.want.Attempts - 1
+				}
+				_, err := dataProvider.Set(created)
+				assert.NoError(t, err)
+
+				if test.args.reserveType == RESERVE_RUNNING {
+					dockerMock.On("ListContainers").Return([]string{"container1"}, test.args.err).Once()
+				} else {
+					dockerMock.On("ListContainers").Return([]string{}, test.args.err).Once()
+					dockerMock.On("CreateContainer", mock.Anything).Return("container1", nil).Once()
+				}
+
+				inspectResponse := interactor.ContainerInfo{Address: "container", ExposedPort: "34999"}
+				dockerMock.On("InspectContainer", mock.Anything).Return(inspectResponse, nil).Maybe()
+
+				mockNoCapacity(&httpMock)
+				httpResponse := http.Response{StatusCode: 200}
+				httpMock.On("Do", mock.Anything).Return(&httpResponse, nil).Maybe()
+
+				err = processor.processMessage(requestID)
+				assert.Equal(t, test.args.err, err)
+
+				assert.Equal(t, test.want, datatest.GetRequest(t, dataProvider, requestID))
+
+				//empty queue can't be verified without blocking
+				if test.queued {
+					assert.Equal(t, []string{requestID}, datatest.PopN(t, dataProvider, 1))
+				}
+
+				events, err := dataProvider.EventList(requestID)
+				assert.NoError(t, err)
+				eventTypes := []string{}
+				for _, event := range events {
+					eventTypes = append(eventTypes, event.Type)
+					assert.Equal(t, "maker1", event.Source)
+				}
+				assert.Equal(t, test.events, eventTypes)
+
+				if test.deadLetter != nil {
+					deadLetter, err := dataProvider.DeadLetterList()
+					assert.NoError(t, err)
+					assert.Equal(t, test.deadLetter, deadLetter)
+				}
+			})
+		}
+	}
+}
+
+func TestBackendShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		finished bool
+		want     string
+		queued   bool
+		events   []string
+	}{
+		{
+			name:     "job finished before timeout",
+			finished: true,
+			want:     common.DONE,
+			events:   []string{common.EVENT_POPPED, common.EVENT_RESERVATION, common.EVENT_DONE},
+		},
+		{
+			name:   "job requeued after timeout",
+			want:   common.CREATED,
+			queued: true,
+			events: []string{common.EVENT_POPPED, common.EVENT_REQUEUED},
+		},
+	}
+
+	for _, backend := range datatest.Backends() {
+		for _, test := range tests {
+			t.Run(backend.Name+" "+test.name, func(t *testing.T) {
+				requestID := "request1"
+
+				dataProvider := backend.Create(t)
+				dockerMock := interactor.MockInteractor{}
+				httpMock := web.HTTPClientMock{}
+
+				processor := Processor{
+					InstanceID:   "maker1",
+					DataProvider: dataProvider,
+					DockerClient: &dockerMock,
+					HttpClient:   &httpMock,
+
+					MaxJobs:          1,
+					ImageControlPort: "3000",
+					ShutdownTimeout:  500 * time.Millisecond,
+				}
+
+				_, err := dataProvider.Set(common.RequestBody{ID: requestID, Status: common.CREATED, Priority: 5})
+				assert.NoError(t, err)
+				assert.NoError(t, dataProvider.ListPush(requestID, 5, 0))
+
+				//job is blocked on container lookup until it's released
+				popped := make(chan struct{})
+				release := make(chan struct{})
+				dockerMock.On("ListContainers").Run(func(args mock.Arguments) {
+					close(popped)
+					<-release
+				}).Return([]string{"container1"}, nil).Once()
+
+				inspectResponse := interactor.ContainerInfo{Address: "container", ExposedPort: "34999"}
+				dockerMock.On("InspectContainer", mock.Anything).Return(inspectResponse, nil).Maybe()
+
+				mockNoCapacity(&httpMock)
+				httpResponse := http.Response{StatusCode: 200}
+				httpMock.On("Do", mock.Anything).Return(&httpResponse, nil).Maybe()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				stopped := make(chan error, 1)
+				go func() {
+					stopped <- processor.Process(ctx)
+				}()
+
+				select {
+				case <-popped:
+				case <-time.After(datatest.POP_TIMEOUT):
+					require.FailNow(t, "request was not popped")
+				}
+
+				cancel()
+				if test.finished {
+					close(release)
+				} else {
+					//abandoned job is released only after the test
+					t.Cleanup(func() { close(release) })
+				}
+
+				select {
+				case err := <-stopped:
+					assert.NoError(t, err)
+				case <-time.After(datatest.POP_TIMEOUT):
+					require.FailNow(t, "processor was not stopped")
+				}
+
+				request := datatest.GetRequest(t, dataProvider, requestID)
+				if assert.NotNil(t, request) {
+					assert.Equal(t, test.want, request.Status)
+					assert.Equal(t, 5, request.Priority)
+				}
+
+				//empty queue can't be verified without blocking
+				if test.queued {
+					assert.Equal(t, []string{requestID}, datatest.PopN(t, dataProvider, 1))
+				}
+
+				events, err := dataProvider.EventList(requestID)
+				assert.NoError(t, err)
+				eventTypes := []string{}
+				for _, event := range events {
+					eventTypes = append(eventTypes, event.Type)
+				}
+				assert.Equal(t, test.events, eventTypes)
+			})
+		}
+	}
+}
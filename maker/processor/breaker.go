@@ -0,0 +1,158 @@
+package processor
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	//calls are allowed, failed calls are delayed with backoff
+	BREAKER_CLOSED = "closed"
+	//calls are not allowed until backoff passes
+	BREAKER_OPEN = "open"
+	//single call is allowed to check if backend is back
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+// used if CircuitBreakerOptions fields are not set
+const (
+	DEFAULT_BREAKER_THRESHOLD   = 5
+	DEFAULT_BREAKER_BACKOFF     = 100 * time.Millisecond
+	DEFAULT_BREAKER_BACKOFF_MAX = 30 * time.Second
+)
+
+// CircuitBreaker delays calls to failing backend. Each failure delays next calls
+// with exponential backoff, after Threshold failures in a row breaker is opened and
+// lets only one call through after backoff passes
+type CircuitBreaker struct {
+	options CircuitBreakerOptions
+
+	mutex    sync.Mutex
+	failures int
+	retryAt  time.Time
+	probing  bool
+	//closed when state changes, so waiting calls can check it again
+	changed chan struct{}
+}
+
+// Wait blocks until call is allowed or ctx is done, allowed call should be
+// finished with Success or Failure
+func (breaker *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		breaker.mutex.Lock()
+		wait := time.Until(breaker.retryAt)
+		opened := breaker.failures >= breaker.options.Threshold
+		if wait <= 0 && !(opened && breaker.probing) {
+			breaker.probing = opened
+			breaker.mutex.Unlock()
+			return nil
+		}
+		changed := breaker.changed
+		breaker.mutex.Unlock()
+
+		//while probing call runs, other calls wait only for state change
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+
+		select {
+		case <-expired:
+		case <-changed:
+		case <-ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (breaker *CircuitBreaker) Success() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.failures == 0 {
+		return
+	}
+
+	breaker.failures = 0
+	breaker.retryAt = time.Time{}
+	breaker.probing = false
+	breaker.notify()
+}
+
+func (breaker *CircuitBreaker) Failure() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.failures++
+	breaker.retryAt = time.Now().Add(breaker.getBackoff())
+	breaker.probing = false
+	breaker.notify()
+}
+
+func (breaker *CircuitBreaker) State() string {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.failures < breaker.options.Threshold {
+		return BREAKER_CLOSED
+	}
+
+	if breaker.probing || !time.Now().Before(breaker.retryAt) {
+		return BREAKER_HALF_OPEN
+	}
+
+	return BREAKER_OPEN
+}
+
+// should be called with locked mutex
+func (breaker *CircuitBreaker) notify() {
+	close(breaker.changed)
+	breaker.changed = make(chan struct{})
+}
+
+// backoff is doubled after each failure, half of it is random so failed
+// replicas don't retry at the same time
+func (breaker *CircuitBreaker) getBackoff() time.Duration {
+	backoff := breaker.options.Backoff
+	for i := 1; i < breaker.failures && backoff < breaker.options.BackoffMax; i++ {
+		backoff *= 2
+	}
+
+	if backoff > breaker.options.BackoffMax {
+		backoff = breaker.options.BackoffMax
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+type CircuitBreakerOptions struct {
+	//failures in a row that open the breaker
+	Threshold int
+	//delay after first failure, doubled after each next one up to BackoffMax
+	Backoff    time.Duration
+	BackoffMax time.Duration
+}
+
+func CreateCircuitBreaker(options CircuitBreakerOptions) *CircuitBreaker {
+	if options.Threshold <= 0 {
+		options.Threshold = DEFAULT_BREAKER_THRESHOLD
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DEFAULT_BREAKER_BACKOFF
+	}
+	if options.BackoffMax <= 0 {
+		options.BackoffMax = DEFAULT_BREAKER_BACKOFF_MAX
+	}
+
+	return &CircuitBreaker{options: options, changed: make(chan struct{})}
+}
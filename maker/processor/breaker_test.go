@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerBackoff(t *testing.T) {
+	breaker := CreateCircuitBreaker(CircuitBreakerOptions{Backoff: 100 * time.Millisecond, BackoffMax: time.Second})
+
+	//half of backoff is random
+	for failures, want := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		breaker.failures = failures
+		for i := 0; i < 10; i++ {
+			backoff := breaker.getBackoff()
+			assert.GreaterOrEqual(t, backoff, want/2)
+			assert.LessOrEqual(t, backoff, want)
+		}
+	}
+}
+
+func TestBreakerStates(t *testing.T) {
+	ctx := context.Background()
+	breaker := CreateCircuitBreaker(CircuitBreakerOptions{Threshold: 2, Backoff: 100 * time.Millisecond, BackoffMax: 100 * time.Millisecond})
+
+	assert.NoError(t, breaker.Wait(ctx))
+	breaker.Failure()
+	assert.Equal(t, BREAKER_CLOSED, breaker.State())
+
+	//failed call delays next calls even if breaker is closed
+	started := time.Now()
+	assert.NoError(t, breaker.Wait(ctx))
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+
+	breaker.Failure()
+	assert.Equal(t, BREAKER_OPEN, breaker.State())
+
+	//only one call is allowed after backoff
+	assert.NoError(t, breaker.Wait(ctx))
+	assert.Equal(t, BREAKER_HALF_OPEN, breaker.State())
+
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, breaker.Wait(waitCtx), context.DeadlineExceeded)
+
+	//waiting calls are allowed once probing call succeeds
+	waited := make(chan error, 1)
+	go func() {
+		waited <- breaker.Wait(ctx)
+	}()
+	breaker.Success()
+	assert.NoError(t, <-waited)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State())
+}
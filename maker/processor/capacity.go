@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.CapacityCacheTTL is not set
+const DEFAULT_CAPACITY_CACHE_TTL = time.Second
+
+type cachedCapacity struct {
+	//nil if container doesn't report capacity
+	capacity  *ContainerCapacity
+	fetchedAt time.Time
+}
+
+// returns copy of cached capacity, capacity is fetched if cache is expired
+func (processor *Processor) getContainerCapacity(containerID string, info interactor.ContainerInfo) *ContainerCapacity {
+	ttl := processor.CapacityCacheTTL
+	if ttl <= 0 {
+		ttl = DEFAULT_CAPACITY_CACHE_TTL
+	}
+
+	processor.capacityMutex.Lock()
+	cached, ok := processor.capacityCache[containerID]
+	processor.capacityMutex.Unlock()
+
+	if !ok || time.Since(cached.fetchedAt) >= ttl {
+		capacity, err := processor.fetchContainerCapacity(containerID, info)
+		if err != nil {
+			log.Printf("Failed to get capacity of container %v: %v", containerID, err)
+		}
+
+		//failed fetches are cached too, so containers without capacity are not asked on each lookup
+		cached = cachedCapacity{capacity: capacity, fetchedAt: time.Now()}
+		processor.capacityMutex.Lock()
+		if processor.capacityCache == nil {
+			processor.capacityCache = map[string]cachedCapacity{}
+		}
+		processor.capacityCache[containerID] = cached
+		if capacity != nil && capacity.Total > 0 {
+			processor.reportedSlots = capacity.Total
+		}
+		processor.capacityMutex.Unlock()
+	}
+
+	if cached.capacity == nil {
+		return nil
+	}
+
+	capacity := *cached.capacity
+	return &capacity
+}
+
+// reservation result is applied to cached capacity, so next lookups don't need to fetch it
+func (processor *Processor) updateContainerCapacity(containerID string, reserved bool) {
+	processor.capacityMutex.Lock()
+	defer processor.capacityMutex.Unlock()
+
+	cached, ok := processor.capacityCache[containerID]
+	if !ok || cached.capacity == nil {
+		return
+	}
+
+	capacity := *cached.capacity
+	if reserved && capacity.Free > 0 {
+		capacity.Free--
+	} else if !reserved {
+		capacity.Free = 0
+	}
+	cached.capacity = &capacity
+	processor.capacityCache[containerID] = cached
+}
+
+// slots reported by server replace cached capacity, metadata of cached capacity is kept
+func (processor *Processor) setContainerCapacity(containerID string, slots common.SlotInfo) {
+	processor.capacityMutex.Lock()
+	defer processor.capacityMutex.Unlock()
+
+	capacity := ContainerCapacity{Total: slots.Total, Free: slots.Free}
+	cached, ok := processor.capacityCache[containerID]
+	if ok && cached.capacity != nil {
+		capacity.Metadata = cached.capacity.Metadata
+	} else {
+		cached.fetchedAt = time.Now()
+	}
+	cached.capacity = &capacity
+
+	if processor.capacityCache == nil {
+		processor.capacityCache = map[string]cachedCapacity{}
+	}
+	processor.capacityCache[containerID] = cached
+	if slots.Total > 0 {
+		processor.reportedSlots = slots.Total
+	}
+}
+
+// capacity of container is fetched again on next lookup
+func (processor *Processor) invalidateContainerCapacity(containerID string) {
+	processor.capacityMutex.Lock()
+	defer processor.capacityMutex.Unlock()
+
+	delete(processor.capacityCache, containerID)
+}
+
+// removes containers that are not listed anymore
+func (processor *Processor) pruneCapacityCache(containers []string) {
+	listed := map[string]bool{}
+	for _, containerID := range containers {
+		listed[containerID] = true
+	}
+
+	processor.capacityMutex.Lock()
+	defer processor.capacityMutex.Unlock()
+
+	for containerID := range processor.capacityCache {
+		if !listed[containerID] {
+			delete(processor.capacityCache, containerID)
+		}
+	}
+}
+
+// any response means that server is started, even if it doesn't report capacity
+func (processor *Processor) fetchContainerCapacity(containerID string, info interactor.ContainerInfo) (*ContainerCapacity, error) {
+	req, err := processor.createControlRequest("GET", info, "/capacity", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := processor.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	processor.serverResponded(containerID, info.Address)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected response code " + strconv.Itoa(resp.StatusCode))
+	}
+
+	result := ContainerCapacity{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	processor.serverReserved(containerID, info.Address, result.Total-result.Free)
+
+	return &result, nil
+}
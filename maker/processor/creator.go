@@ -0,0 +1,249 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.MaxCreations is not set
+const DEFAULT_MAX_CREATIONS = 1
+
+// jobs stop waiting for container that is being created after this time and look for containers again
+const CREATION_WAIT_TIMEOUT = SERVER_CREATING_TIMEOUT
+
+// pendingCreation is container that is being created, jobs that found no free
+// slots wait for it instead of creating own containers until its slots are claimed
+type pendingCreation struct {
+	//jobs that will ask created container for reservation, including its creator
+	claimed int
+	slots   int
+	//closed when container is created
+	done chan struct{}
+	//empty if container was not created
+	id   string
+	info interactor.ContainerInfo
+	err  error
+}
+
+// claimCreation returns pending creation with unclaimed slot, or starts new one if
+// less than MaxCreations containers are created, creator is true for started creation.
+// Nil is returned if job should look for running containers again, interactor.ErrFleetLimit
+// is returned if container limits are reached
+func (processor *Processor) claimCreation() (*pendingCreation, bool, error) {
+	processor.creationMutex.Lock()
+	creation := processor.claimPendingCreation()
+	full := processor.isCreationLimitReached()
+	processor.creationMutex.Unlock()
+
+	if creation != nil || full {
+		return creation, false, nil
+	}
+
+	counts, err := processor.getContainerCounts()
+	if err != nil {
+		return nil, false, err
+	}
+
+	processor.creationMutex.Lock()
+	defer processor.creationMutex.Unlock()
+
+	//other job could start creation while counts were fetched
+	creation = processor.claimPendingCreation()
+	if creation != nil {
+		return creation, false, nil
+	}
+
+	creation, err = processor.startCreation(1, counts)
+	return creation, creation != nil, err
+}
+
+// should be called with locked creationMutex, returns nil if all slots are claimed
+func (processor *Processor) claimPendingCreation() *pendingCreation {
+	for _, creation := range processor.creations {
+		if creation.claimed < creation.slots {
+			creation.claimed++
+			return creation
+		}
+	}
+
+	return nil
+}
+
+// should be called with locked creationMutex
+func (processor *Processor) isCreationLimitReached() bool {
+	limit := processor.MaxCreations
+	if limit <= 0 {
+		limit = DEFAULT_MAX_CREATIONS
+	}
+
+	return len(processor.creations) >= limit
+}
+
+// should be called with locked creationMutex, returns nil if creation limit is reached
+func (processor *Processor) startCreation(claimed int, counts containerCounts) (*pendingCreation, error) {
+	if processor.isCreationLimitReached() {
+		return nil, nil
+	}
+
+	err := processor.checkContainerLimits(counts)
+	if err != nil {
+		return nil, err
+	}
+
+	creation := &pendingCreation{claimed: claimed, slots: processor.getContainerSlots(), done: make(chan struct{})}
+	processor.creations = append(processor.creations, creation)
+	return creation, nil
+}
+
+// containerCounts are running containers of tenant and of all tenants. They are fetched
+// before creationMutex is locked, so slow container backend doesn't block creations
+type containerCounts struct {
+	tenant int
+	fleet  int
+	//finishedCreations when counts were fetched, containers created after it may be not listed
+	finished int
+}
+
+// only counts of configured limits are fetched
+func (processor *Processor) getContainerCounts() (containerCounts, error) {
+	processor.creationMutex.Lock()
+	counts := containerCounts{finished: processor.finishedCreations}
+	processor.creationMutex.Unlock()
+
+	if processor.MaxContainers > 0 {
+		containers, err := processor.DockerClient.ListContainers()
+		if err != nil {
+			return counts, err
+		}
+		counts.tenant = len(containers)
+	}
+
+	if processor.FleetMaxContainers > 0 {
+		usage, err := processor.DockerClient.GetFleetUsage()
+		if err != nil {
+			return counts, err
+		}
+		counts.fleet = usage.Total
+	}
+
+	return counts, nil
+}
+
+// should be called with locked creationMutex, pending creations and creations finished
+// after counts were fetched are counted as containers
+func (processor *Processor) checkContainerLimits(counts containerCounts) error {
+	pending := len(processor.creations) + processor.finishedCreations - counts.finished
+	if processor.MaxContainers > 0 && counts.tenant+pending >= processor.MaxContainers {
+		return interactor.ErrFleetLimit
+	}
+
+	if processor.FleetMaxContainers > 0 && counts.fleet+pending >= processor.FleetMaxContainers {
+		return interactor.ErrFleetLimit
+	}
+
+	return nil
+}
+
+// finishCreation wakes up jobs that claimed slots of created container,
+// new jobs find it with container lookup
+func (processor *Processor) finishCreation(creation *pendingCreation, info interactor.ContainerInfo, err error) {
+	processor.creationMutex.Lock()
+	for i, pending := range processor.creations {
+		if pending == creation {
+			processor.creations = append(processor.creations[:i], processor.creations[i+1:]...)
+			break
+		}
+	}
+	processor.finishedCreations++
+	processor.creationMutex.Unlock()
+
+	creation.info = info
+	creation.err = err
+	close(creation.done)
+}
+
+// runCreation creates container with create and finishes creation even if create panics,
+// so jobs that wait for it are woken up and creation slot is freed
+func (processor *Processor) runCreation(creation *pendingCreation, create func() (string, interactor.ContainerInfo, error)) (id string, info interactor.ContainerInfo, err error) {
+	defer func() {
+		perr := recover()
+		if perr != nil {
+			err = common.HandlePanic(perr)
+		}
+
+		//set before jobs are woken up by finishCreation
+		creation.id = id
+		processor.finishCreation(creation, info, err)
+	}()
+
+	return create()
+}
+
+// waitCreation waits for container claimed by job and asks it for reservation,
+// false is returned if container was not created or is already full. Warm pool
+// container can still be starting, so reservation is retried like for new container
+func (processor *Processor) waitCreation(ctx context.Context, creation *pendingCreation, requestID string) (reservedContainer, bool) {
+	timer := time.NewTimer(CREATION_WAIT_TIMEOUT)
+	defer timer.Stop()
+
+	select {
+	case <-creation.done:
+	case <-ctx.Done():
+		return reservedContainer{}, false
+	case <-timer.C:
+		log.Printf("Waited container is not created in %v", CREATION_WAIT_TIMEOUT)
+		return reservedContainer{}, false
+	}
+
+	if creation.err != nil {
+		log.Printf("Waited container creation failed: %v", creation.err)
+		return reservedContainer{}, false
+	}
+
+	reply, err := processor.reserveContainer(creation.info, requestID, true)
+	if err != nil {
+		log.Printf("Failed reserve request on created container %v: %v", creation.info.Address, err)
+		return reservedContainer{}, false
+	}
+
+	processor.applyReservationReply(creation.id, creation.info.Address, reply)
+	return reservedContainer{ContainerInfo: creation.info, reservedSlot: reply.reservedSlot}, reply.reserved
+}
+
+// createClaimedContainer creates container and reserves slot in it for job that started creation
+func (processor *Processor) createClaimedContainer(creation *pendingCreation, requestID string) (reservedContainer, error) {
+	result := reservedContainer{}
+	_, _, err := processor.runCreation(creation, func() (string, interactor.ContainerInfo, error) {
+		id, info, err := processor.createNewContainer(requestID)
+		if err == nil && info.ExposedPort == "" {
+			err = errors.New("StartNewContainer didn't return port")
+		}
+
+		result = info
+		return id, info.ContainerInfo, err
+	})
+
+	return result, err
+}
+
+// slots of new container are not known until it's started, so configured or
+// last reported capacity is used
+func (processor *Processor) getContainerSlots() int {
+	if processor.ContainerSlots > 0 {
+		return processor.ContainerSlots
+	}
+
+	processor.capacityMutex.Lock()
+	defer processor.capacityMutex.Unlock()
+
+	if processor.reportedSlots > 0 {
+		return processor.reportedSlots
+	}
+
+	return 1
+}
@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClaimCreation(t *testing.T) {
+	processor := Processor{MaxCreations: 2, ContainerSlots: 3}
+
+	//burst of 6 requests starts 2 containers, 3 slots each
+	creators := 0
+	claimed := map[*pendingCreation]int{}
+	for i := 0; i < 6; i++ {
+		creation, creator, err := processor.claimCreation()
+		assert.NoError(t, err)
+		assert.NotNil(t, creation)
+		if creator {
+			creators++
+		}
+		claimed[creation]++
+	}
+	assert.Equal(t, 2, creators)
+	for _, count := range claimed {
+		assert.Equal(t, 3, count)
+	}
+
+	//all slots are claimed and creation limit is reached
+	creation, creator, err := processor.claimCreation()
+	assert.NoError(t, err)
+	assert.Nil(t, creation)
+	assert.False(t, creator)
+
+	//finished creation frees creation slot
+	for pending := range claimed {
+		processor.finishCreation(pending, interactor.ContainerInfo{}, errors.New("failed"))
+		break
+	}
+	creation, creator, err = processor.claimCreation()
+	assert.NoError(t, err)
+	assert.NotNil(t, creation)
+	assert.True(t, creator)
+}
+
+func TestContainerLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  int
+		fleet   int
+		limited bool
+	}{
+		{name: "unlimited", limited: false},
+		{name: "tenant limit with pending creation", tenant: 3, limited: true},
+		{name: "tenant limit not reached", tenant: 4, limited: false},
+		{name: "fleet limit", tenant: 4, fleet: 5, limited: true},
+		{name: "fleet limit not reached", fleet: 6, limited: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dockerMock := interactor.MockInteractor{}
+			processor := Processor{DockerClient: &dockerMock, MaxCreations: 2, MaxContainers: test.tenant, FleetMaxContainers: test.fleet}
+
+			//tenant has 2 containers and 1 is created, other tenants have 2
+			dockerMock.On("ListContainers").Return([]string{"container1", "container2"}, nil).Maybe()
+			dockerMock.On("GetFleetUsage").Return(interactor.FleetUsage{Total: 4}, nil).Maybe()
+			processor.creations = []*pendingCreation{{claimed: 1, slots: 1}}
+
+			creation, creator, err := processor.claimCreation()
+			if test.limited {
+				assert.ErrorIs(t, err, interactor.ErrFleetLimit)
+				assert.Nil(t, creation)
+				assert.False(t, creator)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, creator)
+			}
+		})
+	}
+}
+
+func TestFinishedCreationLimits(t *testing.T) {
+	processor := Processor{MaxCreations: 2, MaxContainers: 3}
+
+	//container created after counts were fetched is counted, even if it's not listed
+	counts := containerCounts{tenant: 2, finished: processor.finishedCreations}
+	creation, err := processor.startCreation(1, containerCounts{})
+	assert.NoError(t, err)
+	processor.finishCreation(creation, interactor.ContainerInfo{}, nil)
+
+	_, err = processor.startCreation(1, counts)
+	assert.ErrorIs(t, err, interactor.ErrFleetLimit)
+}
+
+func TestContainerSlots(t *testing.T) {
+	processor := Processor{}
+	assert.Equal(t, 1, processor.getContainerSlots())
+
+	processor.reportedSlots = 4
+	assert.Equal(t, 4, processor.getContainerSlots())
+
+	processor.ContainerSlots = 2
+	assert.Equal(t, 2, processor.getContainerSlots())
+}
+
+func TestWaitPoolCreation(t *testing.T) {
+	httpMock := web.HTTPClientMock{}
+	processor := Processor{
+		DataProvider:        data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{}),
+		HttpClient:          &httpMock,
+		ImageControlPort:    "3000",
+		ReservationRetries:  3,
+		ReservationCooldown: 1,
+	}
+
+	//warm pool creation is finished before server listens on control port
+	creation, err := processor.startCreation(0, containerCounts{})
+	assert.NoError(t, err)
+	creation.id = "pool"
+	processor.finishCreation(creation, interactor.ContainerInfo{Address: "pool", ExposedPort: "1234"}, nil)
+
+	reserveRequest := mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "POST" && req.URL.String() == "http://pool:3000/reservation/client1"
+	})
+	httpMock.On("Do", reserveRequest).Return(&http.Response{}, syscall.ECONNREFUSED).Once()
+	httpMock.On("Do", reserveRequest).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil).Once()
+
+	info, reserved := processor.waitCreation(context.Background(), creation, "client1")
+	assert.True(t, reserved)
+	assert.Equal(t, "pool", info.Address)
+	httpMock.AssertExpectations(t)
+}
+
+func TestCreationPanic(t *testing.T) {
+	dockerMock := interactor.MockInteractor{}
+	processor := Processor{DataProvider: data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{}), DockerClient: &dockerMock, ContainerSlots: 2}
+
+	dockerMock.On("CreateContainer", mock.Anything).Run(func(args mock.Arguments) {
+		panic("docker client panic")
+	}).Once()
+
+	creation, creator, err := processor.claimCreation()
+	assert.NoError(t, err)
+	assert.True(t, creator)
+	waiting, creator, err := processor.claimCreation()
+	assert.NoError(t, err)
+	assert.False(t, creator)
+
+	//waiting job is woken up and creation slot is freed
+	_, err = processor.createClaimedContainer(creation, "client1")
+	assert.EqualError(t, err, "docker client panic")
+	_, reserved := processor.waitCreation(context.Background(), waiting, "client2")
+	assert.False(t, reserved)
+	assert.Empty(t, processor.creations)
+
+	//job stops waiting for creation when its context is done
+	creation, err = processor.startCreation(1, containerCounts{})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, reserved = processor.waitCreation(ctx, creation, "client3")
+	assert.False(t, reserved)
+}
@@ -0,0 +1,348 @@
+package processor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.ServerSyncInterval is not set
+const DEFAULT_SERVER_SYNC_INTERVAL = 5 * time.Second
+
+// events are watched again after this time if events stream failed
+const SERVER_WATCH_COOLDOWN = 5 * time.Second
+
+// image pull can take long on Swarm, so creating servers are not dropped by sync before this time
+const SERVER_CREATING_TIMEOUT = 10 * time.Minute
+
+// servers that don't answer on control port are counted by warm pool as starting up to this time
+const SERVER_STARTING_TIMEOUT = 5 * time.Minute
+
+// WatchServers tracks lifecycle states of containers until ctx is done. States are
+// changed by container events and by Reservation API responses, every ServerSyncInterval
+// they are synced with container list and published to data backend for admin view
+func (processor *Processor) WatchServers(ctx context.Context) {
+	interval := processor.ServerSyncInterval
+	if interval <= 0 {
+		interval = DEFAULT_SERVER_SYNC_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := processor.watchServers(ctx, ticker, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Container events watch failed: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(SERVER_WATCH_COOLDOWN):
+		}
+	}
+}
+
+func (processor *Processor) watchServers(ctx context.Context, ticker *time.Ticker, interval time.Duration) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, errs := processor.DockerClient.WatchEvents(watchCtx)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case event := <-events:
+			processor.handleContainerEvent(event)
+		case now := <-ticker.C:
+			err := processor.syncServers(now, interval)
+			if err != nil {
+				log.Printf("Failed to sync server states: %v", err)
+			}
+		}
+	}
+}
+
+func (processor *Processor) handleContainerEvent(event interactor.ContainerEvent) {
+	switch event.Type {
+	case interactor.CONTAINER_CREATED:
+		processor.advanceServer(event.ID, "", common.SERVER_CREATING)
+	case interactor.CONTAINER_STARTED:
+		//restarted server starts again after shutdown
+		if processor.getServerState(event.ID) == common.SERVER_SHUTDOWN {
+			processor.setServerState(event.ID, common.SERVER_STARTING)
+		} else {
+			processor.advanceServer(event.ID, "", common.SERVER_STARTING)
+		}
+	//removed server is dropped on sync, so it's shown as Shutdown until then
+	case interactor.CONTAINER_STOPPED, interactor.CONTAINER_REMOVED:
+		processor.setServerState(event.ID, common.SERVER_SHUTDOWN)
+	}
+}
+
+// syncServers tracks listed containers that were started before Maker, shuts down
+// servers that are not listed anymore, drops servers that were shut down on previous
+// sync, and writes changed states to data backend
+func (processor *Processor) syncServers(now time.Time, interval time.Duration) error {
+	containers, err := processor.DockerClient.ListContainers()
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, containerID := range containers {
+		listed[containerID] = true
+		processor.advanceServer(containerID, "", common.SERVER_STARTING)
+	}
+
+	processor.serversMutex.Lock()
+	current := map[string]common.ServerStatus{}
+	for containerID, server := range processor.servers {
+		if !listed[containerID] {
+			switch {
+			case server.State == common.SERVER_CREATING && now.Sub(server.UpdatedAt) < SERVER_CREATING_TIMEOUT:
+			case server.State == common.SERVER_SHUTDOWN && now.Sub(server.UpdatedAt) >= interval:
+				delete(processor.servers, containerID)
+				processor.dropServerToken(containerID)
+				continue
+			case server.State != common.SERVER_SHUTDOWN:
+				server.State = common.SERVER_SHUTDOWN
+				server.UpdatedAt = now
+				processor.servers[containerID] = server
+			}
+		}
+
+		current[containerID] = server
+	}
+	processor.serversMutex.Unlock()
+
+	return processor.publishServers(current)
+}
+
+// only changed servers are written, servers that are not tracked are removed
+func (processor *Processor) publishServers(current map[string]common.ServerStatus) error {
+	published, err := processor.DataProvider.ServerList()
+	if err != nil {
+		return err
+	}
+
+	for _, server := range published {
+		updated, ok := current[server.ID]
+		if !ok {
+			err = processor.DataProvider.ServerRemove(server.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		//time is compared with Equal, since it loses monotonic clock in data backend
+		if updated.Address == server.Address && updated.State == server.State && updated.UpdatedAt.Equal(server.UpdatedAt) {
+			delete(current, server.ID)
+			continue
+		}
+
+		//state published later by other replica is taken, e.g. draining server that is removed
+		if server.UpdatedAt.After(updated.UpdatedAt) {
+			processor.adoptServer(server)
+			delete(current, server.ID)
+		}
+	}
+
+	for _, server := range current {
+		err = processor.DataProvider.ServerSet(server)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (processor *Processor) getServerState(containerID string) string {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	return processor.servers[containerID].State
+}
+
+// server is starting if it didn't answer on control port yet, untracked servers are
+// tracked as starting from now
+func (processor *Processor) isServerStarting(containerID string, now time.Time) bool {
+	processor.advanceServer(containerID, "", common.SERVER_STARTING)
+
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	server := processor.servers[containerID]
+	return server.State == common.SERVER_STARTING && now.Sub(server.UpdatedAt) < SERVER_STARTING_TIMEOUT
+}
+
+// only Ready servers and Allocated ones with free slots are asked for reservation,
+// servers with late SDK heartbeat are skipped
+func (processor *Processor) isServerAvailable(containerID string) bool {
+	state := processor.getServerState(containerID)
+	if state != common.SERVER_READY && state != common.SERVER_ALLOCATED {
+		return false
+	}
+
+	return !processor.isHeartbeatLate(containerID)
+}
+
+// publishServerState changes state of server like setServerState and writes it to data
+// backend right away, so other replicas see it before next sync
+func (processor *Processor) publishServerState(containerID string, state string) {
+	processor.serversMutex.Lock()
+	processor.updateServer(containerID, "", state)
+	//server drained by reaper is not brought back by SDK reports
+	if state == common.SERVER_DRAINING {
+		delete(processor.drainingReplies, containerID)
+	}
+	server := processor.servers[containerID]
+	processor.serversMutex.Unlock()
+
+	err := processor.DataProvider.ServerSet(server)
+	if err != nil {
+		log.Printf("Failed to publish state of server %v: %v", containerID, err)
+	}
+}
+
+// servers drained by any replica are not asked for reservation
+func (processor *Processor) getDrainingServers() map[string]bool {
+	result := map[string]bool{}
+	servers, err := processor.DataProvider.ServerList()
+	if err != nil {
+		log.Printf("Failed to get published servers: %v", err)
+		return result
+	}
+
+	for _, server := range servers {
+		if server.State == common.SERVER_DRAINING {
+			result[server.ID] = true
+		}
+	}
+
+	return result
+}
+
+// serverRepliedDraining marks server Draining until it sends SDK ready or heartbeat
+// report, server may stop draining e.g. after match ends
+func (processor *Processor) serverRepliedDraining(containerID string) {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	processor.updateServer(containerID, "", common.SERVER_DRAINING)
+	if processor.drainingReplies == nil {
+		processor.drainingReplies = map[string]bool{}
+	}
+	processor.drainingReplies[containerID] = true
+}
+
+// server that replied draining is asked for reservation again, it's marked Draining
+// again if it's still draining
+func (processor *Processor) recoverDrainingServer(containerID string) {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	if !processor.drainingReplies[containerID] {
+		return
+	}
+	delete(processor.drainingReplies, containerID)
+
+	if processor.servers[containerID].State == common.SERVER_DRAINING {
+		processor.updateServer(containerID, "", common.SERVER_READY)
+	}
+}
+
+// setServerState changes state of server regardless of current one
+func (processor *Processor) setServerState(containerID string, state string) {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	processor.updateServer(containerID, "", state)
+}
+
+// advanceServer moves server forward from Creating to Allocated, servers that are
+// draining or shut down are changed only by setServerState. Ready and Allocated
+// servers can change to each other, empty address keeps the known one
+func (processor *Processor) advanceServer(containerID string, address string, state string) {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	current := processor.servers[containerID].State
+	switch current {
+	case common.SERVER_DRAINING, common.SERVER_SHUTDOWN:
+		return
+	case common.SERVER_READY, common.SERVER_ALLOCATED:
+		if state != common.SERVER_READY && state != common.SERVER_ALLOCATED {
+			state = current
+		}
+	case common.SERVER_STARTING:
+		if state == common.SERVER_CREATING {
+			state = current
+		}
+	}
+
+	processor.updateServer(containerID, address, state)
+}
+
+// server is changed only if it's still older than the taken one
+func (processor *Processor) adoptServer(server common.ServerStatus) {
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	current, ok := processor.servers[server.ID]
+	if ok && server.UpdatedAt.After(current.UpdatedAt) {
+		processor.servers[server.ID] = server
+	}
+}
+
+// should be called with locked serversMutex
+func (processor *Processor) updateServer(containerID string, address string, state string) {
+	if processor.servers == nil {
+		processor.servers = map[string]common.ServerStatus{}
+	}
+
+	server, ok := processor.servers[containerID]
+	if !ok {
+		server.ID = containerID
+	}
+
+	if address != "" {
+		server.Address = address
+	}
+
+	if server.State != state {
+		server.State = state
+		server.UpdatedAt = time.Now()
+	}
+
+	processor.servers[containerID] = server
+}
+
+// server that answered on control port is started, its reservations are not known
+// if it doesn't report capacity
+func (processor *Processor) serverResponded(containerID string, address string) {
+	state := processor.getServerState(containerID)
+	if state == "" || state == common.SERVER_CREATING || state == common.SERVER_STARTING {
+		state = common.SERVER_READY
+	}
+
+	processor.advanceServer(containerID, address, state)
+}
+
+// reserved is number of slots taken on server
+func (processor *Processor) serverReserved(containerID string, address string, reserved int) {
+	state := common.SERVER_READY
+	if reserved > 0 {
+		state = common.SERVER_ALLOCATED
+	}
+
+	processor.advanceServer(containerID, address, state)
+}
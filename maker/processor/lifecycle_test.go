@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServerTransitions(t *testing.T) {
+	processor := Processor{}
+
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "container1", Type: interactor.CONTAINER_CREATED})
+	assert.Equal(t, common.SERVER_CREATING, processor.getServerState("container1"))
+
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "container1", Type: interactor.CONTAINER_STARTED})
+	assert.Equal(t, common.SERVER_STARTING, processor.getServerState("container1"))
+	assert.False(t, processor.isServerAvailable("container1"))
+
+	processor.serverResponded("container1", "container1")
+	assert.Equal(t, common.SERVER_READY, processor.getServerState("container1"))
+
+	processor.serverReserved("container1", "", 1)
+	assert.Equal(t, common.SERVER_ALLOCATED, processor.getServerState("container1"))
+	assert.True(t, processor.isServerAvailable("container1"))
+
+	//late start event doesn't move server back
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "container1", Type: interactor.CONTAINER_STARTED})
+	assert.Equal(t, common.SERVER_ALLOCATED, processor.getServerState("container1"))
+
+	//draining server is not changed by reservation responses
+	processor.setServerState("container1", common.SERVER_DRAINING)
+	processor.serverReserved("container1", "", 0)
+	assert.Equal(t, common.SERVER_DRAINING, processor.getServerState("container1"))
+	assert.False(t, processor.isServerAvailable("container1"))
+
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "container1", Type: interactor.CONTAINER_STOPPED})
+	assert.Equal(t, common.SERVER_SHUTDOWN, processor.getServerState("container1"))
+	assert.Equal(t, "container1", processor.servers["container1"].Address)
+}
+
+func TestLookupSkipsUnavailableServers(t *testing.T) {
+	dockerMock := interactor.MockInteractor{}
+	httpMock := web.HTTPClientMock{}
+	dataProvider := data.MockDataProvider{}
+	processor := Processor{DockerClient: &dockerMock, HttpClient: &httpMock, DataProvider: &dataProvider, ImageControlPort: "3000"}
+
+	dockerMock.On("ListContainers").Return([]string{"draining", "starting", "ready"}, nil)
+	for _, containerID := range []string{"draining", "starting", "ready"} {
+		dockerMock.On("InspectContainer", containerID).Return(interactor.ContainerInfo{Address: containerID, ExposedPort: "1234"}, nil)
+	}
+	processor.setServerState("draining", common.SERVER_DRAINING)
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+	dataProvider.On("ServerList").Return(nil, nil).Maybe()
+
+	//starting server doesn't answer yet, ready server doesn't report capacity
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Host == "starting:3000"
+	})).Return(&http.Response{}, context.DeadlineExceeded)
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.String() == "http://ready:3000/capacity" || req.URL.String() == "http://draining:3000/capacity"
+	})).Return(&http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil)
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "POST" && req.URL.String() == "http://ready:3000/reservation/client1"
+	})).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil).Once()
+
+	info, err := processor.findRunningContainer(context.Background(), "client1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ready", info.Address)
+	assert.Equal(t, common.SERVER_ALLOCATED, processor.getServerState("ready"))
+	assert.Equal(t, common.SERVER_DRAINING, processor.getServerState("draining"))
+	assert.Equal(t, "", processor.getServerState("starting"))
+	httpMock.AssertExpectations(t)
+}
+
+func TestSyncServers(t *testing.T) {
+	dockerMock := interactor.MockInteractor{}
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	processor := Processor{DockerClient: &dockerMock, DataProvider: dataProvider}
+
+	//stale server of previous Maker run is removed
+	assert.NoError(t, dataProvider.ServerSet(common.ServerStatus{ID: "stale", State: common.SERVER_READY}))
+	processor.serverReserved("stopped", "stopped", 1)
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "creating", Type: interactor.CONTAINER_CREATED})
+	dockerMock.On("ListContainers").Return([]string{"old"}, nil)
+
+	now := time.Now()
+	assert.NoError(t, processor.syncServers(now, time.Second))
+	servers, err := dataProvider.ServerList()
+	assert.NoError(t, err)
+	states := map[string]string{}
+	for _, server := range servers {
+		states[server.ID] = server.State
+	}
+	assert.Equal(t, map[string]string{
+		"creating": common.SERVER_CREATING,
+		"old":      common.SERVER_STARTING,
+		"stopped":  common.SERVER_SHUTDOWN,
+	}, states)
+
+	//shut down server is dropped on next sync
+	assert.NoError(t, processor.syncServers(now.Add(time.Second), time.Second))
+	servers, err = dataProvider.ServerList()
+	assert.NoError(t, err)
+	assert.Len(t, servers, 2)
+}
+
+func TestSharedDrainingServer(t *testing.T) {
+	dockerMock := interactor.MockInteractor{}
+	httpMock := web.HTTPClientMock{}
+	dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+	reaper := Processor{DockerClient: &dockerMock, HttpClient: &httpMock, DataProvider: dataProvider, ImageControlPort: "3000"}
+	replica := Processor{DockerClient: &dockerMock, HttpClient: &httpMock, DataProvider: dataProvider, ImageControlPort: "3000"}
+
+	dockerMock.On("ListContainers").Return([]string{"container1"}, nil)
+	dockerMock.On("InspectContainer", "container1").Return(interactor.ContainerInfo{Address: "container1", ExposedPort: "1234"}, nil)
+	mockNoCapacity(&httpMock)
+	replica.serverReserved("container1", "container1", 0)
+	reaper.serverReserved("container1", "container1", 0)
+	time.Sleep(time.Millisecond)
+	reaper.publishServerState("container1", common.SERVER_DRAINING)
+
+	//server drained by other replica is not asked for reservation
+	info, err := replica.findRunningContainer(context.Background(), "client1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", info.Address)
+
+	//sync doesn't overwrite later state of other replica
+	assert.NoError(t, replica.syncServers(time.Now(), time.Second))
+	assert.Equal(t, common.SERVER_DRAINING, replica.getServerState("container1"))
+	servers, err := dataProvider.ServerList()
+	assert.NoError(t, err)
+	assert.Len(t, servers, 1)
+	assert.Equal(t, common.SERVER_DRAINING, servers[0].State)
+	httpMock.AssertExpectations(t)
+}
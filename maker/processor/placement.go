@@ -0,0 +1,101 @@
+package processor
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+const (
+	//fill fullest containers first, so idle ones can be removed
+	PLACEMENT_BIN_PACKING = "bin-packing"
+	//spread clients to containers with lowest load
+	PLACEMENT_LEAST_LOADED = "least-loaded"
+	PLACEMENT_RANDOM       = "random"
+)
+
+// ContainerCapacity is response of GET /capacity of Reservation API
+type ContainerCapacity struct {
+	Total int `json:"total"`
+	Free  int `json:"free"`
+	//server specific information, e.g. map or version
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type PlacementCandidate struct {
+	ID   string
+	Info interactor.ContainerInfo
+	//nil if container doesn't report capacity
+	Capacity *ContainerCapacity
+}
+
+// PlacementStrategy chooses order in which containers are asked for reservation,
+// containers without free slots are not passed to it
+type PlacementStrategy interface {
+	Order(candidates []PlacementCandidate) []PlacementCandidate
+}
+
+// containers without capacity are tried last in list order, as if they had no strategy
+func splitByCapacity(candidates []PlacementCandidate) ([]PlacementCandidate, []PlacementCandidate) {
+	known := []PlacementCandidate{}
+	unknown := []PlacementCandidate{}
+	for _, candidate := range candidates {
+		if candidate.Capacity != nil {
+			known = append(known, candidate)
+		} else {
+			unknown = append(unknown, candidate)
+		}
+	}
+
+	return known, unknown
+}
+
+type BinPackingPlacement struct{}
+
+func (placement *BinPackingPlacement) Order(candidates []PlacementCandidate) []PlacementCandidate {
+	known, unknown := splitByCapacity(candidates)
+	sort.SliceStable(known, func(i, j int) bool {
+		return known[i].Capacity.Free < known[j].Capacity.Free
+	})
+
+	return append(known, unknown...)
+}
+
+type LeastLoadedPlacement struct{}
+
+func (placement *LeastLoadedPlacement) Order(candidates []PlacementCandidate) []PlacementCandidate {
+	known, unknown := splitByCapacity(candidates)
+	//compares free/total ratios without division
+	sort.SliceStable(known, func(i, j int) bool {
+		return known[i].Capacity.Free*known[j].Capacity.Total > known[j].Capacity.Free*known[i].Capacity.Total
+	})
+
+	return append(known, unknown...)
+}
+
+type RandomPlacement struct{}
+
+func (placement *RandomPlacement) Order(candidates []PlacementCandidate) []PlacementCandidate {
+	result := append([]PlacementCandidate{}, candidates...)
+	rand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+
+	return result
+}
+
+// CreatePlacementStrategy returns strategy by name, bin-packing is used if name is empty
+func CreatePlacementStrategy(name string) (PlacementStrategy, error) {
+	switch name {
+	case "", PLACEMENT_BIN_PACKING:
+		return &BinPackingPlacement{}, nil
+	case PLACEMENT_LEAST_LOADED:
+		return &LeastLoadedPlacement{}, nil
+	case PLACEMENT_RANDOM:
+		return &RandomPlacement{}, nil
+	default:
+		return nil, errors.New("unknown placement strategy " + name)
+	}
+}
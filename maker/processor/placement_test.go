@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createCandidates(capacities map[string]*ContainerCapacity, order []string) []PlacementCandidate {
+	result := []PlacementCandidate{}
+	for _, ID := range order {
+		result = append(result, PlacementCandidate{ID: ID, Capacity: capacities[ID]})
+	}
+
+	return result
+}
+
+func getCandidateIDs(candidates []PlacementCandidate) []string {
+	result := []string{}
+	for _, candidate := range candidates {
+		result = append(result, candidate.ID)
+	}
+
+	return result
+}
+
+func TestPlacementOrder(t *testing.T) {
+	capacities := map[string]*ContainerCapacity{
+		"half":    {Total: 4, Free: 2},
+		"unknown": nil,
+		"empty":   {Total: 10, Free: 10},
+		"last":    {Total: 4, Free: 1},
+	}
+	order := []string{"half", "unknown", "empty", "last"}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: PLACEMENT_BIN_PACKING, want: []string{"last", "half", "empty", "unknown"}},
+		{name: PLACEMENT_LEAST_LOADED, want: []string{"empty", "half", "last", "unknown"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			placement, err := CreatePlacementStrategy(test.name)
+			assert.NoError(t, err)
+
+			result := placement.Order(createCandidates(capacities, order))
+			assert.Equal(t, test.want, getCandidateIDs(result))
+		})
+	}
+
+	t.Run(PLACEMENT_RANDOM, func(t *testing.T) {
+		placement, err := CreatePlacementStrategy(PLACEMENT_RANDOM)
+		assert.NoError(t, err)
+
+		result := placement.Order(createCandidates(capacities, order))
+		assert.ElementsMatch(t, order, getCandidateIDs(result))
+	})
+
+	_, err := CreatePlacementStrategy("unknown")
+	assert.Error(t, err)
+}
+
+func TestCapacityPlacement(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	dockerMock := interactor.MockInteractor{}
+	httpMock := web.HTTPClientMock{}
+
+	processor := Processor{
+		DataProvider:     &dataProvider,
+		DockerClient:     &dockerMock,
+		HttpClient:       &httpMock,
+		ImageControlPort: "3000",
+		Placement:        &LeastLoadedPlacement{},
+	}
+
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	dataProvider.On("ServerList").Return(nil, nil).Maybe()
+
+	capacities := map[string]string{
+		"container1": `{"total": 4, "free": 0}`,
+		"container2": `{"total": 4, "free": 1}`,
+		"container3": `{"total": 4, "free": 3, "metadata": {"map": "dust"}}`,
+	}
+	dockerMock.On("ListContainers").Return([]string{"container1", "container2", "container3"}, nil)
+	for containerID, capacity := range capacities {
+		dockerMock.On("InspectContainer", containerID).Return(interactor.ContainerInfo{Address: containerID, ExposedPort: "34999"}, nil)
+
+		capacityURL := "http://" + containerID + ":3000/capacity"
+		capacityRequest := mock.MatchedBy(func(req *http.Request) bool {
+			return req.URL.String() == capacityURL
+		})
+		httpResponse := http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(capacity))}
+		httpMock.On("Do", capacityRequest).Return(&httpResponse, nil).Once()
+	}
+
+	//only least loaded container is asked for reservation
+	reservationRequest := mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "POST" && req.URL.String() == "http://container3:3000/reservation/request1"
+	})
+	httpMock.On("Do", reservationRequest).Return(&http.Response{StatusCode: http.StatusOK}, nil).Twice()
+
+	info, err := processor.findRunningContainer(nil, "request1")
+	assert.NoError(t, err)
+	assert.Equal(t, "container3", info.Address)
+
+	//cached capacity is used and updated after reservation
+	info, err = processor.findRunningContainer(nil, "request1")
+	assert.NoError(t, err)
+	assert.Equal(t, "container3", info.Address)
+	assert.Equal(t, 1, processor.capacityCache["container3"].capacity.Free)
+
+	httpMock.AssertExpectations(t)
+}
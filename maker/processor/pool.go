@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.WarmPoolInterval is not set
+const DEFAULT_WARM_POOL_INTERVAL = 10 * time.Second
+
+// WarmPoolPolicy sets number of idle containers that are kept running, so
+// requests don't wait for container creation
+type WarmPoolPolicy struct {
+	//idle containers kept regardless of load
+	Min int
+	//idle containers kept as percent of containers with reservations, rounded up
+	BufferPercent int
+}
+
+func (policy WarmPoolPolicy) Enabled() bool {
+	return policy.Min > 0 || policy.BufferPercent > 0
+}
+
+// Target returns number of idle containers to keep when busy containers have reservations
+func (policy WarmPoolPolicy) Target(busy int) int {
+	buffer := (busy*policy.BufferPercent + 99) / 100
+	if buffer > policy.Min {
+		return buffer
+	}
+
+	return policy.Min
+}
+
+// KeepWarmPool creates containers when idle ones are used by reservations until
+// ctx is done, it returns right away if WarmPool is not enabled
+func (processor *Processor) KeepWarmPool(ctx context.Context) {
+	if !processor.WarmPool.Enabled() {
+		return
+	}
+
+	interval := processor.WarmPoolInterval
+	if interval <= 0 {
+		interval = DEFAULT_WARM_POOL_INTERVAL
+	}
+
+	log.Printf("Starting warm pool with %v idle containers and %v%% buffer", processor.WarmPool.Min, processor.WarmPool.BufferPercent)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := processor.replenishWarmPool(ctx)
+		if err != nil {
+			log.Printf("Failed to replenish warm pool: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// containers that don't report reservations while they start are counted as idle, so
+// starting containers are not created again. Containers that don't report reservations
+// after start are replaced
+func (processor *Processor) replenishWarmPool(ctx context.Context) error {
+	usage, err := processor.getContainerUsage()
+	if err != nil {
+		return err
+	}
+
+	missing := processor.WarmPool.Target(usage.busy) - len(usage.idle) - usage.starting
+	for i := 0; i < missing && ctx.Err() == nil; i++ {
+		created, err := processor.createPoolContainer()
+		if err != nil {
+			return err
+		}
+
+		//rest of the pool is created on next check
+		if !created {
+			break
+		}
+	}
+
+	return nil
+}
+
+// pool container takes creation slot, so jobs can wait for it instead of creating own containers,
+// false is returned if all creation slots are used by jobs or container limits are reached
+func (processor *Processor) createPoolContainer() (bool, error) {
+	counts, err := processor.getContainerCounts()
+	if err != nil {
+		return false, err
+	}
+
+	processor.creationMutex.Lock()
+	creation, err := processor.startCreation(0, counts)
+	processor.creationMutex.Unlock()
+
+	if creation == nil || errors.Is(err, interactor.ErrFleetLimit) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	id, _, err := processor.runCreation(creation, processor.startPoolContainer)
+	//pool doesn't grow over container limits
+	if errors.Is(err, interactor.ErrFleetLimit) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	log.Printf("Created warm pool container %v", id)
+	return true, nil
+}
+
+// empty ID is returned if container was not created
+func (processor *Processor) startPoolContainer() (string, interactor.ContainerInfo, error) {
+	env, token, err := processor.createServerEnv()
+	if err != nil {
+		return "", interactor.ContainerInfo{}, err
+	}
+
+	id, err := processor.DockerClient.CreateContainer(env)
+	processor.registerServerToken(token, id)
+	if err != nil {
+		return "", interactor.ContainerInfo{}, err
+	}
+
+	info, err := processor.DockerClient.InspectContainer(id)
+	if err == nil {
+		processor.advanceServer(id, info.Address, common.SERVER_STARTING)
+	}
+
+	return id, info, err
+}
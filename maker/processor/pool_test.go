@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWarmPoolTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy WarmPoolPolicy
+		busy   int
+		want   int
+	}{
+		{name: "disabled", policy: WarmPoolPolicy{}, busy: 10, want: 0},
+		{name: "fixed minimum", policy: WarmPoolPolicy{Min: 2}, busy: 10, want: 2},
+		{name: "buffer rounded up", policy: WarmPoolPolicy{BufferPercent: 25}, busy: 10, want: 3},
+		{name: "minimum without load", policy: WarmPoolPolicy{Min: 1, BufferPercent: 50}, busy: 0, want: 1},
+		{name: "buffer over minimum", policy: WarmPoolPolicy{Min: 1, BufferPercent: 50}, busy: 10, want: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.policy.Target(test.busy))
+		})
+	}
+}
+
+func TestReplenishWarmPool(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy WarmPoolPolicy
+		counts map[string]string
+		//states of containers known before check
+		states  map[string]common.ServerStatus
+		created int
+	}{
+		{
+			name:    "empty pool filled",
+			policy:  WarmPoolPolicy{Min: 2},
+			counts:  map[string]string{},
+			created: 2,
+		},
+		{
+			name:    "used containers replaced",
+			policy:  WarmPoolPolicy{Min: 2},
+			counts:  map[string]string{"container1": "1", "container2": "0"},
+			created: 1,
+		},
+		{
+			name:    "starting containers counted as idle",
+			policy:  WarmPoolPolicy{Min: 2},
+			counts:  map[string]string{"container1": "", "container2": "0"},
+			created: 0,
+		},
+		{
+			name:   "containers not started in time replaced",
+			policy: WarmPoolPolicy{Min: 2},
+			counts: map[string]string{"container1": "", "container2": "0"},
+			states: map[string]common.ServerStatus{
+				"container1": {ID: "container1", State: common.SERVER_STARTING, UpdatedAt: time.Now().Add(-SERVER_STARTING_TIMEOUT)},
+			},
+			created: 1,
+		},
+		{
+			name:   "started containers without reservation count replaced",
+			policy: WarmPoolPolicy{Min: 2},
+			counts: map[string]string{"container1": "", "container2": "0"},
+			states: map[string]common.ServerStatus{
+				"container1": {ID: "container1", State: common.SERVER_READY, UpdatedAt: time.Now()},
+			},
+			created: 1,
+		},
+		{
+			name:    "buffer grows with load",
+			policy:  WarmPoolPolicy{BufferPercent: 50},
+			counts:  map[string]string{"container1": "2", "container2": "1", "container3": "0"},
+			created: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dockerMock := interactor.MockInteractor{}
+			httpMock := web.HTTPClientMock{}
+
+			processor := Processor{
+				DockerClient:     &dockerMock,
+				HttpClient:       &httpMock,
+				ImageControlPort: "3000",
+				WarmPool:         test.policy,
+				servers:          test.states,
+			}
+
+			containers := []string{}
+			for containerID, count := range test.counts {
+				containers = append(containers, containerID)
+				dockerMock.On("InspectContainer", containerID).Return(interactor.ContainerInfo{Address: containerID}, nil)
+
+				//empty count is not reported, e.g. server is starting
+				hostname := containerID
+				countRequest := mock.MatchedBy(func(req *http.Request) bool {
+					return req.URL.Hostname() == hostname
+				})
+				httpResponse := http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(count))}
+				if count == "" {
+					httpResponse.StatusCode = http.StatusServiceUnavailable
+				}
+				httpMock.On("Do", countRequest).Return(&httpResponse, nil).Once()
+			}
+			dockerMock.On("ListContainers").Return(containers, nil)
+
+			if test.created > 0 {
+				dockerMock.On("CreateContainer", mock.Anything).Return("pool", nil).Times(test.created)
+				dockerMock.On("InspectContainer", "pool").Return(interactor.ContainerInfo{Address: "pool"}, nil).Times(test.created)
+			}
+
+			assert.NoError(t, processor.replenishWarmPool(context.Background()))
+			dockerMock.AssertExpectations(t)
+		})
+	}
+}
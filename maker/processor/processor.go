@@ -2,6 +2,8 @@ package processor
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
@@ -14,7 +16,16 @@ import (
 	"github.com/st-matskevich/go-matchmaker/maker/interactor"
 )
 
+// pops are restarted after this time, so queue availability is checked even without requests
+const QUEUE_POP_TIMEOUT = 5 * time.Second
+
+// limit of retry delay in ms if Processor.RetryBackoffMax is 0
+const MAX_RETRY_BACKOFF = 24 * 60 * 60 * 1000
+
 type Processor struct {
+	//written as source of request events
+	InstanceID string
+
 	DataProvider data.DataProvider
 	DockerClient interactor.ContainerInteractor
 	HttpClient   web.HTTPClient
@@ -27,47 +38,310 @@ type Processor struct {
 
 	ReservationRetries  int
 	ReservationCooldown int
-
-	creatorMutex sync.Mutex
+	//containers asked for reservation at the same time, one by one if 0 or 1
+	ReservationParallelism int
+
+	//failed request is queued again until it's processed this many times,
+	//then it's moved to dead-letter queue
+	RetryMaxAttempts int
+	//delay before retry in ms, doubled after each attempt up to RetryBackoffMax
+	RetryBackoff    int
+	RetryBackoffMax int
+
+	//how long Process waits for running jobs after its context is done
+	ShutdownTimeout time.Duration
+
+	//delays pops after queue errors, default breaker is used if nil
+	QueueBreaker *CircuitBreaker
+
+	//containers without reservations are removed after this time, never removed if 0
+	IdleTimeout  time.Duration
+	ReapInterval time.Duration
+
+	//order in which containers are asked for reservation, bin-packing is used if nil
+	Placement PlacementStrategy
+	//how long capacity reported by container is used without fetching it again
+	CapacityCacheTTL time.Duration
+
+	//containers created at the same time, 1 if 0
+	MaxCreations int
+	//containers of tenant and of all tenants, unlimited if 0. Jobs wait for
+	//free slot when limit is reached
+	MaxContainers      int
+	FleetMaxContainers int
+	//slots of new container, used to share it between waiting jobs until it's started,
+	//last capacity reported by containers is used if 0
+	ContainerSlots int
+
+	//idle containers that are created in advance and are not removed by reaper
+	WarmPool         WarmPoolPolicy
+	WarmPoolInterval time.Duration
+
+	//how often server states are synced with container list and published, see WatchServers
+	ServerSyncInterval time.Duration
+
+	//URL of SDK endpoint of this Maker replica passed to new containers, SDK is disabled if empty
+	SDKURL string
+	//servers that sent heartbeat are not asked for reservation if next heartbeat is late for this time
+	SDKHeartbeatTimeout time.Duration
+
+	//key of signed join tokens, tokens are not issued if nil
+	JoinTokenKey ed25519.PrivateKey
+	//lifetime of join token, DEFAULT_JOIN_TOKEN_TTL is used if 0
+	JoinTokenTTL time.Duration
+
+	//version of Reservation API implemented by image, common.RESERVATION_API_V1 is used if empty
+	ReservationAPI string
+
+	creationMutex sync.Mutex
+	//containers that are being created, see claimCreation
+	creations []*pendingCreation
+	//number of finished creations, see containerCounts
+	finishedCreations int
+	//held for reading by container lookups and for writing by container removal
+	lookupMutex   sync.RWMutex
+	capacityMutex sync.Mutex
+	capacityCache map[string]cachedCapacity
+	//total slots last reported by any container
+	reportedSlots int
+	//when reaper found containers without reservations first
+	idleSince map[string]time.Time
+
+	serversMutex sync.Mutex
+	//lifecycle states of containers by container ID
+	servers map[string]common.ServerStatus
+	//container IDs by hash of SDK token, empty while container is created
+	sdkTokens map[string]string
+	//last SDK report of container by container ID
+	heartbeats map[string]time.Time
+	//containers that replied draining to reservation request, unlike containers
+	//drained by reaper they are brought back by SDK ready or heartbeat report
+	drainingReplies map[string]bool
+
+	runningMutex sync.Mutex
+	//requests of running jobs by ID, nil until job locks request
+	running map[string]*common.RequestBody
 }
 
-func (processor *Processor) fillRequestWithContainerInfo(request *common.RequestBody, info *interactor.ContainerInfo) {
+func (processor *Processor) fillRequestWithContainerInfo(request *common.RequestBody, info *reservedContainer) {
 	request.Container = info.Address
+	request.ServerID = info.ServerID
 	request.ServerPort = info.ExposedPort
+	request.ExpiresAt = info.expiresAt
+	request.JoinToken = info.joinToken
+	request.PartyTokens = info.partyTokens
+	request.Joined = false
 }
 
-func (processor *Processor) Process() error {
+// QueueState returns state of queue circuit breaker
+func (processor *Processor) QueueState() string {
+	if processor.QueueBreaker == nil {
+		return BREAKER_CLOSED
+	}
+
+	return processor.QueueBreaker.State()
+}
+
+// Process pops and processes requests until ctx is done, then waits for running
+// jobs up to ShutdownTimeout and queues requests of unfinished jobs again
+func (processor *Processor) Process(ctx context.Context) error {
 	log.Printf("Starting processing messages in %v jobs", processor.MaxJobs)
 
+	if processor.QueueBreaker == nil {
+		processor.QueueBreaker = CreateCircuitBreaker(CircuitBreakerOptions{})
+	}
+
+	jobs := sync.WaitGroup{}
 	waitChan := make(chan struct{}, processor.MaxJobs)
 	for {
-		waitChan <- struct{}{}
+		select {
+		case waitChan <- struct{}{}:
+		case <-ctx.Done():
+			processor.shutdown(&jobs)
+			return nil
+		}
+
+		jobs.Add(1)
 		go func() {
-			val, err := processor.DataProvider.ListPop()
+			defer func() {
+				<-waitChan
+				jobs.Done()
+			}()
+
+			err := processor.QueueBreaker.Wait(ctx)
 			if err != nil {
-				log.Printf("Redis brpop error: %v", err)
+				return
 			}
 
+			//pop is limited, so breaker learns that queue is available even if it's empty
+			popCtx, cancel := context.WithTimeout(ctx, QUEUE_POP_TIMEOUT)
+			val, err := processor.DataProvider.ListPop(popCtx)
+			cancel()
+			if ctx.Err() != nil {
+				//ID can be popped right before cancellation
+				if err == nil {
+					processor.requeue(val, nil)
+				}
+				return
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				processor.QueueBreaker.Success()
+				return
+			} else if err != nil {
+				log.Printf("Queue pop error, queue is %v: %v", processor.QueueBreaker.State(), err)
+				processor.QueueBreaker.Failure()
+				return
+			}
+			processor.QueueBreaker.Success()
+
+			processor.addRunning(val)
 			err = processor.processMessage(val)
 			if err != nil {
 				log.Printf("Failed to process request (%v): %v", val, err)
 			}
 
-			<-waitChan
+			//request was requeued on shutdown, so it shouldn't be acknowledged
+			if !processor.removeRunning(val) {
+				return
+			}
+
+			//failures are already retried or dead-lettered, so request is acknowledged in any case
+			err = processor.DataProvider.ListAck(val)
+			if err != nil {
+				log.Printf("Failed to acknowledge request (%v): %v", val, err)
+			}
 		}()
 	}
 }
 
+// waits for jobs up to ShutdownTimeout, then queues requests of running jobs again
+func (processor *Processor) shutdown(jobs *sync.WaitGroup) {
+	log.Printf("Stopping processing, waiting for running jobs up to %v", processor.ShutdownTimeout)
+
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(processor.ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		log.Printf("All jobs finished")
+		return
+	case <-timer.C:
+	}
+
+	processor.runningMutex.Lock()
+	running := processor.running
+	processor.running = nil
+	processor.runningMutex.Unlock()
+
+	for ID, request := range running {
+		processor.requeue(ID, request)
+	}
+}
+
+func (processor *Processor) addRunning(ID string) {
+	processor.runningMutex.Lock()
+	defer processor.runningMutex.Unlock()
+
+	if processor.running == nil {
+		processor.running = map[string]*common.RequestBody{}
+	}
+	processor.running[ID] = nil
+}
+
+// only requests that are still running are updated
+func (processor *Processor) updateRunning(ID string, request *common.RequestBody) {
+	processor.runningMutex.Lock()
+	defer processor.runningMutex.Unlock()
+
+	if _, ok := processor.running[ID]; ok {
+		popped := *request
+		processor.running[ID] = &popped
+	}
+}
+
+// returns copy of running request, nil if request is not running or not locked yet
+func (processor *Processor) getRunning(ID string) *common.RequestBody {
+	processor.runningMutex.Lock()
+	defer processor.runningMutex.Unlock()
+
+	request := processor.running[ID]
+	if request == nil {
+		return nil
+	}
+
+	popped := *request
+	return &popped
+}
+
+// returns false if request is not running anymore
+func (processor *Processor) removeRunning(ID string) bool {
+	processor.runningMutex.Lock()
+	defer processor.runningMutex.Unlock()
+
+	_, ok := processor.running[ID]
+	delete(processor.running, ID)
+	return ok
+}
+
+// limit is logged once per request
+func (processor *Processor) logLimited(ID string, limited *bool) {
+	if *limited {
+		return
+	}
+
+	log.Printf("Request %v waits for free slot, container limit is reached", ID)
+	data.LogEvent(processor.DataProvider, processor.InstanceID, ID, common.RequestEvent{Type: common.EVENT_LIMITED})
+	*limited = true
+}
+
+// request is the one popped from the queue, nil if it was not read, attempt is not counted
+func (processor *Processor) requeue(ID string, request *common.RequestBody) {
+	log.Printf("Queueing request %v again, it was not processed before shutdown", ID)
+
+	var err error
+	if request == nil {
+		request, err = processor.DataProvider.Get(ID)
+	}
+
+	if err == nil && request != nil {
+		requeued := *request
+		requeued.Status = common.CREATED
+		_, err = processor.DataProvider.Set(requeued)
+		if err == nil {
+			err = processor.DataProvider.ListPush(ID, requeued.Priority, 0)
+		}
+	}
+
+	if err == nil {
+		err = processor.DataProvider.ListAck(ID)
+	}
+
+	if err != nil {
+		log.Printf("Failed to queue request %v again: %v", ID, err)
+		return
+	}
+
+	data.LogEvent(processor.DataProvider, processor.InstanceID, ID, common.RequestEvent{Type: common.EVENT_REQUEUED, Message: "shutdown"})
+}
+
 func (processor *Processor) processMessage(ID string) (rerr error) {
 	ctx := context.Background()
+	started := time.Now()
+	var request *common.RequestBody = nil
 	defer func() {
 		perr := recover()
 		if perr != nil || rerr != nil {
 			if rerr == nil {
 				rerr = common.HandlePanic(perr)
 			}
-			locker := common.RequestBody{ID: ID, Status: common.FAILED}
-			processor.DataProvider.Set(locker)
+			err := processor.handleFailure(ID, request, rerr, time.Since(started))
+			if err != nil {
+				log.Printf("Failed to handle request %v failure: %v", ID, err)
+			}
 		}
 	}()
 
@@ -81,8 +355,13 @@ func (processor *Processor) processMessage(ID string) (rerr error) {
 		return errors.New("cannot get request")
 	}
 
+	//locked request record has only status, so popped one is kept for requeue
+	processor.updateRunning(ID, request)
+
 	log.Printf("Starting processing request %v", request.ID)
+	data.LogEvent(processor.DataProvider, processor.InstanceID, request.ID, common.RequestEvent{Type: common.EVENT_POPPED})
 
+	limited := false
 	for {
 		containerInfo, err := processor.findRunningContainer(ctx, request.ID)
 		if err != nil {
@@ -94,21 +373,39 @@ func (processor *Processor) processMessage(ID string) (rerr error) {
 			break
 		}
 
-		if processor.creatorMutex.TryLock() {
-			defer processor.creatorMutex.Unlock()
-			containerInfo, err = processor.createNewContainer(ctx, request.ID)
-			if err != nil {
-				return err
-			}
+		creation, creator, err := processor.claimCreation()
+		if errors.Is(err, interactor.ErrFleetLimit) {
+			//request waits for free slot, so next requests stay queued
+			processor.logLimited(request.ID, &limited)
+		} else if err != nil {
+			return err
+		}
 
-			if containerInfo.ExposedPort == "" {
-				return errors.New("StartNewContainer didn't return port")
+		if creator {
+			containerInfo, err = processor.createClaimedContainer(creation, request.ID)
+			if errors.Is(err, interactor.ErrFleetLimit) {
+				processor.logLimited(request.ID, &limited)
+				time.Sleep(time.Duration(processor.LookupCooldown) * time.Millisecond)
+				continue
+			} else if err != nil {
+				return err
 			}
 
 			processor.fillRequestWithContainerInfo(request, &containerInfo)
 			break
 		}
 
+		if creation != nil {
+			containerInfo, reserved := processor.waitCreation(ctx, creation, request.ID)
+			if reserved {
+				processor.fillRequestWithContainerInfo(request, &containerInfo)
+				break
+			}
+
+			//container was not created or other job took the slot, so look for running containers again
+			continue
+		}
+
 		time.Sleep(time.Duration(processor.LookupCooldown) * time.Millisecond)
 	}
 
@@ -121,90 +418,229 @@ func (processor *Processor) processMessage(ID string) (rerr error) {
 	}
 
 	log.Printf("Set request %v status to DONE", request.ID)
+	data.LogEvent(processor.DataProvider, processor.InstanceID, request.ID, common.RequestEvent{
+		Type:       common.EVENT_DONE,
+		Container:  request.Container,
+		DurationMs: time.Since(started).Milliseconds(),
+	})
 
 	return nil
 }
 
-func (processor *Processor) findRunningContainer(ctx context.Context, requestID string) (interactor.ContainerInfo, error) {
+// request is the one popped from the queue, nil if it was not read
+func (processor *Processor) handleFailure(ID string, request *common.RequestBody, reason error, duration time.Duration) error {
+	failed := common.RequestBody{ID: ID, Reason: reason.Error()}
+	if request != nil {
+		failed.Priority = request.Priority
+		failed.Attempts = request.Attempts
+	}
+	failed.Attempts++
+
+	if failed.Attempts < processor.RetryMaxAttempts {
+		delay := processor.getRetryDelay(failed.Attempts)
+		log.Printf("Retrying request %v in %v, attempt %v failed", ID, delay, failed.Attempts)
+
+		failed.Status = common.CREATED
+		_, err := processor.DataProvider.Set(failed)
+		if err != nil {
+			return err
+		}
+
+		data.LogEvent(processor.DataProvider, processor.InstanceID, ID, common.RequestEvent{
+			Type:       common.EVENT_RETRY,
+			Message:    failed.Reason,
+			DurationMs: duration.Milliseconds(),
+		})
+
+		return processor.DataProvider.ListPush(ID, failed.Priority, delay)
+	}
+
+	log.Printf("Moving request %v to dead-letter queue after %v attempts", ID, failed.Attempts)
+
+	failed.Status = common.FAILED
+	_, err := processor.DataProvider.Set(failed)
+	if err != nil {
+		return err
+	}
+
+	data.LogEvent(processor.DataProvider, processor.InstanceID, ID, common.RequestEvent{
+		Type:       common.EVENT_FAILED,
+		Message:    failed.Reason,
+		DurationMs: duration.Milliseconds(),
+	})
+
+	return processor.DataProvider.DeadLetterPush(ID)
+}
+
+func (processor *Processor) getRetryDelay(attempts int) time.Duration {
+	delay := processor.RetryBackoff
+	//delay is not doubled past a day without limit, so it doesn't overflow
+	for i := 1; i < attempts && (processor.RetryBackoffMax <= 0 || delay < processor.RetryBackoffMax) && delay < MAX_RETRY_BACKOFF; i++ {
+		delay *= 2
+	}
+
+	if processor.RetryBackoffMax > 0 && delay > processor.RetryBackoffMax {
+		delay = processor.RetryBackoffMax
+	}
+
+	return time.Duration(delay) * time.Millisecond
+}
+
+func (processor *Processor) findRunningContainer(ctx context.Context, requestID string) (reservedContainer, error) {
+	processor.lookupMutex.RLock()
+	defer processor.lookupMutex.RUnlock()
+
 	log.Printf("Looking for available containers")
 
 	containers, err := processor.DockerClient.ListContainers()
 	if err != nil {
-		return interactor.ContainerInfo{}, err
+		return reservedContainer{}, err
 	}
 
+	processor.pruneCapacityCache(containers)
+
+	draining := processor.getDrainingServers()
+	candidates := []PlacementCandidate{}
 	for _, containerID := range containers {
+		if draining[containerID] {
+			continue
+		}
+
 		containerInfo, err := processor.DockerClient.InspectContainer(containerID)
 		if err != nil {
 			log.Printf("Failed InspectContainer on container %v: %v", containerID, err)
 			continue
 		}
 
-		reserved, err := processor.reserveContainer(containerInfo.Address, requestID, false)
-		if err != nil {
-			log.Printf("Failed reserve request on container %v: %v", containerID, err)
+		//full containers are skipped without reservation request, capacity request
+		//also finds out that starting server is ready
+		capacity := processor.getContainerCapacity(containerID, containerInfo)
+		if capacity != nil && capacity.Free <= 0 {
 			continue
 		}
 
-		if reserved {
-			log.Printf("Found available container %v", containerID)
-
-			return containerInfo, nil
+		if !processor.isServerAvailable(containerID) {
+			continue
 		}
+
+		candidates = append(candidates, PlacementCandidate{ID: containerID, Info: containerInfo, Capacity: capacity})
+	}
+
+	placement := processor.Placement
+	if placement == nil {
+		placement = &BinPackingPlacement{}
+	}
+
+	info, found := processor.reserveCandidates(requestID, placement.Order(candidates))
+	if found {
+		return info, nil
 	}
 
 	log.Printf("No available containers found")
 
-	return interactor.ContainerInfo{}, nil
+	return reservedContainer{}, nil
 }
 
-func (processor *Processor) createNewContainer(ctx context.Context, requestID string) (interactor.ContainerInfo, error) {
-	id, err := processor.DockerClient.CreateContainer()
+func (processor *Processor) createNewContainer(requestID string) (string, reservedContainer, error) {
+	env, token, err := processor.createServerEnv()
+	if err != nil {
+		return "", reservedContainer{}, err
+	}
+
+	id, err := processor.DockerClient.CreateContainer(env)
+	processor.registerServerToken(token, id)
 	if err != nil {
-		return interactor.ContainerInfo{}, err
+		return "", reservedContainer{}, err
 	}
 
 	containerInfo, err := processor.DockerClient.InspectContainer(id)
 	if err != nil {
-		return interactor.ContainerInfo{}, err
+		return id, reservedContainer{}, err
 	}
+	processor.advanceServer(id, containerInfo.Address, common.SERVER_STARTING)
+	data.LogEvent(processor.DataProvider, processor.InstanceID, requestID, common.RequestEvent{Type: common.EVENT_CONTAINER_CREATED, Container: containerInfo.Address})
 
-	reserved, err := processor.reserveContainer(containerInfo.Address, requestID, true)
+	reply, err := processor.reserveContainer(containerInfo, requestID, true)
 	if err != nil {
-		return interactor.ContainerInfo{}, err
+		return id, reservedContainer{}, err
 	}
 
-	if !reserved {
-		return interactor.ContainerInfo{}, errors.New("container failed to reserve a slot")
+	processor.applyReservationReply(id, containerInfo.Address, reply)
+	if !reply.reserved {
+		return id, reservedContainer{}, errors.New("container failed to reserve a slot")
 	}
 
-	return containerInfo, nil
+	return id, reservedContainer{ContainerInfo: containerInfo, reservedSlot: reply.reservedSlot}, nil
 }
 
-func (processor *Processor) reserveContainer(hostname string, requestID string, retry bool) (bool, error) {
-	containerURL := "http://" + hostname + ":" + processor.ImageControlPort
-	containerURL += "/reservation/" + requestID
+// reserveContainer sends join token to server and returns its reply, server errors are
+// retried the same way as failed requests
+func (processor *Processor) reserveContainer(info interactor.ContainerInfo, requestID string, retry bool) (reservationReply, error) {
+	hostname := info.Address
+	joinToken, err := processor.signJoinToken(info, requestID)
+	if err != nil {
+		return reservationReply{}, err
+	}
+
+	partyTokens, err := processor.signPartyTokens(info, processor.getRequestParty(requestID))
+	if err != nil {
+		return reservationReply{}, err
+	}
 
-	var err error
 	retriesCounter := 0
 	for {
-		req, err := http.NewRequest("POST", containerURL, nil)
+		req, err := processor.createReservationRequest(info, requestID, joinToken, partyTokens)
 		if err != nil {
-			return false, err
+			return reservationReply{}, err
 		}
 
+		now := time.Now()
+		var reply reservationReply
 		resp, err := processor.HttpClient.Do(req)
 		if err == nil {
-			return resp.StatusCode == 200, nil
+			data.LogEvent(processor.DataProvider, processor.InstanceID, requestID, common.RequestEvent{Type: common.EVENT_RESERVATION, Container: hostname, Code: resp.StatusCode})
+			reply, err = processor.readReservationReply(resp, now)
 		}
 
+		if err == nil {
+			if reply.reserved {
+				reply.joinToken = joinToken
+				reply.partyTokens = partyTokens
+			}
+			return reply, nil
+		}
+		data.LogEvent(processor.DataProvider, processor.InstanceID, requestID, common.RequestEvent{Type: common.EVENT_RESERVATION, Container: hostname, Message: err.Error()})
+
 		retriesCounter++
 		if !retry || retriesCounter >= processor.ReservationRetries {
-			break
+			return reservationReply{}, err
 		}
 
 		time.Sleep(time.Duration(processor.ReservationCooldown) * time.Millisecond)
 	}
+}
+
+// TTL is counted from the time request was sent, so Maker doesn't expect slot to be
+// held longer than server holds it. Expiry is not known if server doesn't report TTL
+func getReservationExpiry(resp *http.Response, sent time.Time) int64 {
+	if resp.Body == nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	status := common.ReservationStatus{}
+	err := json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return 0
+	}
+
+	return expiryFromTTL(status.TTLMs, sent)
+}
+
+func expiryFromTTL(ttlMs int64, sent time.Time) int64 {
+	if ttlMs <= 0 {
+		return 0
+	}
 
-	return false, err
+	return sent.Add(time.Duration(ttlMs) * time.Millisecond).UnixMilli()
 }
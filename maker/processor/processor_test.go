@@ -1,16 +1,22 @@
 package processor
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/st-matskevich/go-matchmaker/common"
 	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/data/datatest"
 	"github.com/st-matskevich/go-matchmaker/common/web"
 	"github.com/st-matskevich/go-matchmaker/maker/interactor"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -26,6 +32,14 @@ type ContainerReservationArgs struct {
 	panic            string
 }
 
+// registered before other requests, so containers don't report capacity unless test mocks it
+func mockNoCapacity(httpMock *web.HTTPClientMock) {
+	capacityRequest := mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Path == "/capacity"
+	})
+	httpMock.On("Do", capacityRequest).Return(&http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody}, nil).Maybe()
+}
+
 func TestContainerReservation(t *testing.T) {
 	tests := []struct {
 		name string
@@ -100,6 +114,10 @@ func TestContainerReservation(t *testing.T) {
 				ImageControlPort: containerControlPort,
 			}
 
+			// events are verified in backend tests
+			dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+			dataProvider.On("ServerList").Return(nil, nil).Maybe()
+
 			var request common.RequestBody
 			request.ID = requestID
 			request.Status = common.CREATED
@@ -113,7 +131,7 @@ func TestContainerReservation(t *testing.T) {
 			} else {
 				containerArray := []string{}
 				dockerMock.On("ListContainers").Return(containerArray, test.args.err, test.args.panic).Once()
-				dockerMock.On("CreateContainer").Return("", nil).Once()
+				dockerMock.On("CreateContainer", mock.Anything).Return("", nil).Once()
 			}
 
 			inspectResponse := interactor.ContainerInfo{}
@@ -122,6 +140,7 @@ func TestContainerReservation(t *testing.T) {
 			dockerMock.On("InspectContainer", mock.Anything).Return(inspectResponse, nil).Once()
 
 			// container reservation request
+			mockNoCapacity(&httpMock)
 			containerURL := "http://" + containerHostname + ":" + containerControlPort
 			containerURL += "/reservation/" + requestID
 			req, err := http.NewRequest("POST", containerURL, nil)
@@ -133,6 +152,11 @@ func TestContainerReservation(t *testing.T) {
 			// update request to DONE
 			dataProvider.On("Set", mock.Anything).Return(nil, nil).Once()
 
+			// failed request is moved to dead-letter queue
+			if test.want != nil {
+				dataProvider.On("DeadLetterPush", requestID).Return(nil).Once()
+			}
+
 			// create initial request
 			err = processor.processMessage(requestID)
 			assert.Equal(t, test.want, err)
@@ -145,3 +169,94 @@ func TestContainerReservation(t *testing.T) {
 		})
 	}
 }
+
+func TestRetryDelay(t *testing.T) {
+	processor := Processor{RetryBackoff: 100, RetryBackoffMax: 1000}
+
+	assert.Equal(t, 100*time.Millisecond, processor.getRetryDelay(1))
+	assert.Equal(t, 200*time.Millisecond, processor.getRetryDelay(2))
+	assert.Equal(t, 800*time.Millisecond, processor.getRetryDelay(4))
+	assert.Equal(t, 1000*time.Millisecond, processor.getRetryDelay(5))
+	assert.Equal(t, 1000*time.Millisecond, processor.getRetryDelay(100))
+
+	//zero max means no limit
+	processor = Processor{RetryBackoff: 100}
+	assert.Equal(t, 100*time.Millisecond, processor.getRetryDelay(1))
+	assert.Equal(t, 800*time.Millisecond, processor.getRetryDelay(4))
+	assert.Equal(t, 6400*time.Millisecond, processor.getRetryDelay(7))
+	assert.Greater(t, processor.getRetryDelay(100), time.Duration(0))
+}
+
+// countingProvider counts pops to detect hot loop on queue errors
+type countingProvider struct {
+	data.DataProvider
+	pops atomic.Int32
+}
+
+func (provider *countingProvider) ListPop(ctx context.Context) (string, error) {
+	provider.pops.Add(1)
+	return provider.DataProvider.ListPop(ctx)
+}
+
+func TestQueueFailure(t *testing.T) {
+	requestID := "request1"
+
+	server := miniredis.RunT(t)
+	redisProvider, err := data.CreateRedisDataProvider(data.RedisDataProviderOptions{Addresses: []string{server.Addr()}})
+	require.NoError(t, err)
+	dataProvider := &countingProvider{DataProvider: redisProvider}
+
+	dockerMock := interactor.MockInteractor{}
+	dockerMock.On("ListContainers").Return([]string{"container1"}, nil)
+	dockerMock.On("InspectContainer", mock.Anything).Return(interactor.ContainerInfo{Address: "container", ExposedPort: "34999"}, nil)
+	httpMock := web.HTTPClientMock{}
+	mockNoCapacity(&httpMock)
+	httpMock.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200}, nil)
+
+	processor := Processor{
+		DataProvider:     dataProvider,
+		DockerClient:     &dockerMock,
+		HttpClient:       &httpMock,
+		MaxJobs:          3,
+		ImageControlPort: "3000",
+		QueueBreaker: CreateCircuitBreaker(CircuitBreakerOptions{
+			Threshold:  2,
+			Backoff:    50 * time.Millisecond,
+			BackoffMax: 100 * time.Millisecond,
+		}),
+	}
+
+	server.SetError("LOADING Redis is loading the dataset in memory")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- processor.Process(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return processor.QueueState() != BREAKER_CLOSED
+	}, time.Second, 10*time.Millisecond)
+
+	//open breaker lets one pop per backoff
+	pops := dataProvider.pops.Load()
+	time.Sleep(500 * time.Millisecond)
+	assert.LessOrEqual(t, dataProvider.pops.Load()-pops, int32(10))
+
+	//failed pop should not create records
+	server.SetError("")
+	assert.Nil(t, datatest.GetRequest(t, dataProvider, ""))
+
+	_, err = dataProvider.Set(common.RequestBody{ID: requestID, Status: common.CREATED})
+	assert.NoError(t, err)
+	assert.NoError(t, dataProvider.ListPush(requestID, common.PRIORITY_NORMAL, 0))
+
+	assert.Eventually(t, func() bool {
+		request := datatest.GetRequest(t, dataProvider, requestID)
+		return request != nil && request.Status == common.DONE
+	}, datatest.POP_TIMEOUT, 10*time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, processor.QueueState())
+
+	cancel()
+	assert.NoError(t, <-stopped)
+}
@@ -0,0 +1,213 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.ReapInterval is not set
+const DEFAULT_REAP_INTERVAL = 30 * time.Second
+
+// Reap removes containers that had no reservations for IdleTimeout until ctx is
+// done, it returns right away if IdleTimeout is not set
+func (processor *Processor) Reap(ctx context.Context) {
+	if processor.IdleTimeout <= 0 {
+		return
+	}
+
+	interval := processor.ReapInterval
+	if interval <= 0 {
+		interval = DEFAULT_REAP_INTERVAL
+	}
+
+	log.Printf("Starting removing containers idle for %v", processor.IdleTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := processor.reapIdleContainers(now)
+			if err != nil {
+				log.Printf("Failed to remove idle containers: %v", err)
+			}
+		}
+	}
+}
+
+// containers are removed after second check that finds them idle for IdleTimeout,
+// idle containers of warm pool are kept
+func (processor *Processor) reapIdleContainers(now time.Time) error {
+	usage, err := processor.getContainerUsage()
+	if err != nil {
+		return err
+	}
+
+	if processor.idleSince == nil {
+		processor.idleSince = map[string]time.Time{}
+	}
+
+	idle := map[string]bool{}
+	expired := []string{}
+	for _, containerID := range usage.idle {
+		idle[containerID] = true
+
+		since, ok := processor.idleSince[containerID]
+		if !ok {
+			processor.idleSince[containerID] = now
+		} else if now.Sub(since) >= processor.IdleTimeout {
+			expired = append(expired, containerID)
+		}
+	}
+
+	//busy containers and containers that don't report reservations are never removed
+	for containerID := range processor.idleSince {
+		if !idle[containerID] {
+			delete(processor.idleSince, containerID)
+		}
+	}
+
+	removable := len(usage.idle) + usage.starting - processor.WarmPool.Target(usage.busy)
+	for _, containerID := range expired {
+		if removable <= 0 {
+			break
+		}
+
+		err = processor.removeIdleContainer(containerID)
+		if err != nil {
+			log.Printf("Failed to remove idle container %v: %v", containerID, err)
+			continue
+		}
+		delete(processor.idleSince, containerID)
+		removable--
+	}
+
+	return nil
+}
+
+// server is marked as draining in data backend before it's checked again, so lookups
+// of other replicas skip it while it's stopped. Lookups are not paused during stop
+func (processor *Processor) removeIdleContainer(containerID string) error {
+	previous, drained, err := processor.drainIdleServer(containerID)
+	if err != nil || !drained {
+		return err
+	}
+
+	log.Printf("Removing container %v, it had no reservations for %v", containerID, processor.IdleTimeout)
+	//server that wasn't stopped gets reservations again, removal is retried on next reap
+	err = processor.DockerClient.StopContainer(containerID)
+	if err != nil {
+		processor.publishServerState(containerID, previous)
+		return err
+	}
+	processor.publishServerState(containerID, common.SERVER_SHUTDOWN)
+
+	return processor.DockerClient.RemoveContainer(containerID)
+}
+
+// local lookups are paused until server is drained, reservation made by other replica
+// before it found draining state is found by second check. State before draining is returned
+func (processor *Processor) drainIdleServer(containerID string) (string, bool, error) {
+	processor.lookupMutex.Lock()
+	defer processor.lookupMutex.Unlock()
+
+	idle, err := processor.isContainerIdle(containerID)
+	if err != nil || !idle {
+		return "", false, err
+	}
+
+	previous := processor.getServerState(containerID)
+	processor.publishServerState(containerID, common.SERVER_DRAINING)
+	idle, err = processor.isContainerIdle(containerID)
+	if err != nil || !idle {
+		processor.publishServerState(containerID, previous)
+		return "", false, err
+	}
+
+	return previous, true, nil
+}
+
+type containerUsage struct {
+	//containers without reservations
+	idle []string
+	//number of containers with reservations
+	busy int
+	//number of starting containers that didn't report reservations yet, containers that
+	//don't report reservations after SERVER_STARTING_TIMEOUT or after start are not counted
+	starting int
+}
+
+func (processor *Processor) getContainerUsage() (containerUsage, error) {
+	result := containerUsage{}
+	containers, err := processor.DockerClient.ListContainers()
+	if err != nil {
+		return result, err
+	}
+
+	now := time.Now()
+	for _, containerID := range containers {
+		idle, err := processor.isContainerIdle(containerID)
+		if err != nil {
+			log.Printf("Failed to get reservations of container %v: %v", containerID, err)
+			if processor.isServerStarting(containerID, now) {
+				result.starting++
+			}
+		} else if idle {
+			result.idle = append(result.idle, containerID)
+		} else {
+			result.busy++
+		}
+	}
+
+	return result, nil
+}
+
+func (processor *Processor) isContainerIdle(containerID string) (bool, error) {
+	containerInfo, err := processor.DockerClient.InspectContainer(containerID)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := processor.getReservationCount(containerInfo)
+	if err != nil {
+		return false, err
+	}
+	processor.serverReserved(containerID, containerInfo.Address, count)
+
+	return count == 0, nil
+}
+
+// GET /reservation responds with number of reserved slots
+func (processor *Processor) getReservationCount(info interactor.ContainerInfo) (int, error) {
+	req, err := processor.createControlRequest("GET", info, "/reservation", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := processor.HttpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("unexpected response code " + strconv.Itoa(resp.StatusCode))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReapIdleContainers(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  int
+		count string
+		//count returned by second check after server is marked as draining
+		recheck string
+		elapsed time.Duration
+		pool    WarmPoolPolicy
+		stopErr error
+		removed bool
+	}{
+		{
+			name:    "idle container removed after timeout",
+			code:    http.StatusOK,
+			count:   "0",
+			elapsed: time.Minute,
+			removed: true,
+		},
+		{
+			name:    "container reserved while draining kept",
+			code:    http.StatusOK,
+			count:   "0",
+			recheck: "1",
+			elapsed: time.Minute,
+		},
+		{
+			name:    "idle container kept if stop fails",
+			code:    http.StatusOK,
+			count:   "0",
+			elapsed: time.Minute,
+			stopErr: errors.New("stop failed"),
+		},
+		{
+			name:    "idle container kept before timeout",
+			code:    http.StatusOK,
+			count:   "0",
+			elapsed: time.Second,
+		},
+		{
+			name:    "container with reservations kept",
+			code:    http.StatusOK,
+			count:   "2",
+			elapsed: time.Minute,
+		},
+		{
+			name:    "idle container of warm pool kept",
+			code:    http.StatusOK,
+			count:   "0",
+			elapsed: time.Minute,
+			pool:    WarmPoolPolicy{Min: 1},
+		},
+		{
+			name:    "container without reservation count kept",
+			code:    http.StatusNotFound,
+			elapsed: time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dockerMock := interactor.MockInteractor{}
+			httpMock := web.HTTPClientMock{}
+			dataProvider := data.CreateMemoryDataProvider(data.MemoryDataProviderOptions{})
+
+			processor := Processor{
+				DataProvider:     dataProvider,
+				DockerClient:     &dockerMock,
+				HttpClient:       &httpMock,
+				ImageControlPort: "3000",
+				IdleTimeout:      time.Minute,
+				WarmPool:         test.pool,
+			}
+
+			dockerMock.On("ListContainers").Return([]string{"container1"}, nil)
+			dockerMock.On("InspectContainer", "container1").Return(interactor.ContainerInfo{Address: "container", ExposedPort: "34999"}, nil)
+
+			countRequest := mock.MatchedBy(func(req *http.Request) bool {
+				return req.Method == "GET" && req.URL.String() == "http://container:3000/reservation"
+			})
+			//body can be read once, so each check gets own response
+			counts := []string{test.count, test.count, test.count, test.count}
+			if test.recheck != "" {
+				counts[3] = test.recheck
+			}
+			for _, count := range counts {
+				httpResponse := http.Response{StatusCode: test.code, Body: io.NopCloser(strings.NewReader(count))}
+				httpMock.On("Do", countRequest).Return(&httpResponse, nil).Once()
+			}
+
+			if test.stopErr != nil {
+				dockerMock.On("StopContainer", "container1").Return(test.stopErr).Once()
+			}
+			if test.removed {
+				dockerMock.On("StopContainer", "container1").Return(nil).Once()
+				dockerMock.On("RemoveContainer", "container1").Return(nil).Once()
+			}
+
+			//container is removed only if it's found idle twice
+			now := time.Now()
+			assert.NoError(t, processor.reapIdleContainers(now))
+			assert.NoError(t, processor.reapIdleContainers(now.Add(test.elapsed)))
+
+			dockerMock.AssertExpectations(t)
+			if !test.removed {
+				assert.NotEqual(t, common.SERVER_DRAINING, processor.getServerState("container1"))
+			}
+
+			//state of kept server is not left draining for other replicas
+			servers, err := dataProvider.ServerList()
+			assert.NoError(t, err)
+			for _, server := range servers {
+				if test.stopErr != nil {
+					dockerMock.On("StopContainer", "container1").Return(test.stopErr).Once()
+				}
+				if test.removed {
+					assert.Equal(t, common.SERVER_SHUTDOWN, server.State)
+				} else {
+					assert.NotEqual(t, common.SERVER_DRAINING, server.State)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/controlauth"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+)
+
+// used if Processor.JoinTokenTTL is not set, equal to default reservation timeout of reservation package
+const DEFAULT_JOIN_TOKEN_TTL = 5 * time.Minute
+
+// reservedSlot is slot that server reserved for request
+type reservedSlot struct {
+	//unix time in ms when server frees the slot if client doesn't join, 0 if not reported
+	expiresAt int64
+	//token that client shows to server on join, empty if join tokens are disabled
+	joinToken string
+	//tokens of party members by client ID, nil if join tokens are disabled
+	partyTokens map[string]string
+}
+
+// reservationReply is answer of server to reservation request
+type reservationReply struct {
+	reserved bool
+	//result code and message of v2 server, empty for v1
+	result  string
+	message string
+	//slots of v2 server after request, nil if not reported
+	slots *common.SlotInfo
+	reservedSlot
+}
+
+// reservedContainer is container that reserved a slot for request
+type reservedContainer struct {
+	interactor.ContainerInfo
+	reservedSlot
+}
+
+type reservationResult struct {
+	candidate PlacementCandidate
+	reserved  bool
+	slot      reservedSlot
+	err       error
+}
+
+// reserveCandidates asks candidates for reservation in given order, up to
+// ReservationParallelism at the same time, and returns first container that reserved a slot
+func (processor *Processor) reserveCandidates(requestID string, candidates []PlacementCandidate) (reservedContainer, bool) {
+	parallelism := processor.ReservationParallelism
+	if parallelism <= 1 {
+		for _, candidate := range candidates {
+			result := processor.reserveCandidate(requestID, candidate)
+			if result.reserved {
+				return result.container(), true
+			}
+		}
+
+		return reservedContainer{}, false
+	}
+
+	results := make(chan reservationResult)
+	next := 0
+	pending := 0
+	for next < len(candidates) || pending > 0 {
+		for pending < parallelism && next < len(candidates) {
+			candidate := candidates[next]
+			go func() {
+				results <- processor.reserveCandidate(requestID, candidate)
+			}()
+			next++
+			pending++
+		}
+
+		result := <-results
+		pending--
+		if result.reserved {
+			//slow containers shouldn't delay the request, their slots are released in background
+			party := processor.getRequestParty(requestID)
+			go processor.releaseExtraReservations(requestID, party, results, pending)
+			return result.container(), true
+		}
+	}
+
+	return reservedContainer{}, false
+}
+
+func (processor *Processor) reserveCandidate(requestID string, candidate PlacementCandidate) reservationResult {
+	reply, err := processor.reserveContainer(candidate.Info, requestID, false)
+	if err != nil {
+		log.Printf("Failed reserve request on container %v: %v", candidate.ID, err)
+		return reservationResult{candidate: candidate, err: err}
+	}
+
+	processor.applyReservationReply(candidate.ID, candidate.Info.Address, reply)
+	if reply.reserved {
+		log.Printf("Found available container %v", candidate.ID)
+	} else if reply.result != "" {
+		log.Printf("Container %v didn't reserve a slot: %v %v", candidate.ID, reply.result, reply.message)
+	}
+
+	return reservationResult{candidate: candidate, reserved: reply.reserved, slot: reply.reservedSlot}
+}
+
+func (result reservationResult) container() reservedContainer {
+	return reservedContainer{ContainerInfo: result.candidate.Info, reservedSlot: result.slot}
+}
+
+// waits for pending reservations and releases slots that were reserved after the first one,
+// so client and its party are never booked in more than one container
+func (processor *Processor) releaseExtraReservations(requestID string, party []string, results chan reservationResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if !result.reserved {
+			continue
+		}
+
+		err := processor.releaseContainer(result.candidate.Info, requestID, party)
+		if err != nil {
+			log.Printf("Failed to release extra reservation of request %v on container %v: %v", requestID, result.candidate.ID, err)
+			continue
+		}
+		processor.invalidateContainerCapacity(result.candidate.ID)
+	}
+}
+
+// v1 request has client ID in path and join token in header, v2 request has them in JSON body
+// together with attributes and party of request
+func (processor *Processor) createReservationRequest(info interactor.ContainerInfo, requestID string, joinToken string, partyTokens map[string]string) (*http.Request, error) {
+	if processor.ReservationAPI != common.RESERVATION_API_V2 {
+		req, err := processor.createControlRequest("POST", info, "/reservation/"+requestID, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if joinToken != "" {
+			req.Header.Set(jointoken.HEADER, joinToken)
+		}
+		return req, nil
+	}
+
+	body := common.ReservationRequest{ClientID: requestID, JoinToken: joinToken, PartyTokens: partyTokens}
+	request := processor.getRunning(requestID)
+	if request != nil {
+		body.Attributes = request.Attributes
+		body.Party = request.Party
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := processor.createControlRequest("POST", info, "/v2/reservation", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// v1 server reserves slot only with 200 response. v2 server reports result in body,
+// error result and response without result are returned as error
+func (processor *Processor) readReservationReply(resp *http.Response, sent time.Time) (reservationReply, error) {
+	if processor.ReservationAPI != common.RESERVATION_API_V2 {
+		if resp.StatusCode != http.StatusOK {
+			if resp.Body != nil {
+				resp.Body.Close()
+			}
+			return reservationReply{}, nil
+		}
+
+		return reservationReply{reserved: true, reservedSlot: reservedSlot{expiresAt: getReservationExpiry(resp, sent)}}, nil
+	}
+
+	if resp.Body == nil {
+		return reservationReply{}, errors.New("empty reservation response, code " + strconv.Itoa(resp.StatusCode))
+	}
+	defer resp.Body.Close()
+
+	response := common.ReservationResponse{}
+	err := json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return reservationReply{}, errors.New("invalid reservation response, code " + strconv.Itoa(resp.StatusCode))
+	}
+
+	reply := reservationReply{result: response.Result, message: response.Message, slots: response.Slots}
+	switch response.Result {
+	case common.RESERVATION_RESERVED:
+		reply.reserved = true
+		reply.expiresAt = expiryFromTTL(response.TTLMs, sent)
+	case common.RESERVATION_FULL, common.RESERVATION_DRAINING, common.RESERVATION_INCOMPATIBLE:
+	default:
+		return reservationReply{}, errors.New("server failed reservation: " + response.Result + " " + response.Message)
+	}
+
+	return reply, nil
+}
+
+// applyReservationReply updates cached capacity and state of server with its reply
+func (processor *Processor) applyReservationReply(containerID string, hostname string, reply reservationReply) {
+	if reply.slots != nil {
+		processor.setContainerCapacity(containerID, *reply.slots)
+	} else if reply.result != common.RESERVATION_INCOMPATIBLE {
+		//incompatible server may still have free slots for other requests
+		processor.updateContainerCapacity(containerID, reply.reserved)
+	}
+
+	//draining server is not asked for reservation until it reports ready or heartbeat
+	if reply.result == common.RESERVATION_DRAINING {
+		processor.serverRepliedDraining(containerID)
+	}
+
+	if reply.reserved {
+		processor.serverReserved(containerID, hostname, 1)
+	}
+}
+
+// releaseContainer frees slots reserved for client and its party, slots of other
+// members are released even if one of them fails
+func (processor *Processor) releaseContainer(info interactor.ContainerInfo, requestID string, party []string) error {
+	var result error
+	for _, clientID := range append([]string{requestID}, party...) {
+		err := processor.releaseSlot(info, requestID, clientID)
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// DELETE /reservation/{client-id} frees slot reserved for client, event is logged to request
+func (processor *Processor) releaseSlot(info interactor.ContainerInfo, requestID string, clientID string) error {
+	hostname := info.Address
+	req, err := processor.createControlRequest("DELETE", info, "/reservation/"+clientID, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := processor.HttpClient.Do(req)
+	if err != nil {
+		data.LogEvent(processor.DataProvider, processor.InstanceID, requestID, common.RequestEvent{Type: common.EVENT_RELEASE, Container: hostname, Message: err.Error()})
+		return err
+	}
+	defer resp.Body.Close()
+	data.LogEvent(processor.DataProvider, processor.InstanceID, requestID, common.RequestEvent{Type: common.EVENT_RELEASE, Container: hostname, Code: resp.StatusCode})
+
+	//slot that is already free is fine too
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return errors.New("unexpected response code " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return nil
+}
+
+// createControlRequest creates request to control port of server. Request has ID of the server,
+// so signing client derives control key of the server
+func (processor *Processor) createControlRequest(method string, info interactor.ContainerInfo, path string, body io.Reader) (*http.Request, error) {
+	containerURL := "http://" + info.Address + ":" + processor.ImageControlPort + path
+	req, err := http.NewRequest(method, containerURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.ServerID != "" {
+		req.Header.Set(controlauth.SERVER_ID_HEADER, info.ServerID)
+	}
+	return req, nil
+}
+
+// party members of running request, v1 servers don't reserve slots for party
+func (processor *Processor) getRequestParty(requestID string) []string {
+	if processor.ReservationAPI != common.RESERVATION_API_V2 {
+		return nil
+	}
+
+	request := processor.getRunning(requestID)
+	if request == nil {
+		return nil
+	}
+
+	return request.Party
+}
+
+// each party member gets own token, since server accepts each token only once
+func (processor *Processor) signPartyTokens(info interactor.ContainerInfo, party []string) (map[string]string, error) {
+	if processor.JoinTokenKey == nil || len(party) == 0 {
+		return nil, nil
+	}
+
+	tokens := map[string]string{}
+	for _, clientID := range party {
+		token, err := processor.signJoinToken(info, clientID)
+		if err != nil {
+			return nil, err
+		}
+		tokens[clientID] = token
+	}
+
+	return tokens, nil
+}
+
+// token is bound to server ID, so client can't use it on other server. Servers created
+// without server ID get tokens bound to their address
+func (processor *Processor) signJoinToken(info interactor.ContainerInfo, clientID string) (string, error) {
+	if processor.JoinTokenKey == nil {
+		return "", nil
+	}
+
+	ttl := processor.JoinTokenTTL
+	if ttl <= 0 {
+		ttl = DEFAULT_JOIN_TOKEN_TTL
+	}
+
+	container := info.ServerID
+	if container == "" {
+		container = info.Address
+	}
+
+	return jointoken.Sign(processor.JoinTokenKey, clientID, container, time.Now().Add(ttl))
+}
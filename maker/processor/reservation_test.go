@@ -0,0 +1,231 @@
+package processor
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/common/data"
+	"github.com/st-matskevich/go-matchmaker/common/web"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParallelReservation(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+
+	processor := Processor{
+		DataProvider:           &dataProvider,
+		HttpClient:             &httpMock,
+		ImageControlPort:       "3000",
+		ReservationParallelism: 2,
+	}
+
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	dataProvider.On("ServerList").Return(nil, nil).Maybe()
+
+	//container1 is dead, other containers have free slots
+	candidates := []PlacementCandidate{}
+	for _, containerID := range []string{"container1", "container2", "container3", "container4"} {
+		candidates = append(candidates, PlacementCandidate{ID: containerID, Info: interactor.ContainerInfo{Address: containerID}})
+	}
+
+	mutex := sync.Mutex{}
+	reserved := map[string]bool{}
+	released := map[string]bool{}
+	isRequest := func(method string) func(req *http.Request) bool {
+		return func(req *http.Request) bool {
+			return req.Method == method && strings.HasSuffix(req.URL.Path, "/reservation/request1")
+		}
+	}
+
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return isRequest("POST")(req) && req.URL.Hostname() == "container1"
+	})).Return(&http.Response{}, errors.New("connection refused")).Maybe()
+	httpMock.On("Do", mock.MatchedBy(isRequest("POST"))).Run(func(args mock.Arguments) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		reserved[args.Get(0).(*http.Request).URL.Hostname()] = true
+	}).Return(&http.Response{StatusCode: http.StatusOK}, nil).Maybe()
+	httpMock.On("Do", mock.MatchedBy(isRequest("DELETE"))).Run(func(args mock.Arguments) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		released[args.Get(0).(*http.Request).URL.Hostname()] = true
+	}).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil).Maybe()
+
+	info, found := processor.reserveCandidates("request1", candidates)
+	assert.True(t, found)
+	assert.NotEqual(t, "container1", info.Address)
+
+	//only the returned container keeps the reservation
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		for hostname := range reserved {
+			if hostname != info.Address && !released[hostname] {
+				return false
+			}
+		}
+
+		return !released[info.Address]
+	}, time.Second, 10*time.Millisecond)
+
+	//no more containers are asked after reservation
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.False(t, reserved["container4"])
+}
+
+func TestReserveContainer(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+	public, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	processor := Processor{DataProvider: &dataProvider, HttpClient: &httpMock, ImageControlPort: "3000", JoinTokenKey: key}
+
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	dataProvider.On("ServerList").Return(nil, nil).Maybe()
+	//join token bound to server ID is sent to server
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		claims, err := jointoken.Parse(public, req.Header.Get(jointoken.HEADER), time.Now())
+		return req.URL.Hostname() == "container1" && err == nil && claims.ClientID == "request1" && claims.Container == "server1"
+	})).Return(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ttl_ms": 60000}`))}, nil).Once()
+	//server that doesn't report TTL has no expiry, server without ID gets token bound to address
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		claims, err := jointoken.Parse(public, req.Header.Get(jointoken.HEADER), time.Now())
+		return req.URL.Hostname() == "container2" && err == nil && claims.Container == "container2"
+	})).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil).Once()
+
+	sent := time.Now()
+	reply, err := processor.reserveContainer(interactor.ContainerInfo{Address: "container1", ServerID: "server1"}, "request1", false)
+	assert.NoError(t, err)
+	assert.True(t, reply.reserved)
+	assert.InDelta(t, sent.Add(time.Minute).UnixMilli(), reply.expiresAt, 1000)
+	assert.NotEmpty(t, reply.joinToken)
+
+	reply, err = processor.reserveContainer(interactor.ContainerInfo{Address: "container2"}, "request1", false)
+	assert.NoError(t, err)
+	assert.True(t, reply.reserved)
+	assert.Equal(t, int64(0), reply.expiresAt)
+	httpMock.AssertExpectations(t)
+}
+
+func TestReleaseParty(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+	processor := Processor{DataProvider: &dataProvider, HttpClient: &httpMock, ImageControlPort: "3000"}
+
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	//slots of party members are released even if client has no slot
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "DELETE" && req.URL.Path == "/reservation/request1"
+	})).Return(&http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil).Once()
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == "DELETE" && req.URL.Path == "/reservation/request2"
+	})).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil).Once()
+
+	err := processor.releaseContainer(interactor.ContainerInfo{Address: "container1"}, "request1", []string{"request2"})
+	assert.Error(t, err)
+	httpMock.AssertExpectations(t)
+}
+
+func TestReserveContainerV2(t *testing.T) {
+	dataProvider := data.MockDataProvider{}
+	httpMock := web.HTTPClientMock{}
+	public, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	processor := Processor{DataProvider: &dataProvider, HttpClient: &httpMock, ImageControlPort: "3000", ReservationAPI: common.RESERVATION_API_V2, JoinTokenKey: key}
+
+	dataProvider.On("EventPush", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	dataProvider.On("ServerList").Return(nil, nil).Maybe()
+	dataProvider.On("ServerSet", mock.Anything).Return(nil).Maybe()
+	processor.addRunning("request1")
+	processor.updateRunning("request1", &common.RequestBody{ID: "request1", Attributes: map[string]string{"mode": "duel"}, Party: []string{"request2"}})
+
+	response := func(code int, body string) *http.Response {
+		return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	//attributes and party of request are sent in body, each party member gets own token
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		if req.URL.Hostname() != "container1" || req.URL.Path != "/v2/reservation" {
+			return false
+		}
+
+		body := common.ReservationRequest{}
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil {
+			return false
+		}
+
+		claims, err := jointoken.Parse(public, body.PartyTokens["request2"], time.Now())
+		return body.ClientID == "request1" && body.Attributes["mode"] == "duel" && len(body.Party) == 1 && err == nil && claims.ClientID == "request2"
+	})).Return(response(http.StatusOK, `{"result":"reserved","slots":{"total":4,"free":2},"ttl_ms":60000}`), nil).Once()
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Hostname() == "container2"
+	})).Return(response(http.StatusConflict, `{"result":"full","slots":{"total":4,"free":0}}`), nil).Once()
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Hostname() == "container3"
+	})).Return(response(http.StatusConflict, `{"result":"draining"}`), nil).Once()
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Hostname() == "container4"
+	})).Return(response(http.StatusConflict, `{"result":"incompatible","message":"other game mode"}`), nil).Once()
+	httpMock.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.URL.Hostname() == "container5"
+	})).Return(response(http.StatusInternalServerError, `{"result":"error","message":"database is down"}`), nil).Once()
+
+	sent := time.Now()
+	result := processor.reserveCandidate("request1", PlacementCandidate{ID: "container1", Info: interactor.ContainerInfo{Address: "container1"}})
+	assert.True(t, result.reserved)
+	assert.InDelta(t, sent.Add(time.Minute).UnixMilli(), result.slot.expiresAt, 1000)
+	assert.Contains(t, result.slot.partyTokens, "request2")
+	assert.Equal(t, &ContainerCapacity{Total: 4, Free: 2}, processor.getContainerCapacity("container1", interactor.ContainerInfo{Address: "container1"}))
+	assert.Equal(t, common.SERVER_ALLOCATED, processor.getServerState("container1"))
+
+	//full server is not asked again until its capacity is fetched
+	result = processor.reserveCandidate("request1", PlacementCandidate{ID: "container2", Info: interactor.ContainerInfo{Address: "container2"}})
+	assert.False(t, result.reserved)
+	assert.NoError(t, result.err)
+	assert.Equal(t, &ContainerCapacity{Total: 4, Free: 0}, processor.getContainerCapacity("container2", interactor.ContainerInfo{Address: "container2"}))
+
+	//draining server is skipped by lookups
+	processor.serverResponded("container3", "container3")
+	result = processor.reserveCandidate("request1", PlacementCandidate{ID: "container3", Info: interactor.ContainerInfo{Address: "container3"}})
+	assert.False(t, result.reserved)
+	assert.Equal(t, common.SERVER_DRAINING, processor.getServerState("container3"))
+	assert.False(t, processor.isServerAvailable("container3"))
+
+	//server that stopped draining is asked again after SDK heartbeat
+	processor.sdkTokens = map[string]string{hashToken("token3"): "container3"}
+	assert.NoError(t, processor.ReportServer("token3", SDK_REPORT_HEARTBEAT))
+	assert.True(t, processor.isServerAvailable("container3"))
+
+	//server drained by reaper is not brought back
+	processor.publishServerState("container3", common.SERVER_DRAINING)
+	assert.NoError(t, processor.ReportServer("token3", SDK_REPORT_READY))
+	assert.Equal(t, common.SERVER_DRAINING, processor.getServerState("container3"))
+
+	result = processor.reserveCandidate("request1", PlacementCandidate{ID: "container4", Info: interactor.ContainerInfo{Address: "container4"}})
+	assert.False(t, result.reserved)
+	assert.NoError(t, result.err)
+
+	result = processor.reserveCandidate("request1", PlacementCandidate{ID: "container5", Info: interactor.ContainerInfo{Address: "container5"}})
+	assert.False(t, result.reserved)
+	assert.Error(t, result.err)
+	httpMock.AssertExpectations(t)
+}
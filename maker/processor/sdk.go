@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+)
+
+// env of new containers, server sends SDK reports to SDK_URL_ENV with
+// SDK_TOKEN_ENV in Authorization header
+const SDK_URL_ENV = "MATCHMAKER_SDK_URL"
+const SDK_TOKEN_ENV = "MATCHMAKER_SDK_TOKEN"
+
+// replaced in SDK URL with hostname of Maker replica. Tokens and heartbeats are kept
+// in memory of replica that created the container, so servers report to this replica
+const SDK_URL_HOSTNAME = "{hostname}"
+
+// used if Processor.SDKHeartbeatTimeout is not set
+const DEFAULT_SDK_HEARTBEAT_TIMEOUT = 30 * time.Second
+
+// reports that game server sends to Maker SDK endpoint
+const (
+	//server is started and accepts reservations
+	SDK_REPORT_READY = "ready"
+	//server is alive, servers that sent heartbeat once are skipped if next one is late
+	SDK_REPORT_HEARTBEAT = "heartbeat"
+	//all slots are free
+	SDK_REPORT_MATCH_ENDED = "match-ended"
+	//one slot is free, capacity is fetched again
+	SDK_REPORT_SLOT_FREED = "slot-freed"
+	//server is going to exit, container is stopped and removed
+	SDK_REPORT_SHUTDOWN = "shutdown"
+)
+
+var ErrUnknownToken = errors.New("unknown SDK token")
+var ErrUnknownReport = errors.New("unknown SDK report")
+
+// returned if container that got token is still being created, server should retry
+var ErrServerPending = errors.New("server is not created yet")
+
+// createServerEnv returns env and SDK token of new container, token is empty if SDK is disabled.
+// Token is registered before container is created, since server can report before
+// CreateContainer returns. Public key of join tokens is passed if they are enabled
+func (processor *Processor) createServerEnv() (map[string]string, string, error) {
+	serverID := make([]byte, 16)
+	_, err := rand.Read(serverID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	env := map[string]string{common.SERVER_ID_ENV: hex.EncodeToString(serverID)}
+	if processor.JoinTokenKey != nil {
+		env[jointoken.KEY_ENV] = jointoken.EncodePublicKey(processor.JoinTokenKey.Public().(ed25519.PublicKey))
+	}
+
+	if processor.SDKURL == "" {
+		return env, "", nil
+	}
+
+	bytes := make([]byte, 32)
+	_, err = rand.Read(bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	if processor.sdkTokens == nil {
+		processor.sdkTokens = map[string]string{}
+	}
+	processor.sdkTokens[hashToken(token)] = ""
+
+	env[SDK_URL_ENV] = processor.SDKURL
+	env[SDK_TOKEN_ENV] = token
+	return env, token, nil
+}
+
+// registerServerToken links token to created container, token of failed creation is
+// dropped with empty containerID
+func (processor *Processor) registerServerToken(token string, containerID string) {
+	if token == "" {
+		return
+	}
+
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	if containerID == "" {
+		delete(processor.sdkTokens, hashToken(token))
+		return
+	}
+
+	processor.sdkTokens[hashToken(token)] = containerID
+}
+
+// should be called with locked serversMutex
+func (processor *Processor) dropServerToken(containerID string) {
+	for hash, tokenContainerID := range processor.sdkTokens {
+		if tokenContainerID == containerID {
+			delete(processor.sdkTokens, hash)
+		}
+	}
+	delete(processor.heartbeats, containerID)
+	delete(processor.drainingReplies, containerID)
+}
+
+// ReportServer applies SDK report of server that owns token
+func (processor *Processor) ReportServer(token string, report string) error {
+	processor.serversMutex.Lock()
+	containerID, ok := processor.sdkTokens[hashToken(token)]
+	if ok && containerID != "" {
+		if processor.heartbeats == nil {
+			processor.heartbeats = map[string]time.Time{}
+		}
+		processor.heartbeats[containerID] = time.Now()
+	}
+	processor.serversMutex.Unlock()
+
+	if !ok {
+		return ErrUnknownToken
+	}
+
+	if containerID == "" {
+		return ErrServerPending
+	}
+
+	switch report {
+	case SDK_REPORT_READY:
+		processor.recoverDrainingServer(containerID)
+		processor.serverResponded(containerID, "")
+	case SDK_REPORT_HEARTBEAT:
+		processor.recoverDrainingServer(containerID)
+	case SDK_REPORT_MATCH_ENDED:
+		processor.invalidateContainerCapacity(containerID)
+		processor.serverReserved(containerID, "", 0)
+	case SDK_REPORT_SLOT_FREED:
+		processor.invalidateContainerCapacity(containerID)
+	case SDK_REPORT_SHUTDOWN:
+		processor.setServerState(containerID, common.SERVER_SHUTDOWN)
+		go processor.removeServerContainer(containerID)
+	default:
+		return ErrUnknownReport
+	}
+
+	return nil
+}
+
+// server is not asked for reservation after shutdown report, so lookups are not paused
+func (processor *Processor) removeServerContainer(containerID string) {
+	log.Printf("Removing container %v, server reported shutdown", containerID)
+	err := processor.DockerClient.StopContainer(containerID)
+	if err == nil {
+		err = processor.DockerClient.RemoveContainer(containerID)
+	}
+
+	if err != nil {
+		log.Printf("Failed to remove container %v: %v", containerID, err)
+	}
+}
+
+// servers that never sent heartbeat don't use SDK, so they are not checked
+func (processor *Processor) isHeartbeatLate(containerID string) bool {
+	timeout := processor.SDKHeartbeatTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SDK_HEARTBEAT_TIMEOUT
+	}
+
+	processor.serversMutex.Lock()
+	defer processor.serversMutex.Unlock()
+
+	heartbeat, ok := processor.heartbeats[containerID]
+	return ok && time.Since(heartbeat) > timeout
+}
+
+// only hashes are kept, so token lookup time doesn't depend on matching prefix
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/st-matskevich/go-matchmaker/jointoken"
+	"github.com/st-matskevich/go-matchmaker/maker/interactor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestServerEnvWithJoinTokens(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	processor := Processor{JoinTokenKey: key}
+
+	//each server gets own ID that its join tokens are bound to
+	env, token, err := processor.createServerEnv()
+	assert.NoError(t, err)
+	assert.Empty(t, token)
+	assert.Equal(t, jointoken.EncodePublicKey(public), env[jointoken.KEY_ENV])
+	assert.NotEmpty(t, env[common.SERVER_ID_ENV])
+
+	other, _, err := processor.createServerEnv()
+	assert.NoError(t, err)
+	assert.NotEqual(t, env[common.SERVER_ID_ENV], other[common.SERVER_ID_ENV])
+}
+
+func TestServerReports(t *testing.T) {
+	dockerMock := interactor.MockInteractor{}
+	processor := Processor{DockerClient: &dockerMock, SDKURL: "http://maker:3002", SDKHeartbeatTimeout: time.Minute}
+
+	env, token, err := processor.createServerEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{SDK_URL_ENV: "http://maker:3002", SDK_TOKEN_ENV: token, common.SERVER_ID_ENV: env[common.SERVER_ID_ENV]}, env)
+
+	//server can report before container is created
+	assert.ErrorIs(t, processor.ReportServer(token, SDK_REPORT_READY), ErrServerPending)
+	processor.registerServerToken(token, "container1")
+	processor.handleContainerEvent(interactor.ContainerEvent{ID: "container1", Type: interactor.CONTAINER_STARTED})
+
+	assert.ErrorIs(t, processor.ReportServer("other", SDK_REPORT_READY), ErrUnknownToken)
+	assert.ErrorIs(t, processor.ReportServer(token, "unknown"), ErrUnknownReport)
+
+	assert.NoError(t, processor.ReportServer(token, SDK_REPORT_READY))
+	assert.Equal(t, common.SERVER_READY, processor.getServerState("container1"))
+	assert.True(t, processor.isServerAvailable("container1"))
+
+	processor.serverReserved("container1", "", 2)
+	processor.capacityCache = map[string]cachedCapacity{"container1": {capacity: &ContainerCapacity{Total: 2}}}
+	assert.NoError(t, processor.ReportServer(token, SDK_REPORT_SLOT_FREED))
+	assert.Equal(t, common.SERVER_ALLOCATED, processor.getServerState("container1"))
+	assert.NotContains(t, processor.capacityCache, "container1")
+
+	assert.NoError(t, processor.ReportServer(token, SDK_REPORT_MATCH_ENDED))
+	assert.Equal(t, common.SERVER_READY, processor.getServerState("container1"))
+
+	//server with late heartbeat is not asked for reservation
+	processor.heartbeats["container1"] = time.Now().Add(-2 * time.Minute)
+	assert.False(t, processor.isServerAvailable("container1"))
+	assert.NoError(t, processor.ReportServer(token, SDK_REPORT_HEARTBEAT))
+	assert.True(t, processor.isServerAvailable("container1"))
+
+	removed := make(chan struct{})
+	dockerMock.On("StopContainer", "container1").Return(nil).Once()
+	dockerMock.On("RemoveContainer", "container1").Return(nil).Once().Run(func(args mock.Arguments) {
+		close(removed)
+	})
+	assert.NoError(t, processor.ReportServer(token, SDK_REPORT_SHUTDOWN))
+	assert.Equal(t, common.SERVER_SHUTDOWN, processor.getServerState("container1"))
+	<-removed
+	dockerMock.AssertExpectations(t)
+}
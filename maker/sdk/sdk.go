@@ -0,0 +1,46 @@
+package sdk
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/maker/processor"
+)
+
+// retry delay in seconds for servers that report before their container is created
+const SDK_RETRY_AFTER = "1"
+
+type Controller struct {
+	//processors by tenant, SDK tokens are unique across tenants
+	Processors map[string]*processor.Processor
+}
+
+// HandleReport applies report from path to server that owns token from Authorization header
+func (controller *Controller) HandleReport(c *fiber.Ctx) error {
+	token := c.Get(fiber.HeaderAuthorization)
+	if token == "" {
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+
+	report := c.Params("report")
+	for tenant, tenantProcessor := range controller.Processors {
+		err := tenantProcessor.ReportServer(token, report)
+		switch {
+		case errors.Is(err, processor.ErrUnknownToken):
+			continue
+		case errors.Is(err, processor.ErrUnknownReport):
+			return c.SendStatus(fiber.StatusNotFound)
+		case errors.Is(err, processor.ErrServerPending):
+			c.Set(fiber.HeaderRetryAfter, SDK_RETRY_AFTER)
+			return c.SendStatus(fiber.StatusServiceUnavailable)
+		case err != nil:
+			log.Printf("Failed to apply SDK report of tenant %q: %v", tenant, err)
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	return c.SendStatus(fiber.StatusUnauthorized)
+}
@@ -0,0 +1,26 @@
+package sdk
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/st-matskevich/go-matchmaker/maker/processor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownToken(t *testing.T) {
+	controller := Controller{Processors: map[string]*processor.Processor{"shooter": {}, "racing": {}}}
+	app := fiber.New()
+	app.Post("/sdk/:report", controller.HandleReport)
+
+	for _, token := range []string{"", "unknown"} {
+		httpRequest, err := http.NewRequest("POST", "/sdk/"+processor.SDK_REPORT_READY, nil)
+		assert.NoError(t, err)
+		httpRequest.Header.Set("Authorization", token)
+
+		response, err := app.Test(httpRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusUnauthorized, response.StatusCode)
+	}
+}
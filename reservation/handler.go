@@ -0,0 +1,127 @@
+package reservation
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+const RESERVATION_PATH = "/reservation"
+const CAPACITY_PATH = "/capacity"
+const V2_RESERVATION_PATH = "/v2/reservation"
+
+// Handler serves Reservation API with slots of manager, it can be used as server on
+// control port or mounted to existing mux:
+//
+//	mux.Handle("/reservation/", handler)
+//	mux.Handle("/reservation", handler)
+//	mux.Handle("/capacity", handler)
+//	mux.Handle("/v2/reservation", handler)
+type Handler struct {
+	Slots *SlotManager
+}
+
+func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == CAPACITY_PATH:
+		handler.handleCapacity(w, r)
+	case r.URL.Path == RESERVATION_PATH:
+		handler.handleCount(w, r)
+	case r.URL.Path == V2_RESERVATION_PATH:
+		handler.handleReserveV2(w, r)
+	case strings.HasPrefix(r.URL.Path, RESERVATION_PATH+"/"):
+		clientID := strings.TrimPrefix(r.URL.Path, RESERVATION_PATH+"/")
+		if clientID == "" || strings.Contains(clientID, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		handler.handleClient(w, r, clientID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (handler *Handler) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(handler.Slots.Capacity())
+}
+
+func (handler *Handler) handleCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Write([]byte(strconv.Itoa(handler.Slots.Count())))
+}
+
+// responds with 200 if slots were reserved, with 409 if server is full, draining or
+// incompatible and with 400 to invalid body. Result is reported in body
+func (handler *Handler) handleReserveV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := common.ReservationRequest{}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.ClientID == "" {
+		writeResponse(w, http.StatusBadRequest, common.ReservationResponse{Result: common.RESERVATION_ERROR, Message: "invalid reservation request"})
+		return
+	}
+
+	response := handler.Slots.ReserveRequest(request)
+	code := http.StatusConflict
+	if response.Result == common.RESERVATION_RESERVED {
+		code = http.StatusOK
+	}
+	writeResponse(w, code, response)
+}
+
+func writeResponse(w http.ResponseWriter, code int, response common.ReservationResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// POST responds with 403 if slot was not reserved, GET and DELETE with 404 if client has no slot.
+// POST and GET report reservation status in body
+func (handler *Handler) handleClient(w http.ResponseWriter, r *http.Request, clientID string) {
+	switch r.Method {
+	case http.MethodPost:
+		if !handler.Slots.Reserve(clientID) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.writeStatus(w, clientID)
+	case http.MethodGet:
+		handler.writeStatus(w, clientID)
+	case http.MethodDelete:
+		if !handler.Slots.Cancel(clientID) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *Handler) writeStatus(w http.ResponseWriter, clientID string) {
+	status, ok := handler.Slots.Status(clientID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,98 @@
+package reservation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	handler := &Handler{Slots: CreateSlotManager(SlotManagerOptions{Capacity: 1, Metadata: map[string]string{"map": "dust"}})}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{name: "reserve slot", method: http.MethodPost, path: "/reservation/client1", code: http.StatusOK},
+		{name: "server is full", method: http.MethodPost, path: "/reservation/client2", code: http.StatusForbidden},
+		{name: "reservation exists", method: http.MethodGet, path: "/reservation/client1", code: http.StatusOK},
+		{name: "reservation not found", method: http.MethodGet, path: "/reservation/client2", code: http.StatusNotFound},
+		{name: "count reservations", method: http.MethodGet, path: "/reservation", code: http.StatusOK, body: "1"},
+		{name: "capacity", method: http.MethodGet, path: "/capacity", code: http.StatusOK, body: `{"total":1,"free":0,"metadata":{"map":"dust"}}` + "\n"},
+		{name: "cancel reservation", method: http.MethodDelete, path: "/reservation/client1", code: http.StatusOK},
+		{name: "cancel missing reservation", method: http.MethodDelete, path: "/reservation/client1", code: http.StatusNotFound},
+		{name: "unknown path", method: http.MethodGet, path: "/reservation/client1/extra", code: http.StatusNotFound},
+		{name: "unknown method", method: http.MethodPut, path: "/reservation/client1", code: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+
+			assert.Equal(t, test.code, recorder.Code)
+			if test.body != "" {
+				body, err := io.ReadAll(recorder.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.body, string(body))
+			}
+		})
+	}
+}
+
+func TestHandlerReportsStatus(t *testing.T) {
+	handler := &Handler{Slots: CreateSlotManager(SlotManagerOptions{Capacity: 1, Timeout: time.Minute})}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/reservation/client1", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	status := common.ReservationStatus{}
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+	assert.False(t, status.Joined)
+	assert.InDelta(t, time.Minute.Milliseconds(), status.TTLMs, 1000)
+
+	assert.True(t, handler.Slots.Join("client1"))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/reservation/client1", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"joined": true}`, recorder.Body.String())
+}
+
+func TestHandlerV2(t *testing.T) {
+	handler := &Handler{Slots: CreateSlotManager(SlotManagerOptions{Capacity: 1, Timeout: time.Minute})}
+
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		result string
+	}{
+		{name: "reserved", body: `{"client_id":"client1"}`, code: http.StatusOK, result: common.RESERVATION_RESERVED},
+		{name: "full", body: `{"client_id":"client2"}`, code: http.StatusConflict, result: common.RESERVATION_FULL},
+		{name: "invalid body", body: `{"party":`, code: http.StatusBadRequest, result: common.RESERVATION_ERROR},
+		{name: "no client", body: `{}`, code: http.StatusBadRequest, result: common.RESERVATION_ERROR},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v2/reservation", strings.NewReader(test.body)))
+			assert.Equal(t, test.code, recorder.Code)
+
+			response := common.ReservationResponse{}
+			assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+			assert.Equal(t, test.result, response.Result)
+		})
+	}
+}
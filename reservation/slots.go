@@ -0,0 +1,297 @@
+// Package reservation implements Reservation API of go-matchmaker for game servers
+// written in Go. SlotManager keeps reserved slots of server, Handler serves them to Maker
+// and API on control port
+package reservation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+)
+
+// used if SlotManagerOptions fields are not set, equal to default RESERVATION_LIFETIME of API
+const DEFAULT_RESERVATION_TIMEOUT = 5 * time.Minute
+
+// reasons of slot release passed to OnRelease hook
+const (
+	//client didn't join before reservation timeout
+	RELEASE_EXPIRED = "expired"
+	//reservation was canceled by Maker or game code
+	RELEASE_CANCELED = "canceled"
+	//joined player left the server
+	RELEASE_LEFT = "left"
+)
+
+// Capacity is response of GET /capacity
+type Capacity struct {
+	Total int `json:"total"`
+	Free  int `json:"free"`
+	//server specific information, e.g. map or version
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type slot struct {
+	joined    bool
+	expiresAt time.Time
+	//stopped when client joins or reservation is canceled
+	timer *time.Timer
+}
+
+// SlotManager keeps slots of clients, slot is reserved by Maker and held until client
+// joins or reservation timeout passes. Joined player keeps the slot until Leave
+type SlotManager struct {
+	options SlotManagerOptions
+
+	mutex sync.Mutex
+	slots map[string]*slot
+	//new reservations are rejected, see Drain
+	draining bool
+}
+
+// Reserve takes slot for client, repeated reservation of the same client succeeds
+// without taking another slot. Returns false if server is full, draining or rejected by hooks
+func (manager *SlotManager) Reserve(clientID string) bool {
+	response := manager.ReserveRequest(common.ReservationRequest{ClientID: clientID})
+	return response.Result == common.RESERVATION_RESERVED
+}
+
+// ReserveRequest takes slots for client and its party together, either for all of them or
+// for none. Clients that already have slots keep them. Returns response of Reservation API v2
+func (manager *SlotManager) ReserveRequest(request common.ReservationRequest) common.ReservationResponse {
+	if manager.options.Compatible != nil && !manager.options.Compatible(request) {
+		return manager.response(common.RESERVATION_INCOMPATIBLE, "request is not compatible with server")
+	}
+
+	clients := append([]string{request.ClientID}, request.Party...)
+	if manager.options.OnReserve != nil {
+		for _, clientID := range clients {
+			if !manager.options.OnReserve(clientID) {
+				return manager.response(common.RESERVATION_INCOMPATIBLE, "client "+clientID+" is rejected")
+			}
+		}
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	missing := map[string]bool{}
+	for _, clientID := range clients {
+		if _, ok := manager.slots[clientID]; !ok {
+			missing[clientID] = true
+		}
+	}
+
+	if len(missing) > 0 && manager.draining {
+		return manager.responseLocked(common.RESERVATION_DRAINING, "server is draining")
+	}
+
+	if len(manager.slots)+len(missing) > manager.options.Capacity {
+		return manager.responseLocked(common.RESERVATION_FULL, "not enough free slots")
+	}
+
+	for clientID := range missing {
+		manager.reserveLocked(clientID)
+	}
+
+	response := manager.responseLocked(common.RESERVATION_RESERVED, "")
+	response.TTLMs = manager.statusLocked(manager.slots[request.ClientID]).TTLMs
+	return response
+}
+
+// Drain rejects new reservations, so Maker stops sending clients to server before it's
+// stopped. Reserved and joined slots are kept
+func (manager *SlotManager) Drain() {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.draining = true
+}
+
+// Join confirms reservation of client that connected to server, returns false if client
+// has no reservation. Game code should call it before accepting the player
+func (manager *SlotManager) Join(clientID string) bool {
+	manager.mutex.Lock()
+	reserved, ok := manager.slots[clientID]
+	joined := ok && !reserved.joined
+	if joined {
+		reserved.joined = true
+		reserved.timer.Stop()
+	}
+	manager.mutex.Unlock()
+
+	if joined && manager.options.OnJoin != nil {
+		manager.options.OnJoin(clientID)
+	}
+
+	return ok
+}
+
+// Leave frees slot of joined player, returns false if client didn't join
+func (manager *SlotManager) Leave(clientID string) bool {
+	manager.mutex.Lock()
+	reserved, ok := manager.slots[clientID]
+	ok = ok && reserved.joined
+	if ok {
+		delete(manager.slots, clientID)
+	}
+	manager.mutex.Unlock()
+
+	if ok {
+		manager.released(clientID, RELEASE_LEFT)
+	}
+
+	return ok
+}
+
+// Cancel frees slot of client that didn't join yet, returns false if there is no such reservation
+func (manager *SlotManager) Cancel(clientID string) bool {
+	manager.mutex.Lock()
+	reserved, ok := manager.slots[clientID]
+	ok = ok && !reserved.joined
+	if ok {
+		reserved.timer.Stop()
+		delete(manager.slots, clientID)
+	}
+	manager.mutex.Unlock()
+
+	if ok {
+		manager.released(clientID, RELEASE_CANCELED)
+	}
+
+	return ok
+}
+
+// IsReserved returns true if client has reserved slot or joined the server
+func (manager *SlotManager) IsReserved(clientID string) bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	_, ok := manager.slots[clientID]
+	return ok
+}
+
+// Status returns reservation status reported to Maker and API, false if client has no slot
+func (manager *SlotManager) Status(clientID string) (common.ReservationStatus, bool) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	reserved, ok := manager.slots[clientID]
+	if !ok {
+		return common.ReservationStatus{}, false
+	}
+
+	return manager.statusLocked(reserved), true
+}
+
+// Count returns number of taken slots, both reserved and joined
+func (manager *SlotManager) Count() int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	return len(manager.slots)
+}
+
+func (manager *SlotManager) Capacity() Capacity {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	return Capacity{
+		Total:    manager.options.Capacity,
+		Free:     manager.options.Capacity - len(manager.slots),
+		Metadata: manager.options.Metadata,
+	}
+}
+
+// should be called with locked mutex
+func (manager *SlotManager) reserveLocked(clientID string) {
+	reserved := &slot{expiresAt: time.Now().Add(manager.options.Timeout)}
+	reserved.timer = time.AfterFunc(manager.options.Timeout, func() {
+		manager.expire(clientID, reserved)
+	})
+	manager.slots[clientID] = reserved
+}
+
+// should be called with locked mutex
+func (manager *SlotManager) statusLocked(reserved *slot) common.ReservationStatus {
+	if reserved.joined {
+		return common.ReservationStatus{Joined: true}
+	}
+
+	//reservation that is about to expire is reported with minimal TTL, so it's not taken as unknown
+	ttl := time.Until(reserved.expiresAt).Milliseconds()
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	return common.ReservationStatus{TTLMs: ttl}
+}
+
+func (manager *SlotManager) response(result string, message string) common.ReservationResponse {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	return manager.responseLocked(result, message)
+}
+
+// should be called with locked mutex
+func (manager *SlotManager) responseLocked(result string, message string) common.ReservationResponse {
+	return common.ReservationResponse{
+		Result:  result,
+		Message: message,
+		Slots: &common.SlotInfo{
+			Total: manager.options.Capacity,
+			Free:  manager.options.Capacity - len(manager.slots),
+		},
+	}
+}
+
+// timer can fire after client joined or slot was reserved again, so slot is checked
+func (manager *SlotManager) expire(clientID string, expired *slot) {
+	manager.mutex.Lock()
+	reserved, ok := manager.slots[clientID]
+	ok = ok && reserved == expired && !reserved.joined
+	if ok {
+		delete(manager.slots, clientID)
+	}
+	manager.mutex.Unlock()
+
+	if ok {
+		manager.released(clientID, RELEASE_EXPIRED)
+	}
+}
+
+// hooks are called without lock, so game code can use manager in them
+func (manager *SlotManager) released(clientID string, reason string) {
+	if manager.options.OnRelease != nil {
+		manager.options.OnRelease(clientID, reason)
+	}
+}
+
+type SlotManagerOptions struct {
+	//number of clients server can hold
+	Capacity int
+	//reserved slot is freed if client doesn't join in this time
+	Timeout time.Duration
+	//reported in GET /capacity
+	Metadata map[string]string
+
+	//called before slots are reserved with attributes of request, reservation is rejected
+	//as incompatible if it returns false, e.g. if server runs other game mode
+	Compatible func(request common.ReservationRequest) bool
+	//called before slot is reserved for client and each party member, reservation is
+	//rejected if it returns false
+	OnReserve func(clientID string) bool
+	//called after client joined
+	OnJoin func(clientID string)
+	//called after slot is freed with one of RELEASE_* reasons
+	OnRelease func(clientID string, reason string)
+}
+
+func CreateSlotManager(options SlotManagerOptions) *SlotManager {
+	if options.Timeout <= 0 {
+		options.Timeout = DEFAULT_RESERVATION_TIMEOUT
+	}
+
+	return &SlotManager{options: options, slots: map[string]*slot{}}
+}
@@ -0,0 +1,104 @@
+package reservation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/st-matskevich/go-matchmaker/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlotManager(t *testing.T) {
+	released := make(chan string, 10)
+	manager := CreateSlotManager(SlotManagerOptions{
+		Capacity: 2,
+		Timeout:  time.Hour,
+		OnReserve: func(clientID string) bool {
+			return clientID != "banned"
+		},
+		OnRelease: func(clientID string, reason string) {
+			released <- clientID + ":" + reason
+		},
+	})
+
+	assert.False(t, manager.Reserve("banned"))
+	assert.True(t, manager.Reserve("client1"))
+	//repeated reservation doesn't take another slot
+	assert.True(t, manager.Reserve("client1"))
+	assert.True(t, manager.Reserve("client2"))
+	assert.False(t, manager.Reserve("client3"))
+	assert.Equal(t, Capacity{Total: 2, Free: 0}, manager.Capacity())
+
+	status, ok := manager.Status("client1")
+	assert.True(t, ok)
+	assert.False(t, status.Joined)
+	assert.Greater(t, status.TTLMs, int64(0))
+
+	assert.True(t, manager.Join("client1"))
+	assert.False(t, manager.Join("client3"))
+	status, ok = manager.Status("client1")
+	assert.True(t, ok)
+	assert.Equal(t, common.ReservationStatus{Joined: true}, status)
+	//joined player is not canceled, only left
+	assert.False(t, manager.Cancel("client1"))
+	assert.False(t, manager.Leave("client2"))
+
+	assert.True(t, manager.Cancel("client2"))
+	assert.Equal(t, "client2:"+RELEASE_CANCELED, <-released)
+	assert.True(t, manager.Leave("client1"))
+	assert.Equal(t, "client1:"+RELEASE_LEFT, <-released)
+	assert.Equal(t, 0, manager.Count())
+}
+
+func TestReservationExpiry(t *testing.T) {
+	released := make(chan string, 10)
+	manager := CreateSlotManager(SlotManagerOptions{
+		Capacity: 2,
+		Timeout:  50 * time.Millisecond,
+		OnRelease: func(clientID string, reason string) {
+			released <- clientID + ":" + reason
+		},
+	})
+
+	assert.True(t, manager.Reserve("client1"))
+	assert.True(t, manager.Reserve("client2"))
+	assert.True(t, manager.Join("client2"))
+
+	assert.Equal(t, "client1:"+RELEASE_EXPIRED, <-released)
+	assert.False(t, manager.IsReserved("client1"))
+
+	//joined player keeps the slot after timeout
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, manager.IsReserved("client2"))
+	assert.Len(t, released, 0)
+}
+
+func TestReserveRequest(t *testing.T) {
+	manager := CreateSlotManager(SlotManagerOptions{
+		Capacity: 3,
+		Timeout:  time.Hour,
+		Compatible: func(request common.ReservationRequest) bool {
+			return request.Attributes["mode"] != "duel"
+		},
+	})
+
+	response := manager.ReserveRequest(common.ReservationRequest{ClientID: "client1", Attributes: map[string]string{"mode": "duel"}})
+	assert.Equal(t, common.RESERVATION_INCOMPATIBLE, response.Result)
+
+	response = manager.ReserveRequest(common.ReservationRequest{ClientID: "client1", Party: []string{"client2"}})
+	assert.Equal(t, common.RESERVATION_RESERVED, response.Result)
+	assert.Equal(t, &common.SlotInfo{Total: 3, Free: 1}, response.Slots)
+	assert.Greater(t, response.TTLMs, int64(0))
+	assert.True(t, manager.IsReserved("client2"))
+
+	//party is reserved together, so no slot is taken if it doesn't fit
+	response = manager.ReserveRequest(common.ReservationRequest{ClientID: "client3", Party: []string{"client4"}})
+	assert.Equal(t, common.RESERVATION_FULL, response.Result)
+	assert.False(t, manager.IsReserved("client3"))
+
+	manager.Drain()
+	response = manager.ReserveRequest(common.ReservationRequest{ClientID: "client3"})
+	assert.Equal(t, common.RESERVATION_DRAINING, response.Result)
+	//existing reservation is still confirmed
+	assert.True(t, manager.Reserve("client1"))
+}